@@ -1,7 +1,10 @@
 module github.com/oleewere/go-solr-client
 
+go 1.23
+
 require (
 	github.com/go-ini/ini v1.39.2
+	github.com/go-zookeeper/zk v1.0.3
 	github.com/gopherjs/gopherjs v0.0.0-20181103185306-d547d1d9531e // indirect
 	github.com/hashicorp/go-uuid v1.0.0 // indirect
 	github.com/jcmturner/gofork v0.0.0-20180107083740-2aebee971930 // indirect
@@ -58,10 +58,18 @@ func main() {
 	}
 
 	if _, err := os.Stat(iniFileLocation); os.IsNotExist(err) {
-		solr.GenerateIniFile(iniFileLocation)
+		log.Println("Generating new INI config file: " + iniFileLocation)
+		if err := solr.GenerateIniFile(iniFileLocation); err != nil {
+			log.Fatal(err)
+		}
 		os.Exit(0)
 	}
 	log.Println("Starting Solr Client ...")
-	solrConfig, sshConfig := solr.GenerateSolrConfig(iniFileLocation)
-	solr.GenerateSolrData(&solrConfig, &sshConfig, iniFileLocation)
+	solrConfig, sshConfig, err := solr.GenerateSolrConfig(iniFileLocation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := solr.GenerateSolrData(&solrConfig, &sshConfig, iniFileLocation); err != nil {
+		log.Fatal(err)
+	}
 }
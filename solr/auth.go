@@ -0,0 +1,246 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpirySkew is subtracted from the expiry of refreshed tokens, so a token is not sent right before it expires
+const tokenExpirySkew = 30 * time.Second
+
+// Authenticator adds authentication details (e.g. Authorization header) to Solr requests
+type Authenticator interface {
+	Authenticate(ctx context.Context, request *http.Request) error
+}
+
+// TokenProvider provides bearer tokens (e.g. JWT) for the bearer token authenticator
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// BasicAuthenticator authenticates requests with basic auth credentials (Solr BasicAuthPlugin)
+type BasicAuthenticator struct {
+	username string
+	password string
+}
+
+// KerberosAuthenticator authenticates requests with SPNEGO (Solr KerberosPlugin)
+type KerberosAuthenticator struct {
+	session *kerberosSession
+}
+
+// BearerTokenAuthenticator authenticates requests with a bearer token (Solr JWTAuthPlugin)
+type BearerTokenAuthenticator struct {
+	provider TokenProvider
+}
+
+// StaticTokenProvider provides the same token for every request
+type StaticTokenProvider struct {
+	token string
+}
+
+// FileTokenProvider provides the token from a file, the file is read again if it has been changed since the last read
+type FileTokenProvider struct {
+	path    string
+	mutex   sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// RefreshTokenProvider provides tokens from a user supplied refresh function, the token is cached until it expires
+type RefreshTokenProvider struct {
+	refresh func(ctx context.Context) (string, time.Time, error)
+	mutex   sync.Mutex
+	token   string
+	expiry  time.Time
+}
+
+// JWTConfig holds bearer token (JWT) related configurations
+type JWTConfig struct {
+	token     string
+	tokenFile string
+}
+
+// ClientOption customizes a Solr client during creation
+type ClientOption func(solrClient *SolrClient)
+
+// WithAuthenticator set the authenticator of the Solr client, it overrides the authentication method of the security config
+func WithAuthenticator(authenticator Authenticator) ClientOption {
+	return func(solrClient *SolrClient) {
+		solrClient.authenticator = authenticator
+	}
+}
+
+// NewBasicAuthenticator create an authenticator for basic auth credentials
+func NewBasicAuthenticator(username string, password string) *BasicAuthenticator {
+	return &BasicAuthenticator{username: username, password: password}
+}
+
+// Authenticate add basic auth header to the request
+func (authenticator *BasicAuthenticator) Authenticate(ctx context.Context, request *http.Request) error {
+	request.SetBasicAuth(authenticator.username, authenticator.password)
+	return nil
+}
+
+// Authenticate add SPNEGO header to the request, header generation is abandoned if the context is done before it finishes
+func (authenticator *KerberosAuthenticator) Authenticate(ctx context.Context, request *http.Request) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	headerRequest := request.Clone(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- authenticator.session.setSPNEGOHeader(headerRequest)
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Authorization", headerRequest.Header.Get("Authorization"))
+		return nil
+	}
+}
+
+// NewBearerTokenAuthenticator create an authenticator that sends tokens of the provider as bearer tokens
+func NewBearerTokenAuthenticator(provider TokenProvider) *BearerTokenAuthenticator {
+	return &BearerTokenAuthenticator{provider: provider}
+}
+
+// Authenticate add bearer token authorization header to the request
+func (authenticator *BearerTokenAuthenticator) Authenticate(ctx context.Context, request *http.Request) error {
+	token, err := authenticator.provider.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get bearer token: %v", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// NewStaticTokenProvider create a token provider for a static token
+func NewStaticTokenProvider(token string) *StaticTokenProvider {
+	return &StaticTokenProvider{token: token}
+}
+
+// Token returns the static token
+func (provider *StaticTokenProvider) Token(ctx context.Context) (string, error) {
+	return provider.token, nil
+}
+
+// NewFileTokenProvider create a token provider that reads the token from a file
+func NewFileTokenProvider(path string) *FileTokenProvider {
+	return &FileTokenProvider{path: path}
+}
+
+// Token returns the token from the file, the file is read again only if its modification time or size has been changed
+func (provider *FileTokenProvider) Token(ctx context.Context) (string, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	fileInfo, err := os.Stat(provider.path)
+	if err != nil {
+		return "", err
+	}
+	if len(provider.token) != 0 && fileInfo.ModTime().Equal(provider.modTime) && fileInfo.Size() == provider.size {
+		return provider.token, nil
+	}
+	content, err := ioutil.ReadFile(provider.path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(content))
+	if len(token) == 0 {
+		return "", fmt.Errorf("token file is empty: %s", provider.path)
+	}
+	provider.token = token
+	provider.modTime = fileInfo.ModTime()
+	provider.size = fileInfo.Size()
+	return token, nil
+}
+
+// NewRefreshTokenProvider create a token provider that calls the refresh function for a new token (and its expiry) when the cached one expires,
+// zero expiry time means the token does not expire
+func NewRefreshTokenProvider(refresh func(ctx context.Context) (string, time.Time, error)) *RefreshTokenProvider {
+	return &RefreshTokenProvider{refresh: refresh}
+}
+
+// Token returns the cached token, or a new one from the refresh function
+func (provider *RefreshTokenProvider) Token(ctx context.Context) (string, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if len(provider.token) != 0 && (provider.expiry.IsZero() || time.Now().Add(tokenExpirySkew).Before(provider.expiry)) {
+		return provider.token, nil
+	}
+	token, expiry, err := provider.refresh(ctx)
+	if err != nil {
+		return "", err
+	}
+	provider.token = token
+	provider.expiry = expiry
+	return token, nil
+}
+
+// InitBearerTokenSecurityConfig set security config with a static bearer token (JWT)
+func InitBearerTokenSecurityConfig(token string) SecurityConfig {
+	securityConfig := SecurityConfig{}
+	securityConfig.WithBearerToken(token)
+	return securityConfig
+}
+
+// WithBearerToken enable bearer token (JWT) authentication with a static token
+func (securityConfig *SecurityConfig) WithBearerToken(token string) *SecurityConfig {
+	securityConfig.jwtEnabled = true
+	securityConfig.jwtConfig = &JWTConfig{token: token}
+	return securityConfig
+}
+
+// WithBearerTokenFile enable bearer token (JWT) authentication with a token file, that is read again on change
+func (securityConfig *SecurityConfig) WithBearerTokenFile(tokenFile string) *SecurityConfig {
+	securityConfig.jwtEnabled = true
+	securityConfig.jwtConfig = &JWTConfig{tokenFile: tokenFile}
+	return securityConfig
+}
+
+// JWTEnabled returns true if bearer token (JWT) authentication is enabled
+func (securityConfig *SecurityConfig) JWTEnabled() bool {
+	return securityConfig.jwtEnabled
+}
+
+// newAuthenticator create the authenticator for the enabled authentication method of the security config (nil if none is enabled),
+// kerberos authentication uses the kerberos session of the Solr client
+func newAuthenticator(securityConfig *SecurityConfig, kerberosSession *kerberosSession) Authenticator {
+	switch {
+	case securityConfig.basicAuthEnabled && securityConfig.basicAuthConfig != nil:
+		return NewBasicAuthenticator(securityConfig.basicAuthConfig.username, securityConfig.basicAuthConfig.password)
+	case securityConfig.kerberosEnabled && kerberosSession != nil:
+		return &KerberosAuthenticator{session: kerberosSession}
+	case securityConfig.jwtEnabled && securityConfig.jwtConfig != nil:
+		if len(securityConfig.jwtConfig.tokenFile) != 0 {
+			return NewBearerTokenAuthenticator(NewFileTokenProvider(securityConfig.jwtConfig.tokenFile))
+		}
+		return NewBearerTokenAuthenticator(NewStaticTokenProvider(securityConfig.jwtConfig.token))
+	}
+	return nil
+}
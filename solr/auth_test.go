@@ -0,0 +1,191 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTokenFile(t *testing.T, path string, content string, modTime time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("cannot write token file: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("cannot set modification time: %v", err)
+	}
+}
+
+func TestFileTokenProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeTokenFile(t, path, "token-1\n", modTime)
+	provider := NewFileTokenProvider(path)
+	if token, err := provider.Token(context.Background()); err != nil || token != "token-1" {
+		t.Fatalf("unexpected token: %q, %v", token, err)
+	}
+
+	// same modification time and size: the cached token is used
+	writeTokenFile(t, path, "token-2\n", modTime)
+	if token, err := provider.Token(context.Background()); err != nil || token != "token-1" {
+		t.Fatalf("expected the cached token, got: %q, %v", token, err)
+	}
+
+	writeTokenFile(t, path, "token-2\n", modTime.Add(time.Minute))
+	if token, err := provider.Token(context.Background()); err != nil || token != "token-2" {
+		t.Fatalf("expected the token to be read again, got: %q, %v", token, err)
+	}
+
+	writeTokenFile(t, path, "rotated-token-3", modTime.Add(time.Minute))
+	if token, err := provider.Token(context.Background()); err != nil || token != "rotated-token-3" {
+		t.Fatalf("expected the token to be read again after a size change, got: %q, %v", token, err)
+	}
+}
+
+func TestFileTokenProviderErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"empty": "", "whitespace": " \n\t\n"} {
+		path := filepath.Join(dir, name)
+		writeTokenFile(t, path, content, time.Now())
+		if token, err := NewFileTokenProvider(path).Token(context.Background()); err == nil || !strings.Contains(err.Error(), "token file is empty") {
+			t.Errorf("%s: expected empty token file error, got: %q, %v", name, token, err)
+		}
+	}
+	if _, err := NewFileTokenProvider(filepath.Join(dir, "missing")).Token(context.Background()); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got: %v", err)
+	}
+}
+
+func TestRefreshTokenProvider(t *testing.T) {
+	var calls int
+	var expiry time.Time
+	provider := NewRefreshTokenProvider(func(ctx context.Context) (string, time.Time, error) {
+		calls++
+		return "token-" + strconv.Itoa(calls), expiry, nil
+	})
+	assertToken := func(expectedToken string, expectedCalls int) {
+		t.Helper()
+		token, err := provider.Token(context.Background())
+		if err != nil || token != expectedToken || calls != expectedCalls {
+			t.Fatalf("expected %s after %d refreshes, got: %q after %d refreshes, %v", expectedToken, expectedCalls, token, calls, err)
+		}
+	}
+
+	expiry = time.Now().Add(time.Hour)
+	assertToken("token-1", 1)
+	assertToken("token-1", 1)
+
+	// the token expires within the skew, so it is refreshed on every call
+	provider.expiry = time.Now().Add(tokenExpirySkew - time.Second)
+	assertToken("token-2", 2)
+	provider.expiry = time.Now().Add(tokenExpirySkew + time.Minute)
+	assertToken("token-2", 2)
+	provider.expiry = time.Now().Add(-time.Minute)
+	assertToken("token-3", 3)
+
+	// zero expiry: the token never expires
+	expiry = time.Time{}
+	provider.expiry = time.Now()
+	assertToken("token-4", 4)
+	assertToken("token-4", 4)
+}
+
+func TestRefreshTokenProviderError(t *testing.T) {
+	refreshErr := errors.New("identity provider is unavailable")
+	fail := true
+	provider := NewRefreshTokenProvider(func(ctx context.Context) (string, time.Time, error) {
+		if fail {
+			return "", time.Time{}, refreshErr
+		}
+		return "token", time.Now().Add(time.Hour), nil
+	})
+	if _, err := provider.Token(context.Background()); err != refreshErr {
+		t.Fatalf("expected the refresh error, got: %v", err)
+	}
+	request, _ := http.NewRequest(http.MethodGet, "http://localhost:8983/solr", nil)
+	err := NewBearerTokenAuthenticator(provider).Authenticate(context.Background(), request)
+	if err == nil || !strings.Contains(err.Error(), refreshErr.Error()) || len(request.Header.Get("Authorization")) != 0 {
+		t.Fatalf("expected the refresh error from the authenticator, got: %v", err)
+	}
+	fail = false
+	if err := NewBearerTokenAuthenticator(provider).Authenticate(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if header := request.Header.Get("Authorization"); header != "Bearer token" {
+		t.Fatalf("unexpected authorization header: %s", header)
+	}
+}
+
+func TestJWTIniConfig(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	writeTokenFile(t, tokenFile, "file-token", time.Now())
+	testCases := []struct {
+		name     string
+		security string
+		expected string
+	}{
+		{"static token", "jwtEnabled = true\njwtToken = static-token\n", "Bearer static-token"},
+		{"token file", "jwtEnabled = true\njwtToken = static-token\njwtTokenFile = " + tokenFile + "\n", "Bearer file-token"},
+		{"disabled", "jwtEnabled = false\njwtToken = static-token\n", ""},
+	}
+	for _, testCase := range testCases {
+		iniFile := filepath.Join(dir, "solr.ini")
+		content := "[security]\n" + testCase.security + "[solr]\nurl = http://localhost:8983\ncollection = test\n"
+		if err := ioutil.WriteFile(iniFile, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		solrConfig, _, err := GenerateSolrConfig(iniFile)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", testCase.name, err)
+		}
+		authenticator := newAuthenticator(solrConfig.SecurityConfig, nil)
+		if len(testCase.expected) == 0 {
+			if authenticator != nil {
+				t.Errorf("%s: expected no authenticator, got: %T", testCase.name, authenticator)
+			}
+			continue
+		}
+		if _, ok := authenticator.(*BearerTokenAuthenticator); !ok {
+			t.Fatalf("%s: expected bearer token authenticator, got: %T", testCase.name, authenticator)
+		}
+		request, _ := http.NewRequest(http.MethodGet, "http://localhost:8983/solr", nil)
+		if err := authenticator.Authenticate(context.Background(), request); err != nil {
+			t.Fatalf("%s: unexpected error: %v", testCase.name, err)
+		}
+		if header := request.Header.Get("Authorization"); header != testCase.expected {
+			t.Errorf("%s: expected %s, got %s", testCase.name, testCase.expected, header)
+		}
+	}
+
+	iniFile := filepath.Join(dir, "invalid.ini")
+	ioutil.WriteFile(iniFile, []byte("[security]\njwtEnabled = true\n[solr]\nurl = http://localhost:8983\n"), 0600)
+	if _, _, err := GenerateSolrConfig(iniFile); err == nil || !strings.Contains(err.Error(), "neither token nor token file") {
+		t.Fatalf("expected missing token error, got: %v", err)
+	}
+	ioutil.WriteFile(iniFile, []byte("[security]\njwtEnabled = true\njwtToken = t\nbasicAuthEnabled = true\nbasicAuthUsername = solr\n"), 0600)
+	if _, _, err := GenerateSolrConfig(iniFile); err == nil || !strings.Contains(err.Error(), "only one authentication method") {
+		t.Fatalf("expected multiple authentication methods error, got: %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState represents the state of a circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every request until the open duration elapses
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of trial requests through, to decide whether the circuit can be closed again
+	CircuitHalfOpen
+)
+
+// CircuitBreakerConfig holds circuit breaker related configurations, a circuit opens if the failure rate or the slow call rate
+// reaches its threshold in the current window (after the minimum number of requests)
+type CircuitBreakerConfig struct {
+	FailureRateThreshold  float64
+	SlowCallThreshold     time.Duration
+	SlowCallRateThreshold float64
+	MinimumRequests       int
+	WindowSize            time.Duration
+	OpenDuration          time.Duration
+	HalfOpenMaxRequests   int
+}
+
+// CircuitOpenError is returned without sending the request, if the circuit of the Solr node or collection is open
+type CircuitOpenError struct {
+	Key   string
+	Until time.Time
+}
+
+// circuitBreaker tracks the outcome of the requests for one Solr node or collection
+type circuitBreaker struct {
+	mutex            sync.Mutex
+	key              string
+	config           *CircuitBreakerConfig
+	state            CircuitState
+	openedAt         time.Time
+	windowStart      time.Time
+	requests         int
+	failures         int
+	slowCalls        int
+	halfOpenInFlight int
+	halfOpenSuccess  int
+}
+
+// circuitBreakers holds the circuit breakers of a Solr client by key (node:<url> or collection:<name>)
+type circuitBreakers struct {
+	mutex    sync.Mutex
+	config   *CircuitBreakerConfig
+	breakers map[string]*circuitBreaker
+}
+
+// String returns the name of the circuit state
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Error returns the string representation of the circuit open error
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open for %s until %s", e.Key, e.Until.Format(time.RFC3339))
+}
+
+// IsCircuitOpen returns true if the request was rejected by an open circuit breaker
+func IsCircuitOpen(err error) bool {
+	var circuitOpenError *CircuitOpenError
+	return errors.As(err, &circuitOpenError)
+}
+
+// DefaultCircuitBreakerConfig create a circuit breaker config that opens the circuit for 30 seconds if at least half of the requests
+// (or 80% of the requests are slower than 10 seconds) failed out of at least 20 requests in a 60 seconds window
+func DefaultCircuitBreakerConfig() *CircuitBreakerConfig {
+	return &CircuitBreakerConfig{
+		FailureRateThreshold:  0.5,
+		SlowCallThreshold:     10 * time.Second,
+		SlowCallRateThreshold: 0.8,
+		MinimumRequests:       20,
+		WindowSize:            60 * time.Second,
+		OpenDuration:          30 * time.Second,
+		HalfOpenMaxRequests:   3,
+	}
+}
+
+func newCircuitBreakers(config *CircuitBreakerConfig) *circuitBreakers {
+	return &circuitBreakers{config: config, breakers: make(map[string]*circuitBreaker)}
+}
+
+func nodeCircuitKey(nodeUrl string) string {
+	return "node:" + nodeUrl
+}
+
+func collectionCircuitKey(collection string) string {
+	return "collection:" + collection
+}
+
+// get returns the circuit breaker for the key, it is created on first use
+func (breakers *circuitBreakers) get(key string) *circuitBreaker {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+	breaker, ok := breakers.breakers[key]
+	if !ok {
+		breaker = &circuitBreaker{key: key, config: breakers.config, windowStart: time.Now()}
+		breakers.breakers[key] = breaker
+	}
+	return breaker
+}
+
+// remove drop the circuit breakers of the keys (e.g. nodes that are no longer part of the cluster)
+func (breakers *circuitBreakers) remove(keys ...string) {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+	for _, key := range keys {
+		delete(breakers.breakers, key)
+	}
+}
+
+// states returns the current state of every circuit breaker
+func (breakers *circuitBreakers) states() map[string]CircuitState {
+	breakers.mutex.Lock()
+	all := make([]*circuitBreaker, 0, len(breakers.breakers))
+	for _, breaker := range breakers.breakers {
+		all = append(all, breaker)
+	}
+	breakers.mutex.Unlock()
+	states := make(map[string]CircuitState)
+	for _, breaker := range all {
+		states[breaker.key] = breaker.currentState()
+	}
+	return states
+}
+
+// currentState returns the state of the breaker (open breakers turn to half-open after the open duration)
+func (breaker *circuitBreaker) currentState() CircuitState {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.refresh(time.Now())
+	return breaker.state
+}
+
+// refresh move an open breaker to half-open after the open duration, and start a new window if the current one has elapsed
+func (breaker *circuitBreaker) refresh(now time.Time) {
+	if breaker.state == CircuitOpen && now.Sub(breaker.openedAt) >= breaker.config.OpenDuration {
+		breaker.state = CircuitHalfOpen
+		breaker.halfOpenInFlight = 0
+		breaker.halfOpenSuccess = 0
+	}
+	if breaker.state == CircuitClosed && breaker.config.WindowSize > 0 && now.Sub(breaker.windowStart) >= breaker.config.WindowSize {
+		breaker.resetWindow(now)
+	}
+}
+
+func (breaker *circuitBreaker) resetWindow(now time.Time) {
+	breaker.windowStart = now
+	breaker.requests = 0
+	breaker.failures = 0
+	breaker.slowCalls = 0
+}
+
+// allow returns CircuitOpenError if the request cannot be sent, in half-open state only a limited number of trial requests are allowed
+func (breaker *circuitBreaker) allow() error {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	now := time.Now()
+	breaker.refresh(now)
+	switch breaker.state {
+	case CircuitOpen:
+		return &CircuitOpenError{Key: breaker.key, Until: breaker.openedAt.Add(breaker.config.OpenDuration)}
+	case CircuitHalfOpen:
+		maxRequests := breaker.config.HalfOpenMaxRequests
+		if maxRequests <= 0 {
+			maxRequests = 1
+		}
+		if breaker.halfOpenInFlight+breaker.halfOpenSuccess >= maxRequests {
+			return &CircuitOpenError{Key: breaker.key, Until: now}
+		}
+		breaker.halfOpenInFlight++
+	}
+	return nil
+}
+
+// record register the outcome of an allowed request
+func (breaker *circuitBreaker) record(failure bool, latency time.Duration) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	now := time.Now()
+	slow := breaker.config.SlowCallThreshold > 0 && latency >= breaker.config.SlowCallThreshold
+	switch breaker.state {
+	case CircuitHalfOpen:
+		if breaker.halfOpenInFlight > 0 {
+			breaker.halfOpenInFlight--
+		}
+		if failure || slow {
+			breaker.open(now)
+			return
+		}
+		breaker.halfOpenSuccess++
+		maxRequests := breaker.config.HalfOpenMaxRequests
+		if maxRequests <= 0 {
+			maxRequests = 1
+		}
+		if breaker.halfOpenSuccess >= maxRequests {
+			breaker.state = CircuitClosed
+			breaker.resetWindow(now)
+		}
+	case CircuitClosed:
+		breaker.refresh(now)
+		breaker.requests++
+		if failure {
+			breaker.failures++
+		}
+		if slow {
+			breaker.slowCalls++
+		}
+		if breaker.requests < breaker.config.MinimumRequests {
+			return
+		}
+		failureRate := float64(breaker.failures) / float64(breaker.requests)
+		slowCallRate := float64(breaker.slowCalls) / float64(breaker.requests)
+		if (breaker.config.FailureRateThreshold > 0 && failureRate >= breaker.config.FailureRateThreshold) ||
+			(breaker.config.SlowCallRateThreshold > 0 && slowCallRate >= breaker.config.SlowCallRateThreshold) {
+			breaker.open(now)
+		}
+	}
+}
+
+// release give back a half-open trial slot of a request that was allowed but not sent
+func (breaker *circuitBreaker) release() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if breaker.state == CircuitHalfOpen && breaker.halfOpenInFlight > 0 {
+		breaker.halfOpenInFlight--
+	}
+}
+
+// recordOutcome register the outcome of an allowed request, the slot is given back if the request was cancelled
+// or it was rejected by another circuit breaker (e.g. every node circuit of a collection is open)
+func (breaker *circuitBreaker) recordOutcome(ctx context.Context, err error, latency time.Duration) {
+	if ctx.Err() != nil || IsCircuitOpen(err) {
+		breaker.release()
+		return
+	}
+	breaker.record(isCircuitFailure(err), latency)
+}
+
+func (breaker *circuitBreaker) open(now time.Time) {
+	breaker.state = CircuitOpen
+	breaker.openedAt = now
+	breaker.halfOpenInFlight = 0
+	breaker.halfOpenSuccess = 0
+}
+
+// isCircuitFailure returns true if the error should count as a failure for the circuit breaker (connection error, 5xx or 429 response)
+func isCircuitFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isNodeFailure(err) {
+		return true
+	}
+	solrError := AsSolrError(err)
+	return solrError != nil && solrError.HTTPStatus == http.StatusTooManyRequests
+}
+
+// CircuitBreakerStates returns the state of the circuit breakers by key (node:<url> or collection:<name>), nil if circuit breaking is disabled
+func (solrClient *SolrClient) CircuitBreakerStates() map[string]CircuitState {
+	if solrClient.breakers == nil {
+		return nil
+	}
+	return solrClient.breakers.states()
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testCircuitBreakerConfig() *CircuitBreakerConfig {
+	return &CircuitBreakerConfig{FailureRateThreshold: 0.5, MinimumRequests: 2, WindowSize: time.Minute, OpenDuration: time.Minute,
+		HalfOpenMaxRequests: 1}
+}
+
+func TestCircuitBreakerRecordOutcome(t *testing.T) {
+	nodeOpenErr := &CircuitOpenError{Key: nodeCircuitKey("http://solr1:8983/solr"), Until: time.Now().Add(time.Minute)}
+	testCases := []struct {
+		name     string
+		errs     []error
+		expected CircuitState
+	}{
+		{"successes", []error{nil, nil, nil}, CircuitClosed},
+		{"failures", []error{&SolrError{HTTPStatus: http.StatusServiceUnavailable}, &connectionError{err: errors.New("EOF")}}, CircuitOpen},
+		{"client errors are not failures", []error{&SolrError{HTTPStatus: http.StatusBadRequest}, &SolrError{HTTPStatus: http.StatusNotFound}}, CircuitClosed},
+		{"open node circuits are not counted", []error{nodeOpenErr, nodeOpenErr, nodeOpenErr, &SolrError{HTTPStatus: http.StatusServiceUnavailable}}, CircuitClosed},
+	}
+	for _, testCase := range testCases {
+		breaker := newCircuitBreakers(testCircuitBreakerConfig()).get(collectionCircuitKey("test"))
+		for _, err := range testCase.errs {
+			if allowErr := breaker.allow(); allowErr != nil {
+				t.Fatalf("%s: unexpected open circuit: %v", testCase.name, allowErr)
+			}
+			breaker.recordOutcome(context.Background(), err, time.Millisecond)
+		}
+		if state := breaker.currentState(); state != testCase.expected {
+			t.Errorf("%s: expected %s circuit, got %s", testCase.name, testCase.expected, state)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenSlotReleased(t *testing.T) {
+	breaker := newCircuitBreakers(testCircuitBreakerConfig()).get(collectionCircuitKey("test"))
+	breaker.open(time.Now().Add(-time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, testCase := range []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{"cancelled request", ctx, context.Canceled},
+		{"open node circuit", context.Background(), &CircuitOpenError{Key: nodeCircuitKey("http://solr1:8983/solr")}},
+	} {
+		if err := breaker.allow(); err != nil {
+			t.Fatalf("%s: the trial request should be allowed: %v", testCase.name, err)
+		}
+		breaker.recordOutcome(testCase.ctx, testCase.err, time.Millisecond)
+		if state := breaker.currentState(); state != CircuitHalfOpen {
+			t.Fatalf("%s: expected half-open circuit, got %s", testCase.name, state)
+		}
+	}
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("the released trial slot should be available: %v", err)
+	}
+	breaker.recordOutcome(context.Background(), nil, time.Millisecond)
+	if state := breaker.currentState(); state != CircuitClosed {
+		t.Fatalf("expected closed circuit after a successful trial, got %s", state)
+	}
+}
+
+func TestRefreshClusterStateRemovesNodeBreakers(t *testing.T) {
+	server := newClusterStatusServer(t)
+	otherNode := "127.0.0.1:1_solr"
+	server.setState(testClusterState([]string{server.nodeName(), otherNode}, ReplicaActive, server.nodeName(), otherNode))
+	solrConfig := &SolrConfig{Url: server.URL, SolrUrlContext: "/solr", Collection: "test", CloudConfig: &CloudConfig{},
+		CircuitBreakerConfig: testCircuitBreakerConfig()}
+	solrClient, err := NewSolrClient(solrConfig)
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	defer solrClient.Close()
+	serverKey := nodeCircuitKey(server.URL + "/solr")
+	otherKey := nodeCircuitKey("http://127.0.0.1:1/solr")
+	for _, key := range []string{serverKey, otherKey, collectionCircuitKey("test")} {
+		solrClient.breakers.get(key)
+	}
+
+	server.setState(testClusterState([]string{server.nodeName()}, ReplicaActive, server.nodeName()))
+	if err := solrClient.RefreshClusterState(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	states := solrClient.CircuitBreakerStates()
+	if _, ok := states[otherKey]; ok {
+		t.Fatalf("the breaker of the removed node should be dropped: %v", states)
+	}
+	if _, ok := states[serverKey]; !ok {
+		t.Fatalf("the breaker of the remaining node should be kept: %v", states)
+	}
+	if _, ok := states[collectionCircuitKey("test")]; !ok {
+		t.Fatalf("the breaker of the collection should be kept: %v", states)
+	}
+}
@@ -16,65 +16,97 @@ package solr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"gopkg.in/jcmturner/gokrb5.v4/client"
-	"gopkg.in/jcmturner/gokrb5.v4/config"
-	"gopkg.in/jcmturner/gokrb5.v4/keytab"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // NewSolrClient initialize a new Solr client based on configuration type
-func NewSolrClient(solrConfig *SolrConfig) (*SolrClient, error) {
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			Proxy:                 http.ProxyFromEnvironment,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       30 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ResponseHeaderTimeout: 10 * time.Second,
-		},
+func NewSolrClient(solrConfig *SolrConfig, options ...ClientOption) (*SolrClient, error) {
+	transport, err := newHTTPTransport(solrConfig)
+	if err != nil {
+		return nil, err
 	}
+	httpClient := &http.Client{Transport: transport}
 
-	var securityConfig SecurityConfig
 	if solrConfig.SecurityConfig == nil {
 		solrConfig.SecurityConfig = new(SecurityConfig)
 	}
-
-	if securityConfig.kerberosConfig != nil && len(securityConfig.kerberosConfig.keytab) != 0 {
+	securityConfig := solrConfig.SecurityConfig
+	if securityConfig.kerberosConfig != nil && (len(securityConfig.kerberosConfig.keytab) != 0 || len(securityConfig.kerberosConfig.ccachePath) != 0) {
 		securityConfig.kerberosEnabled = true
 	}
+	if err := securityConfig.Validate(); err != nil {
+		return nil, err
+	}
 
-	if securityConfig.kerberosEnabled {
-		keytabPath := securityConfig.kerberosConfig.keytab
-		principalName := securityConfig.kerberosConfig.principal
-		krb5confPath := securityConfig.kerberosConfig.krb5confPath
-		realm := securityConfig.kerberosConfig.realm
-
-		kt, err := keytab.Load(keytabPath)
-
-		if err != nil {
-			log.Fatal(err)
+	if securityConfig.kerberosEnabled && securityConfig.kerberosConfig == nil {
+		return nil, fmt.Errorf("kerberos is enabled but kerberos config is missing")
+	}
+	solrClient := &SolrClient{httpClient: httpClient, solrConfig: solrConfig,
+		nodes: newNodePool(solrConfig.nodeBaseUrls(), solrConfig.LoadBalancerConfig.Strategy), stop: make(chan struct{}),
+		logger: NopLogger{}, metrics: NopMetrics{}, tracer: NopTracer{}}
+	if solrConfig.CircuitBreakerConfig != nil {
+		solrClient.breakers = newCircuitBreakers(solrConfig.CircuitBreakerConfig)
+	}
+	for _, option := range options {
+		option(solrClient)
+	}
+	if solrClient.logger == nil {
+		solrClient.logger = NopLogger{}
+	}
+	if solrClient.metrics == nil {
+		solrClient.metrics = NopMetrics{}
+	}
+	if solrClient.tracer == nil {
+		solrClient.tracer = NopTracer{}
+	}
+	if solrClient.authenticator == nil {
+		if securityConfig.kerberosEnabled {
+			session, err := newKerberosSession(securityConfig.kerberosConfig, solrClient.logger)
+			if err != nil {
+				return nil, err
+			}
+			solrClient.kerberosSession = session
 		}
-		cfg, err := config.Load(krb5confPath)
-		if err != nil {
-			log.Fatal(err)
+		solrClient.authenticator = newAuthenticator(securityConfig, solrClient.kerberosSession)
+	}
+	solrClient.roundTrip = solrClient.buildRoundTrip()
+	solrClient.reportNodeHealth()
+	if solrConfig.CloudConfig != nil || solrClient.clusterStateProvider != nil {
+		if err := solrClient.initCloud(); err != nil {
+			solrClient.Close()
+			return nil, err
 		}
-		cl := client.NewClientWithKeytab(principalName, realm, kt)
-		cl.WithConfig(cfg)
-		errLogin := cl.Login()
-
-		if errLogin != nil {
-			log.Fatal(errLogin)
+	}
+	if solrClient.nodes.size() > 1 || solrClient.cloud != nil {
+		healthCheckInterval := solrConfig.LoadBalancerConfig.HealthCheckIntervalSeconds
+		if healthCheckInterval <= 0 {
+			healthCheckInterval = defaultHealthCheckIntervalSeconds
 		}
-		solrConfig.SecurityConfig.kerberosConfig.kerberosClient = &cl
+		solrClient.startHealthCheck(time.Duration(healthCheckInterval) * time.Second)
+	}
+	return solrClient, nil
+}
+
+// Close release the resources of the Solr client (e.g. stop kerberos ticket renewal and node health checks)
+func (solrClient *SolrClient) Close() {
+	solrClient.stopOnce.Do(func() {
+		close(solrClient.stop)
+	})
+	if solrClient.kerberosSession != nil {
+		solrClient.kerberosSession.close()
+	}
+	if solrClient.cloud != nil {
+		solrClient.cloud.close()
 	}
-	solrClient := SolrClient{httpClient: httpClient, solrConfig: solrConfig}
-	return &solrClient, nil
 }
 
 // InitSecurityConfig set initial security config on start
@@ -89,6 +121,76 @@ func InitSecurityConfig(krb5Path string, keytabPath string, principal string, re
 	return securityConfig
 }
 
+// InitBasicAuthSecurityConfig set security config with basic auth credentials
+func InitBasicAuthSecurityConfig(username string, password string) SecurityConfig {
+	securityConfig := SecurityConfig{}
+	securityConfig.WithBasicAuth(username, password)
+	return securityConfig
+}
+
+// InitBasicAuthSecurityConfigFromPasswordFile set security config with basic auth credentials, the password is read from a file
+func InitBasicAuthSecurityConfigFromPasswordFile(username string, passwordFile string) (SecurityConfig, error) {
+	password, err := ReadPasswordFile(passwordFile)
+	if err != nil {
+		return SecurityConfig{}, err
+	}
+	return InitBasicAuthSecurityConfig(username, password), nil
+}
+
+// ReadPasswordFile read a password from a file (trailing new lines are trimmed)
+func ReadPasswordFile(passwordFile string) (string, error) {
+	content, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		return "", fmt.Errorf("cannot read password file: %v", err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// WithBasicAuth enable basic authentication with the given credentials
+func (securityConfig *SecurityConfig) WithBasicAuth(username string, password string) *SecurityConfig {
+	securityConfig.basicAuthEnabled = true
+	securityConfig.basicAuthConfig = &BasicAuthConfig{username: username, password: password}
+	return securityConfig
+}
+
+// BasicAuthEnabled returns true if basic authentication is enabled
+func (securityConfig *SecurityConfig) BasicAuthEnabled() bool {
+	return securityConfig.basicAuthEnabled
+}
+
+// KerberosEnabled returns true if kerberos (SPNEGO) authentication is enabled
+func (securityConfig *SecurityConfig) KerberosEnabled() bool {
+	return securityConfig.kerberosEnabled
+}
+
+// Validate check that the security config is consistent (e.g. only one authentication method is enabled)
+func (securityConfig *SecurityConfig) Validate() error {
+	var enabledMethods []string
+	if securityConfig.basicAuthEnabled {
+		enabledMethods = append(enabledMethods, "basic auth")
+	}
+	if securityConfig.kerberosEnabled {
+		enabledMethods = append(enabledMethods, "kerberos")
+	}
+	if securityConfig.jwtEnabled {
+		enabledMethods = append(enabledMethods, "jwt")
+	}
+	if len(enabledMethods) > 1 {
+		return fmt.Errorf("%s are enabled, only one authentication method can be used", strings.Join(enabledMethods, " and "))
+	}
+	if securityConfig.jwtEnabled {
+		if securityConfig.jwtConfig == nil || (len(securityConfig.jwtConfig.token) == 0 && len(securityConfig.jwtConfig.tokenFile) == 0) {
+			return fmt.Errorf("jwt is enabled but neither token nor token file is configured")
+		}
+	}
+	if securityConfig.basicAuthEnabled {
+		if securityConfig.basicAuthConfig == nil || len(securityConfig.basicAuthConfig.username) == 0 {
+			return fmt.Errorf("basic auth is enabled but username is missing")
+		}
+	}
+	return nil
+}
+
 // AddBasicAuthHeader add Auth header with basic auth credentials
 func AddBasicAuthHeader(request *http.Request, solrConfig *SolrConfig) {
 	if solrConfig.SecurityConfig.basicAuthConfig != nil && solrConfig.SecurityConfig.basicAuthEnabled {
@@ -96,13 +198,29 @@ func AddBasicAuthHeader(request *http.Request, solrConfig *SolrConfig) {
 	}
 }
 
-// AddNegotiateHeader add WWW-Authenticate header (SPNEGO) in case of kerberos is enabled
-func AddNegotiateHeader(request *http.Request, solrConfig *SolrConfig) {
-	if solrConfig.SecurityConfig.kerberosConfig != nil && solrConfig.SecurityConfig.kerberosEnabled {
-		spn := ""
-		kcl := solrConfig.SecurityConfig.kerberosConfig.kerberosClient
-		kcl.SetSPNEGOHeader(request, spn)
+// AddNegotiateHeader add WWW-Authenticate header (SPNEGO) in case of kerberos is enabled, returns the error of the login or the header generation
+//
+// Deprecated: it logs in with the kerberos config on every call, use a Solr client (NewSolrClient), that authenticates
+// its requests with one kerberos session and renews its TGT
+func AddNegotiateHeader(request *http.Request, solrConfig *SolrConfig) error {
+	return AddNegotiateHeaderContext(context.Background(), request, solrConfig)
+}
+
+// AddNegotiateHeaderContext add WWW-Authenticate header (SPNEGO) in case of kerberos is enabled,
+// header generation (which can reach the KDC) is abandoned if the context is done before it finishes
+//
+// Deprecated: it logs in with the kerberos config (a keytab login reaches the KDC) on every call, use a Solr client (NewSolrClient),
+// that authenticates its requests with one kerberos session and renews its TGT
+func AddNegotiateHeaderContext(ctx context.Context, request *http.Request, solrConfig *SolrConfig) error {
+	if solrConfig.SecurityConfig.kerberosConfig == nil || !solrConfig.SecurityConfig.kerberosEnabled {
+		return nil
+	}
+	session, err := loginKerberosSession(solrConfig.SecurityConfig.kerberosConfig, nil)
+	if err != nil {
+		return err
 	}
+	authenticator := KerberosAuthenticator{session: session}
+	return authenticator.Authenticate(ctx, request)
 }
 
 // GetSolrCollectionUri gather Solr collection url with url context (if exists) and url suffix
@@ -112,14 +230,22 @@ func GetSolrCollectionUri(solrConfig *SolrConfig, uriSuffix string) string {
 	if len(solrConfig.SolrUrlContext) != 0 {
 		uriPrefix = uriPrefix + "" + solrConfig.SolrUrlContext
 	}
-	uri := fmt.Sprintf("%s/%s/%s", uriPrefix, solrConfig.Collection, uriSuffix)
+	return getSolrCollectionUri(uriPrefix, solrConfig.Collection, uriSuffix)
+}
+
+// getSolrCollectionUri gather Solr collection url for a specific Solr node (node base url contains the url context)
+func getSolrCollectionUri(nodeBaseUrl string, collection string, uriSuffix string) string {
+	uri := fmt.Sprintf("%s/%s/%s", nodeBaseUrl, collection, uriSuffix)
 	return uri
 }
 
 // Update send documents to Solr
 func (solrClient *SolrClient) Update(docs interface{}, parameters *url.Values, commit bool) (bool, *SolrResponseData, error) {
-	httpClient := solrClient.httpClient
-	uri := GetSolrCollectionUri(solrClient.solrConfig, "update")
+	return solrClient.UpdateContext(context.Background(), docs, parameters, commit)
+}
+
+// UpdateContext send documents to Solr, the request is cancelled when the context is done
+func (solrClient *SolrClient) UpdateContext(ctx context.Context, docs interface{}, parameters *url.Values, commit bool) (bool, *SolrResponseData, error) {
 	var buf bytes.Buffer
 	if docs != nil {
 		encoder := json.NewEncoder(&buf)
@@ -127,75 +253,261 @@ func (solrClient *SolrClient) Update(docs interface{}, parameters *url.Values, c
 			return false, nil, err
 		}
 	}
-	request, err := http.NewRequest("POST", uri, &buf)
-	if err != nil {
+	params := url.Values{}
+	if parameters != nil {
+		for key, values := range *parameters {
+			params[key] = append([]string(nil), values...)
+		}
+	}
+	if routed, solrResponse, err := solrClient.routedUpdate(ctx, buf.Bytes(), params, commit); routed {
+		return err == nil, solrResponse, err
+	}
+	if commit {
+		params.Set("commit", "true")
+	}
+
+	var solrResponse SolrResponseData
+	request := &solrRequest{method: "POST", handler: "update", operation: OperationUpdate, params: params, body: buf.Bytes()}
+	if err := solrClient.execute(ctx, request, &solrResponse); err != nil {
 		return false, nil, err
 	}
+	return true, &solrResponse, nil
+}
 
-	request.Header.Add("Content-Type", "application/json")
+// Query get Solr data based on parameters
+func (solrClient *SolrClient) Query(solrQuery *SolrQuery) (bool, *SolrResponseData, error) {
+	return solrClient.QueryContext(context.Background(), solrQuery)
+}
 
-	AddBasicAuthHeader(request, solrClient.solrConfig)
-	AddNegotiateHeader(request, solrClient.solrConfig)
+// QueryContext get Solr data based on parameters, the request is cancelled when the context is done
+func (solrClient *SolrClient) QueryContext(ctx context.Context, solrQuery *SolrQuery) (bool, *SolrResponseData, error) {
+	if solrQuery == nil {
+		solrQuery = CreateSolrQuery()
+	}
 
-	response, err := httpClient.Do(request)
+	solrClient.logger.Debug("Query", LogField("url", GetSolrCollectionUri(solrClient.solrConfig, "select")))
 
-	if err != nil {
+	if err := validateCursorParams(*solrQuery.params, solrClient.solrConfig.uniqueKey()); err != nil {
 		return false, nil, err
 	}
+	var solrResponse SolrResponseData
+	request := &solrRequest{method: "POST", handler: "select", operation: OperationSelect, params: *solrQuery.params, idempotent: true}
+	if err := solrClient.execute(ctx, request, &solrResponse); err != nil {
+		return false, nil, err
+	}
+	return true, &solrResponse, nil
+}
 
-	defer response.Body.Close()
+// solrRequest holds the details of a request against a Solr collection handler (or against a node level path, e.g. admin/collections),
+// if node url is set, the request is sent only to that node (e.g. to a shard leader)
+type solrRequest struct {
+	method     string
+	handler    string
+	path       string
+	nodeUrl    string
+	operation  Operation
+	params     url.Values
+	body       []byte
+	idempotent bool
+}
 
-	bodyBytes, err := ioutil.ReadAll(response.Body)
+// uri gather the url of the request for a specific Solr node
+func (solrRequest *solrRequest) uri(solrConfig *SolrConfig, nodeBaseUrl string) string {
+	if len(solrRequest.path) != 0 {
+		return fmt.Sprintf("%s/%s", nodeBaseUrl, solrRequest.path)
+	}
+	return getSolrCollectionUri(nodeBaseUrl, solrConfig.Collection, solrRequest.handler)
+}
 
-	var solrResponse SolrResponseData
-	jsonErr := json.Unmarshal(bodyBytes, &solrResponse)
-	if jsonErr != nil {
-		return false, nil, jsonErr
+// handlerPath returns the path of the request handler (relative to the url context of the node)
+func (solrRequest *solrRequest) handlerPath(solrConfig *SolrConfig) string {
+	if len(solrRequest.path) != 0 {
+		return solrRequest.path
 	}
+	return fmt.Sprintf("%s/%s", solrConfig.Collection, solrRequest.handler)
+}
 
-	return true, &solrResponse, err
+// execute send a request to a Solr collection handler and decode the JSON response into the result object,
+// every client call (including admin and schema calls) should go through it, so the context is honored
+// during the HTTP round trip, the SPNEGO header generation and the response decoding as well;
+// nodes are marked as dead on connection errors and 5xx responses, idempotent requests are sent to another node right away,
+// and retried with backoff according to the retry policy (if it is configured); requests fail fast if the circuit of the collection is open
+func (solrClient *SolrClient) execute(ctx context.Context, solrRequest *solrRequest, result interface{}) error {
+	ctx, span := solrClient.startSpan(ctx, solrRequest)
+	start := time.Now()
+	err := solrClient.executeWithBreaker(ctx, solrRequest, result)
+	solrClient.metrics.ObserveRequest(solrClient.solrConfig.Collection, solrRequest.operation, time.Since(start), err)
+	endSpan(span, result, err)
+	return err
 }
 
-// Query get Solr data based on parameters
-func (solrClient *SolrClient) Query(solrQuery *SolrQuery) (bool, *SolrResponseData, error) {
-	httpClient := solrClient.httpClient
-	uri := GetSolrCollectionUri(solrClient.solrConfig, "select")
-	var buf bytes.Buffer
-	request, err := http.NewRequest("POST", uri, &buf)
-	if err != nil {
-		return false, nil, err
-	}
+// executeWithBreaker send the request if the circuit of the collection is not open
+func (solrClient *SolrClient) executeWithBreaker(ctx context.Context, solrRequest *solrRequest, result interface{}) error {
+	return solrClient.withCollectionBreaker(ctx, func() (time.Duration, error) {
+		start := time.Now()
+		err := solrClient.executeAttempts(ctx, solrRequest, result)
+		return time.Since(start), err
+	})
+}
 
-	if solrQuery == nil {
-		solrQuery = CreateSolrQuery()
+// withCollectionBreaker run the call if the circuit of the collection is not open, and record its outcome with the latency returned by the call
+func (solrClient *SolrClient) withCollectionBreaker(ctx context.Context, call func() (time.Duration, error)) error {
+	if solrClient.breakers == nil {
+		_, err := call()
+		return err
 	}
+	collectionBreaker := solrClient.breakers.get(collectionCircuitKey(solrClient.solrConfig.Collection))
+	if err := collectionBreaker.allow(); err != nil {
+		return err
+	}
+	latency, err := call()
+	collectionBreaker.recordOutcome(ctx, err, latency)
+	return err
+}
 
-	request.URL.RawQuery = solrQuery.Encode()
-	request.Header.Add("Content-Type", "application/json")
+// executeAttempts send the request to the picked nodes until it succeeds or cannot be retried, nodes with open circuit are skipped
+func (solrClient *SolrClient) executeAttempts(ctx context.Context, solrRequest *solrRequest, result interface{}) error {
+	policy := solrClient.solrConfig.RetryPolicy
+	idempotent := solrClient.isIdempotent(solrRequest)
+	tried := make(map[*solrNode]bool)
+	for attempt := 1; ; attempt++ {
+		node := solrClient.pickNode(solrRequest, tried)
+		if node == nil {
+			return fmt.Errorf("no Solr node is available for collection: %s", solrClient.solrConfig.Collection)
+		}
+		tried[node] = true
+		var nodeBreaker *circuitBreaker
+		if solrClient.breakers != nil {
+			nodeBreaker = solrClient.breakers.get(nodeCircuitKey(node.url))
+			if openErr := nodeBreaker.allow(); openErr != nil {
+				if len(solrRequest.nodeUrl) != 0 || len(tried) >= solrClient.nodes.size() {
+					return wrapAttempts(openErr, attempt-1)
+				}
+				attempt--
+				continue
+			}
+		}
+		if attempt > 1 {
+			solrClient.metrics.ObserveRetry(solrClient.solrConfig.Collection, solrRequest.operation)
+		}
+		start := time.Now()
+		err := solrClient.executeOnNode(ctx, node, solrRequest, result)
+		if nodeBreaker != nil {
+			nodeBreaker.recordOutcome(ctx, err, time.Since(start))
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return wrapAttempts(err, attempt)
+		}
+		nodeFailure := isNodeFailure(err)
+		if nodeFailure {
+			solrClient.nodes.markDead(node)
+			solrClient.reportNodeHealth()
+		}
+		if !idempotent {
+			return wrapAttempts(err, attempt)
+		}
+		failover := nodeFailure && len(solrRequest.nodeUrl) == 0 && len(tried) < solrClient.nodes.size()
+		if policy == nil {
+			if !failover {
+				return wrapAttempts(err, attempt)
+			}
+			continue
+		}
+		if attempt >= policy.MaxAttempts || !policy.isRetryable(err) {
+			return wrapAttempts(err, attempt)
+		}
+		if !failover {
+			// every node has been tried, start again after waiting
+			tried = make(map[*solrNode]bool)
+			if sleepErr := sleepContext(ctx, policy.backoff(attempt)); sleepErr != nil {
+				return wrapAttempts(err, attempt)
+			}
+		}
+	}
+}
 
-	AddBasicAuthHeader(request, solrClient.solrConfig)
-	AddNegotiateHeader(request, solrClient.solrConfig)
+// pickNode returns the node for the next attempt of the request
+func (solrClient *SolrClient) pickNode(solrRequest *solrRequest, tried map[*solrNode]bool) *solrNode {
+	if len(solrRequest.nodeUrl) != 0 {
+		return solrClient.nodes.get(solrRequest.nodeUrl)
+	}
+	return solrClient.nodes.pick(tried)
+}
 
-	log.Print("Query: ", uri)
+// wrapAttempts wrap the error with the number of attempts, if the call was attempted more than once
+func wrapAttempts(err error, attempts int) error {
+	if attempts > 1 {
+		return &RetryError{Attempts: attempts, Err: err}
+	}
+	return err
+}
 
-	response, err := httpClient.Do(request)
+// executeOnNode send a request to a Solr collection handler of a specific node
+func (solrClient *SolrClient) executeOnNode(ctx context.Context, node *solrNode, solrRequest *solrRequest, result interface{}) error {
+	atomic.AddInt64(&node.outstanding, 1)
+	defer atomic.AddInt64(&node.outstanding, -1)
 
+	response, err := solrClient.sendToNode(ctx, node, solrRequest)
 	if err != nil {
-		return false, nil, err
+		return err
 	}
-
 	defer response.Body.Close()
 
-	bodyBytes, err := ioutil.ReadAll(response.Body)
+	reader := newResponseReader(response.Body, solrClient.solrConfig.MaxResponseSizeBytes)
+	err = decodeResponseStream(response.StatusCode, reader, result)
+	solrClient.metrics.ObserveBytes(solrClient.solrConfig.Collection, solrRequest.operation, int64(len(solrRequest.body)), reader.count)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
 
+// sendToNode send a request to a specific node through the request chain, the caller should close the response body
+func (solrClient *SolrClient) sendToNode(ctx context.Context, node *solrNode, solrRequest *solrRequest) (*http.Response, error) {
+	uri := solrRequest.uri(solrClient.solrConfig, node.url)
+	request, err := http.NewRequestWithContext(ctx, solrRequest.method, uri, bytes.NewReader(solrRequest.body))
 	if err != nil {
-		return false, nil, err
+		return nil, err
 	}
+	request.URL.RawQuery = solrRequest.params.Encode()
+	request.Header.Add("Content-Type", "application/json")
+	injectTraceContext(ctx, request)
 
-	var solrResponse SolrResponseData
-	jsonErr := json.Unmarshal(bodyBytes, &solrResponse)
-	if jsonErr != nil {
-		return false, nil, jsonErr
+	response, err := solrClient.roundTrip(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, &connectionError{err: err}
+		}
+		return nil, err
 	}
-	return true, &solrResponse, nil
+	return response, nil
+}
+
+// decodeResponse decode the Solr JSON response into the result object, a SolrError is returned if the HTTP status
+// is not successful, the response contains an error object or the response body is not JSON
+func decodeResponse(httpStatus int, bodyBytes []byte, result interface{}) error {
+	var errorResponse struct {
+		ResponseHeader SolrResponseHeader `json:"responseHeader"`
+		Error          *SolrErrorData     `json:"error"`
+	}
+	if jsonErr := json.Unmarshal(bodyBytes, &errorResponse); jsonErr != nil {
+		if httpStatus < 200 || httpStatus >= 300 {
+			return newSolrError(httpStatus, nil, bodyBytes)
+		}
+		solrError := newSolrError(httpStatus, nil, bodyBytes)
+		solrError.Msg = fmt.Sprintf("unexpected non-JSON response: %s", solrError.Msg)
+		return solrError
+	}
+	if errorResponse.Error != nil || httpStatus < 200 || httpStatus >= 300 {
+		return newSolrError(httpStatus, errorResponse.Error, bodyBytes)
+	}
+	if errorResponse.ResponseHeader.Status != 0 {
+		status := int(errorResponse.ResponseHeader.Status)
+		return &SolrError{HTTPStatus: httpStatus, Code: status, Msg: fmt.Sprintf("response header status: %d", status)}
+	}
+	return json.Unmarshal(bodyBytes, result)
 }
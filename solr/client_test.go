@@ -0,0 +1,72 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestUpdateParameters(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{"responseHeader":{"status":0,"QTime":1}}`))
+	}))
+	defer server.Close()
+	solrClient, err := NewSolrClient(&SolrConfig{Url: server.URL, Collection: "test"})
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	defer solrClient.Close()
+
+	testCases := []struct {
+		name       string
+		parameters *url.Values
+		commit     bool
+		expected   url.Values
+	}{
+		{"no parameters", nil, false, url.Values{}},
+		{"commit", nil, true, url.Values{"commit": {"true"}}},
+		{"parameters", &url.Values{"overwrite": {"false"}, "commitWithin": {"1000"}}, false,
+			url.Values{"overwrite": {"false"}, "commitWithin": {"1000"}}},
+		{"commit overrides commit parameter", &url.Values{"commit": {"false"}}, true, url.Values{"commit": {"true"}}},
+	}
+	for _, testCase := range testCases {
+		if _, _, err := solrClient.Update([]SolrDocument{{"id": "1"}}, testCase.parameters, testCase.commit); err != nil {
+			t.Fatalf("%s: unexpected error: %v", testCase.name, err)
+		}
+		query.Del("wt")
+		if query.Encode() != testCase.expected.Encode() {
+			t.Errorf("%s: expected parameters %q, got %q", testCase.name, testCase.expected.Encode(), query.Encode())
+		}
+	}
+}
+
+func TestAddNegotiateHeaderError(t *testing.T) {
+	securityConfig := InitSecurityConfig("", "/nonexistent/solr.keytab", "solr", "EXAMPLE.COM")
+	request, _ := http.NewRequest("GET", "http://localhost:8983/solr/test/select", nil)
+	if err := AddNegotiateHeader(request, &SolrConfig{SecurityConfig: &securityConfig}); err == nil {
+		t.Fatalf("expected keytab error")
+	}
+	if len(request.Header.Get("Authorization")) != 0 {
+		t.Fatalf("authorization header should not be set")
+	}
+	if err := AddNegotiateHeader(request, &SolrConfig{SecurityConfig: &SecurityConfig{}}); err != nil {
+		t.Fatalf("unexpected error without kerberos: %v", err)
+	}
+}
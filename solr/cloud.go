@@ -0,0 +1,548 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// defaultClusterStateRefreshIntervalSeconds is used for refreshing the cluster state if no interval is configured
+const defaultClusterStateRefreshIntervalSeconds = 30
+
+// defaultZkSessionTimeoutSeconds is used for ZooKeeper sessions if no session timeout is configured
+const defaultZkSessionTimeoutSeconds = 15
+
+// clusterStateTimeout is the timeout of one cluster state refresh
+const clusterStateTimeout = 30 * time.Second
+
+// ReplicaActive is the state of replicas that can serve requests
+const ReplicaActive = "active"
+
+// ClusterState represents the live nodes of a SolrCloud cluster and the shard/replica layout of its collections
+type ClusterState struct {
+	LiveNodes   []string                    `json:"live_nodes"`
+	Collections map[string]*CollectionState `json:"collections"`
+}
+
+// CollectionState represents the shards and the document router of a collection
+type CollectionState struct {
+	Shards map[string]*ShardState `json:"shards"`
+	Router CollectionRouter       `json:"router"`
+}
+
+// CollectionRouter represents the document router of a collection (e.g. compositeId or implicit)
+type CollectionRouter struct {
+	Name  string `json:"name"`
+	Field string `json:"field,omitempty"`
+}
+
+// ShardState represents a shard (slice) of a collection
+type ShardState struct {
+	Range    string                   `json:"range"`
+	State    string                   `json:"state"`
+	Replicas map[string]*ReplicaState `json:"replicas"`
+}
+
+// ReplicaState represents a replica of a shard
+type ReplicaState struct {
+	Core     string `json:"core"`
+	BaseURL  string `json:"base_url"`
+	NodeName string `json:"node_name"`
+	State    string `json:"state"`
+	Leader   string `json:"leader,omitempty"`
+	Type     string `json:"type,omitempty"`
+}
+
+// ClusterStateProvider gathers the state of a SolrCloud cluster for a collection
+type ClusterStateProvider interface {
+	ClusterState(ctx context.Context, collection string) (*ClusterState, error)
+}
+
+// ZkConnection is the subset of ZooKeeper operations that are used for reading the cluster state (implemented by *zk.Conn)
+type ZkConnection interface {
+	Children(path string) ([]string, *zk.Stat, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Close()
+}
+
+// ZkClusterStateProvider reads the cluster state from ZooKeeper (/live_nodes and /collections/<collection>/state.json)
+type ZkClusterStateProvider struct {
+	zkHosts        []string
+	chroot         string
+	sessionTimeout time.Duration
+	logger         Logger
+	mutex          sync.Mutex
+	connection     ZkConnection
+	closed         bool
+}
+
+// HttpClusterStateProvider reads the cluster state with the Collections API (CLUSTERSTATUS) through a Solr client
+type HttpClusterStateProvider struct {
+	solrClient *SolrClient
+}
+
+// fallbackClusterStateProvider tries the providers one after another, until one of them returns the cluster state
+type fallbackClusterStateProvider struct {
+	providers []ClusterStateProvider
+}
+
+// zkLogger writes the log messages of the ZooKeeper client with the logger of the Solr client
+type zkLogger struct {
+	logger Logger
+}
+
+// cloudState holds the last known cluster state of a cloud mode Solr client, the provider is closed with the client
+// only if the client created it (providers of WithClusterStateProvider are owned by the caller)
+type cloudState struct {
+	provider     ClusterStateProvider
+	ownsProvider bool
+	mutex        sync.RWMutex
+	clusterState *ClusterState
+}
+
+// WithClusterStateProvider set the cluster state provider of the Solr client (and turn on cloud mode), it overrides the providers of the cloud config
+func WithClusterStateProvider(provider ClusterStateProvider) ClientOption {
+	return func(solrClient *SolrClient) {
+		solrClient.clusterStateProvider = provider
+	}
+}
+
+// IsLeader returns true if the replica is the leader of its shard
+func (replica *ReplicaState) IsLeader() bool {
+	return replica.Leader == "true"
+}
+
+// IsActive returns true if the replica is active and its node is live
+func (replica *ReplicaState) IsActive(liveNodes map[string]bool) bool {
+	return replica.State == ReplicaActive && liveNodes[replica.NodeName]
+}
+
+// Leader returns the leader replica of the shard (nil if the shard has no leader)
+func (shard *ShardState) Leader() *ReplicaState {
+	for _, replica := range shard.Replicas {
+		if replica.IsLeader() {
+			return replica
+		}
+	}
+	return nil
+}
+
+// liveNodeSet returns the live nodes as a set
+func (clusterState *ClusterState) liveNodeSet() map[string]bool {
+	liveNodes := make(map[string]bool)
+	for _, liveNode := range clusterState.LiveNodes {
+		liveNodes[liveNode] = true
+	}
+	return liveNodes
+}
+
+// ActiveReplicaUrls returns the base urls of the nodes that host active replicas of the collection (sorted, without duplicates)
+func (clusterState *ClusterState) ActiveReplicaUrls(collection string) []string {
+	collectionState, ok := clusterState.Collections[collection]
+	if !ok {
+		return nil
+	}
+	liveNodes := clusterState.liveNodeSet()
+	urlSet := make(map[string]bool)
+	for _, shard := range collectionState.Shards {
+		if len(shard.State) != 0 && shard.State != ReplicaActive {
+			continue
+		}
+		for _, replica := range shard.Replicas {
+			if replica.IsActive(liveNodes) {
+				urlSet[replica.BaseURL] = true
+			}
+		}
+	}
+	var urls []string
+	for replicaUrl := range urlSet {
+		urls = append(urls, replicaUrl)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// NodeNameToBaseUrl transform a SolrCloud node name (e.g.: myhost:8983_solr) into a base url (e.g.: http://myhost:8983/solr)
+func NodeNameToBaseUrl(nodeName string, urlScheme string) string {
+	if len(urlScheme) == 0 {
+		urlScheme = "http"
+	}
+	hostAndPort := nodeName
+	path := ""
+	if separatorIndex := strings.Index(nodeName, "_"); separatorIndex != -1 {
+		hostAndPort = nodeName[:separatorIndex]
+		if decodedPath, err := url.QueryUnescape(nodeName[separatorIndex+1:]); err == nil {
+			path = decodedPath
+		} else {
+			path = nodeName[separatorIndex+1:]
+		}
+	}
+	if len(path) == 0 {
+		return fmt.Sprintf("%s://%s", urlScheme, hostAndPort)
+	}
+	return fmt.Sprintf("%s://%s/%s", urlScheme, hostAndPort, strings.TrimPrefix(path, "/"))
+}
+
+// fillBaseUrls set replica base urls based on node names, newer Solr versions do not store base urls in state.json
+func (clusterState *ClusterState) fillBaseUrls(urlScheme string) {
+	for _, collectionState := range clusterState.Collections {
+		for _, shard := range collectionState.Shards {
+			for _, replica := range shard.Replicas {
+				if len(replica.BaseURL) == 0 && len(replica.NodeName) != 0 {
+					replica.BaseURL = NodeNameToBaseUrl(replica.NodeName, urlScheme)
+				}
+			}
+		}
+	}
+}
+
+// NewZkClusterStateProvider create a cluster state provider that connects to ZooKeeper on first use
+func NewZkClusterStateProvider(zkHosts []string, chroot string, sessionTimeout time.Duration) *ZkClusterStateProvider {
+	return &ZkClusterStateProvider{zkHosts: zkHosts, chroot: strings.TrimRight(chroot, "/"), sessionTimeout: sessionTimeout}
+}
+
+// WithLogger set the logger of the ZooKeeper client (by default it logs with the standard library logger)
+func (provider *ZkClusterStateProvider) WithLogger(logger Logger) *ZkClusterStateProvider {
+	provider.logger = logger
+	return provider
+}
+
+// Printf write a ZooKeeper client log message as a warning (informational messages are turned off)
+func (logger zkLogger) Printf(format string, args ...interface{}) {
+	logger.logger.Warn("ZooKeeper client: " + fmt.Sprintf(format, args...))
+}
+
+// NewZkClusterStateProviderWithConnection create a cluster state provider with an existing ZooKeeper connection (or a stand-in implementation)
+func NewZkClusterStateProviderWithConnection(connection ZkConnection, chroot string) *ZkClusterStateProvider {
+	return &ZkClusterStateProvider{connection: connection, chroot: strings.TrimRight(chroot, "/")}
+}
+
+// ClusterState read live nodes and the state of the collection from ZooKeeper
+func (provider *ZkClusterStateProvider) ClusterState(ctx context.Context, collection string) (*ClusterState, error) {
+	connection, err := provider.connect()
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		clusterState *ClusterState
+		err          error
+	}
+	done := make(chan result, 1)
+	go func() {
+		clusterState, err := provider.readClusterState(connection, collection)
+		done <- result{clusterState: clusterState, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-done:
+		return res.clusterState, res.err
+	}
+}
+
+func (provider *ZkClusterStateProvider) connect() (ZkConnection, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.connection != nil {
+		return provider.connection, nil
+	}
+	if provider.closed {
+		return nil, fmt.Errorf("ZooKeeper cluster state provider is closed")
+	}
+	var logger zk.Logger = zk.DefaultLogger
+	if provider.logger != nil {
+		logger = zkLogger{logger: provider.logger}
+	}
+	connection, _, err := zk.Connect(provider.zkHosts, provider.sessionTimeout, zk.WithLogInfo(false), zk.WithLogger(logger))
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to ZooKeeper: %v", err)
+	}
+	provider.connection = connection
+	return connection, nil
+}
+
+func (provider *ZkClusterStateProvider) readClusterState(connection ZkConnection, collection string) (*ClusterState, error) {
+	liveNodes, _, err := connection.Children(provider.chroot + "/live_nodes")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read live nodes from ZooKeeper: %v", err)
+	}
+	clusterState := &ClusterState{LiveNodes: liveNodes, Collections: make(map[string]*CollectionState)}
+	stateJSON, _, err := connection.Get(fmt.Sprintf("%s/collections/%s/state.json", provider.chroot, collection))
+	if err == zk.ErrNoNode {
+		// collections created with older Solr versions are stored in the shared cluster state
+		stateJSON, _, err = connection.Get(provider.chroot + "/clusterstate.json")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state of collection %s from ZooKeeper: %v", collection, err)
+	}
+	var collections map[string]*CollectionState
+	if err := json.Unmarshal(stateJSON, &collections); err != nil {
+		return nil, fmt.Errorf("cannot parse state of collection %s: %v", collection, err)
+	}
+	if collectionState, ok := collections[collection]; ok {
+		clusterState.Collections[collection] = collectionState
+	}
+	urlScheme := "http"
+	if clusterProps, _, err := connection.Get(provider.chroot + "/clusterprops.json"); err == nil && len(clusterProps) != 0 {
+		var props struct {
+			URLScheme string `json:"urlScheme"`
+		}
+		if json.Unmarshal(clusterProps, &props) == nil && len(props.URLScheme) != 0 {
+			urlScheme = props.URLScheme
+		}
+	}
+	clusterState.fillBaseUrls(urlScheme)
+	return clusterState, nil
+}
+
+// Close close the ZooKeeper connection, the provider does not connect again after it is closed
+func (provider *ZkClusterStateProvider) Close() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.closed = true
+	if provider.connection != nil {
+		provider.connection.Close()
+		provider.connection = nil
+	}
+}
+
+// NewHttpClusterStateProvider create a cluster state provider that uses the Collections API through the Solr client
+func NewHttpClusterStateProvider(solrClient *SolrClient) *HttpClusterStateProvider {
+	return &HttpClusterStateProvider{solrClient: solrClient}
+}
+
+// ClusterState read live nodes and the state of the collection with the CLUSTERSTATUS action of the Collections API,
+// the request is sent to the configured Solr urls and the live nodes (not only to the nodes of the active replicas)
+func (provider *HttpClusterStateProvider) ClusterState(ctx context.Context, collection string) (*ClusterState, error) {
+	params := url.Values{}
+	params.Set("action", "CLUSTERSTATUS")
+	params.Set("collection", collection)
+	params.Set("wt", "json")
+	nodeUrls := provider.solrClient.adminNodeUrls()
+	if len(nodeUrls) == 0 {
+		return nil, fmt.Errorf("no Solr node is available for the CLUSTERSTATUS request")
+	}
+	var errs []string
+	for _, nodeUrl := range nodeUrls {
+		var clusterStatus struct {
+			Cluster ClusterState `json:"cluster"`
+		}
+		request := &solrRequest{method: "GET", path: "admin/collections", nodeUrl: nodeUrl, operation: OperationAdmin, params: params, idempotent: true}
+		err := provider.solrClient.execute(ctx, request, &clusterStatus)
+		if err == nil {
+			clusterState := &clusterStatus.Cluster
+			if clusterState.Collections == nil {
+				clusterState.Collections = make(map[string]*CollectionState)
+			}
+			clusterState.fillBaseUrls(urlSchemeOf(nodeUrl))
+			return clusterState, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if AsSolrError(err) != nil && !isNodeFailure(err) {
+			// the same request fails on the other nodes as well
+			return nil, err
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", nodeUrl, err))
+	}
+	return nil, fmt.Errorf("CLUSTERSTATUS request failed on every node: %s", strings.Join(errs, "; "))
+}
+
+// Close close the providers that hold connections (e.g. ZooKeeper)
+func (provider *fallbackClusterStateProvider) Close() {
+	for _, delegate := range provider.providers {
+		if closer, ok := delegate.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+// ClusterState returns the cluster state of the first provider that succeeds
+func (provider *fallbackClusterStateProvider) ClusterState(ctx context.Context, collection string) (*ClusterState, error) {
+	var errs []string
+	for _, delegate := range provider.providers {
+		clusterState, err := delegate.ClusterState(ctx, collection)
+		if err == nil {
+			return clusterState, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("cannot gather cluster state: %s", strings.Join(errs, "; "))
+}
+
+func urlSchemeOf(solrUrl string) string {
+	if parsedUrl, err := url.Parse(solrUrl); err == nil && len(parsedUrl.Scheme) != 0 {
+		return parsedUrl.Scheme
+	}
+	return "http"
+}
+
+// initCloud set up the cluster state provider, load the initial cluster state and start the periodic refresh
+func (solrClient *SolrClient) initCloud() error {
+	cloudConfig := solrClient.solrConfig.CloudConfig
+	if cloudConfig == nil {
+		cloudConfig = &CloudConfig{}
+	}
+	provider := solrClient.clusterStateProvider
+	ownsProvider := provider == nil
+	if ownsProvider {
+		var providers []ClusterStateProvider
+		if len(cloudConfig.ZkHosts) != 0 {
+			sessionTimeout := cloudConfig.ZkSessionTimeoutSeconds
+			if sessionTimeout <= 0 {
+				sessionTimeout = defaultZkSessionTimeoutSeconds
+			}
+			zkProvider := NewZkClusterStateProvider(cloudConfig.ZkHosts, cloudConfig.ZkChroot, time.Duration(sessionTimeout)*time.Second)
+			providers = append(providers, zkProvider.WithLogger(solrClient.logger))
+		}
+		if len(solrClient.solrConfig.Url) != 0 || len(solrClient.solrConfig.Urls) != 0 {
+			providers = append(providers, NewHttpClusterStateProvider(solrClient))
+		}
+		if len(providers) == 0 {
+			return fmt.Errorf("cloud mode requires ZooKeeper hosts or Solr urls")
+		}
+		provider = &fallbackClusterStateProvider{providers: providers}
+	}
+	solrClient.cloud = &cloudState{provider: provider, ownsProvider: ownsProvider}
+	if err := solrClient.RefreshClusterState(context.Background()); err != nil {
+		solrClient.cloud.close()
+		return err
+	}
+	refreshInterval := cloudConfig.RefreshIntervalSeconds
+	if refreshInterval <= 0 {
+		refreshInterval = defaultClusterStateRefreshIntervalSeconds
+	}
+	go solrClient.refreshClusterStatePeriodically(time.Duration(refreshInterval) * time.Second)
+	return nil
+}
+
+// RefreshClusterState gather the cluster state, and route the requests to the nodes of the active replicas of the collection
+func (solrClient *SolrClient) RefreshClusterState(ctx context.Context) error {
+	if solrClient.cloud == nil {
+		return fmt.Errorf("cloud mode is not enabled")
+	}
+	ctx, cancel := context.WithTimeout(ctx, clusterStateTimeout)
+	defer cancel()
+	clusterState, err := solrClient.cloud.provider.ClusterState(ctx, solrClient.solrConfig.Collection)
+	if err != nil {
+		return err
+	}
+	if _, ok := clusterState.Collections[solrClient.solrConfig.Collection]; !ok {
+		return fmt.Errorf("collection %s does not exist in the cluster state", solrClient.solrConfig.Collection)
+	}
+	solrClient.cloud.mutex.Lock()
+	solrClient.cloud.clusterState = clusterState
+	solrClient.cloud.mutex.Unlock()
+	replicaUrls := clusterState.ActiveReplicaUrls(solrClient.solrConfig.Collection)
+	if len(replicaUrls) == 0 {
+		// never leave the client without nodes, the configured nodes forward the requests when the replicas recover
+		solrClient.logger.Warn("Collection has no active replica, requests are sent to the configured Solr urls",
+			LogField("collection", solrClient.solrConfig.Collection))
+		replicaUrls = solrClient.seedNodeUrls()
+	}
+	if len(replicaUrls) != 0 {
+		removed := solrClient.nodes.setNodes(replicaUrls)
+		if solrClient.breakers != nil {
+			for i, nodeUrl := range removed {
+				removed[i] = nodeCircuitKey(nodeUrl)
+			}
+			solrClient.breakers.remove(removed...)
+		}
+	}
+	solrClient.reportNodeHealth()
+	return nil
+}
+
+// seedNodeUrls returns the configured Solr urls (with url context)
+func (solrClient *SolrClient) seedNodeUrls() []string {
+	var urls []string
+	for _, nodeUrl := range solrClient.solrConfig.nodeUrls() {
+		if len(nodeUrl) != 0 {
+			urls = append(urls, nodeUrl+solrClient.solrConfig.SolrUrlContext)
+		}
+	}
+	return urls
+}
+
+// adminNodeUrls returns the nodes for admin requests: the configured Solr urls and the live nodes of the last known cluster state
+func (solrClient *SolrClient) adminNodeUrls() []string {
+	urls := solrClient.seedNodeUrls()
+	seen := make(map[string]bool)
+	for _, nodeUrl := range urls {
+		seen[nodeUrl] = true
+	}
+	if clusterState := solrClient.ClusterState(); clusterState != nil {
+		urlScheme := "http"
+		if len(urls) != 0 {
+			urlScheme = urlSchemeOf(urls[0])
+		}
+		for _, liveNode := range clusterState.LiveNodes {
+			nodeUrl := NodeNameToBaseUrl(liveNode, urlScheme)
+			if !seen[nodeUrl] {
+				seen[nodeUrl] = true
+				urls = append(urls, nodeUrl)
+			}
+		}
+	}
+	return urls
+}
+
+// ClusterState returns the last known cluster state (nil if cloud mode is not enabled)
+func (solrClient *SolrClient) ClusterState() *ClusterState {
+	if solrClient.cloud == nil {
+		return nil
+	}
+	solrClient.cloud.mutex.RLock()
+	defer solrClient.cloud.mutex.RUnlock()
+	return solrClient.cloud.clusterState
+}
+
+func (solrClient *SolrClient) refreshClusterStatePeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-solrClient.stop:
+			return
+		case <-ticker.C:
+			if err := solrClient.RefreshClusterState(context.Background()); err != nil {
+				solrClient.logger.Warn("Cluster state refresh failed", LogField("collection", solrClient.solrConfig.Collection), LogField("error", err))
+			}
+		}
+	}
+}
+
+// close close the provider (e.g. the ZooKeeper connection), if it was created by the client
+func (cloud *cloudState) close() {
+	if !cloud.ownsProvider {
+		return
+	}
+	if closer, ok := cloud.provider.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// clusterStatusServer is a stand-in Solr node that answers CLUSTERSTATUS requests with the current cluster state
+type clusterStatusServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	state    *ClusterState
+	requests int
+}
+
+// fakeZkConnection is a stand-in ZooKeeper connection that serves nodes from memory
+type fakeZkConnection struct {
+	children map[string][]string
+	data     map[string][]byte
+	err      error
+	closed   bool
+}
+
+// closableClusterStateProvider is a stand-in provider that fails with err (if it is set) and records whether it was closed
+type closableClusterStateProvider struct {
+	err    error
+	mutex  sync.Mutex
+	closed bool
+}
+
+// recordingLogger records the messages of every level
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func newClusterStatusServer(t *testing.T) *clusterStatusServer {
+	server := &clusterStatusServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/solr/admin/collections" || r.URL.Query().Get("action") != "CLUSTERSTATUS" {
+			http.Error(w, "unexpected request: "+r.URL.String(), http.StatusNotFound)
+			return
+		}
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.requests++
+		json.NewEncoder(w).Encode(map[string]interface{}{"responseHeader": map[string]int{"status": 0}, "cluster": server.state})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *clusterStatusServer) setState(state *ClusterState) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.state = state
+}
+
+func (server *clusterStatusServer) requestCount() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.requests
+}
+
+// nodeName returns the SolrCloud node name of the server, e.g.: 127.0.0.1:12345_solr
+func (server *clusterStatusServer) nodeName() string {
+	return strings.TrimPrefix(server.URL, "http://") + "_solr"
+}
+
+// testClusterState create a cluster state with one shard of the "test" collection, with a replica on each node
+func testClusterState(liveNodes []string, replicaState string, replicaNodes ...string) *ClusterState {
+	replicas := make(map[string]*ReplicaState)
+	for i, nodeName := range replicaNodes {
+		replica := &ReplicaState{Core: "test_shard1_replica_n" + strconv.Itoa(i+1), NodeName: nodeName, State: replicaState}
+		if i == 0 {
+			replica.Leader = "true"
+		}
+		replicas["core_node"+strconv.Itoa(i+1)] = replica
+	}
+	return &ClusterState{
+		LiveNodes: liveNodes,
+		Collections: map[string]*CollectionState{
+			"test": {
+				Router: CollectionRouter{Name: "compositeId"},
+				Shards: map[string]*ShardState{"shard1": {Range: "80000000-7fffffff", State: "active", Replicas: replicas}},
+			},
+		},
+	}
+}
+
+func newCloudTestClient(t *testing.T, server *clusterStatusServer, options ...ClientOption) *SolrClient {
+	solrConfig := &SolrConfig{Url: server.URL, SolrUrlContext: "/solr", Collection: "test", CloudConfig: &CloudConfig{}}
+	solrClient, err := NewSolrClient(solrConfig, options...)
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+	return solrClient
+}
+
+func (connection *fakeZkConnection) Children(path string) ([]string, *zk.Stat, error) {
+	if connection.err != nil {
+		return nil, nil, connection.err
+	}
+	children, ok := connection.children[path]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return children, &zk.Stat{}, nil
+}
+
+func (connection *fakeZkConnection) Get(path string) ([]byte, *zk.Stat, error) {
+	if connection.err != nil {
+		return nil, nil, connection.err
+	}
+	data, ok := connection.data[path]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return data, &zk.Stat{}, nil
+}
+
+func (connection *fakeZkConnection) Close() {
+	connection.closed = true
+}
+
+func (provider *closableClusterStateProvider) ClusterState(ctx context.Context, collection string) (*ClusterState, error) {
+	if provider.err != nil {
+		return nil, provider.err
+	}
+	return testClusterState([]string{"host1:8983_solr"}, ReplicaActive, "host1:8983_solr"), nil
+}
+
+func (provider *closableClusterStateProvider) Close() {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.closed = true
+}
+
+func (provider *closableClusterStateProvider) isClosed() bool {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	return provider.closed
+}
+
+func (logger *recordingLogger) log(level LogLevel, msg string, fields []Field) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	message := level.String() + " " + msg
+	for _, field := range fields {
+		message += fmt.Sprintf(" %s=%v", field.Key, field.Value)
+	}
+	logger.messages = append(logger.messages, message)
+}
+
+func (logger *recordingLogger) Debug(msg string, fields ...Field) {
+	logger.log(LevelDebug, msg, fields)
+}
+func (logger *recordingLogger) Info(msg string, fields ...Field) { logger.log(LevelInfo, msg, fields) }
+func (logger *recordingLogger) Warn(msg string, fields ...Field) { logger.log(LevelWarn, msg, fields) }
+func (logger *recordingLogger) Error(msg string, fields ...Field) {
+	logger.log(LevelError, msg, fields)
+}
+
+func (logger *recordingLogger) recorded() []string {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	return append([]string(nil), logger.messages...)
+}
+
+func TestZkClusterStateProvider(t *testing.T) {
+	state := testClusterState(nil, ReplicaActive, "host1:8983_solr", "host2:8983_solr")
+	stateJSON, _ := json.Marshal(state.Collections)
+	connection := &fakeZkConnection{
+		children: map[string][]string{"/solr/live_nodes": {"host1:8983_solr"}},
+		data: map[string][]byte{
+			"/solr/collections/test/state.json": stateJSON,
+			"/solr/clusterprops.json":           []byte(`{"urlScheme":"https"}`),
+		},
+	}
+	provider := NewZkClusterStateProviderWithConnection(connection, "/solr/")
+	clusterState, err := provider.ClusterState(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if urls := clusterState.ActiveReplicaUrls("test"); !reflect.DeepEqual(urls, []string{"https://host1:8983/solr"}) {
+		t.Fatalf("unexpected active replica urls: %v", urls)
+	}
+}
+
+func TestRefreshClusterState(t *testing.T) {
+	server := newClusterStatusServer(t)
+	server.setState(testClusterState([]string{server.nodeName()}, ReplicaActive, server.nodeName()))
+	solrClient := newCloudTestClient(t, server)
+	if nodes := solrClient.LiveNodes(); !reflect.DeepEqual(nodes, []string{server.URL + "/solr"}) {
+		t.Fatalf("unexpected nodes after the initial refresh: %v", nodes)
+	}
+
+	otherNode := "127.0.0.1:1_solr"
+	server.setState(testClusterState([]string{server.nodeName(), otherNode}, ReplicaActive, server.nodeName(), otherNode))
+	if err := solrClient.RefreshClusterState(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"http://127.0.0.1:1/solr", server.URL + "/solr"}
+	if nodes := solrClient.LiveNodes(); !reflect.DeepEqual(nodes, expected) {
+		t.Fatalf("unexpected nodes after refresh: %v, expected: %v", nodes, expected)
+	}
+	if leader := solrClient.ClusterState().Collections["test"].Shards["shard1"].Leader(); leader == nil || leader.BaseURL != server.URL+"/solr" {
+		t.Fatalf("unexpected leader: %+v", leader)
+	}
+}
+
+func TestRefreshClusterStateMissingCollection(t *testing.T) {
+	server := newClusterStatusServer(t)
+	server.setState(&ClusterState{LiveNodes: []string{server.nodeName()}})
+	solrConfig := &SolrConfig{Url: server.URL, SolrUrlContext: "/solr", Collection: "test", CloudConfig: &CloudConfig{}}
+	if _, err := NewSolrClient(solrConfig); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected missing collection error, got: %v", err)
+	}
+}
+
+func TestClusterStateFallbackProvider(t *testing.T) {
+	server := newClusterStatusServer(t)
+	server.setState(testClusterState([]string{server.nodeName()}, ReplicaActive, server.nodeName()))
+	solrClient, err := NewSolrClient(&SolrConfig{Url: server.URL, SolrUrlContext: "/solr", Collection: "test"})
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	defer solrClient.Close()
+
+	zkProvider := NewZkClusterStateProviderWithConnection(&fakeZkConnection{err: zk.ErrNoServer}, "")
+	provider := &fallbackClusterStateProvider{providers: []ClusterStateProvider{zkProvider, NewHttpClusterStateProvider(solrClient)}}
+	clusterState, err := provider.ClusterState(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if urls := clusterState.ActiveReplicaUrls("test"); !reflect.DeepEqual(urls, []string{server.URL + "/solr"}) {
+		t.Fatalf("unexpected active replica urls: %v", urls)
+	}
+	if server.requestCount() != 1 {
+		t.Fatalf("expected one CLUSTERSTATUS request, got: %d", server.requestCount())
+	}
+
+	server.Close()
+	_, err = provider.ClusterState(context.Background(), "test")
+	if err == nil || !strings.Contains(err.Error(), "ZooKeeper") || !strings.Contains(err.Error(), "CLUSTERSTATUS") {
+		t.Fatalf("expected the errors of both providers, got: %v", err)
+	}
+}
+
+func TestRefreshClusterStateAllReplicasDown(t *testing.T) {
+	server := newClusterStatusServer(t)
+	unreachableNode := "127.0.0.1:1_solr"
+	server.setState(testClusterState([]string{unreachableNode}, ReplicaActive, unreachableNode))
+	solrClient := newCloudTestClient(t, server)
+	if nodes := solrClient.LiveNodes(); !reflect.DeepEqual(nodes, []string{"http://127.0.0.1:1/solr"}) {
+		t.Fatalf("unexpected nodes: %v", nodes)
+	}
+
+	// the replica nodes are dead, the cluster state is still refreshed through the configured url
+	for _, node := range solrClient.nodes.nodes {
+		solrClient.nodes.markDead(node)
+	}
+	server.setState(testClusterState([]string{unreachableNode}, "recovering", unreachableNode))
+	if err := solrClient.RefreshClusterState(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nodes := solrClient.LiveNodes(); !reflect.DeepEqual(nodes, []string{server.URL + "/solr"}) {
+		t.Fatalf("expected the configured url without active replicas, got: %v", nodes)
+	}
+
+	server.setState(testClusterState([]string{server.nodeName()}, ReplicaActive, server.nodeName()))
+	if err := solrClient.RefreshClusterState(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nodes := solrClient.LiveNodes(); !reflect.DeepEqual(nodes, []string{server.URL + "/solr"}) {
+		t.Fatalf("unexpected nodes after recovery: %v", nodes)
+	}
+}
+
+func TestAdminNodeUrls(t *testing.T) {
+	server := newClusterStatusServer(t)
+	server.setState(testClusterState([]string{server.nodeName(), "host2:8983_solr"}, ReplicaActive, server.nodeName()))
+	solrClient := newCloudTestClient(t, server)
+	expected := []string{server.URL + "/solr", "http://host2:8983/solr"}
+	if urls := solrClient.adminNodeUrls(); !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("unexpected admin node urls: %v, expected: %v", urls, expected)
+	}
+	if err := solrClient.RefreshClusterState(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCallerClusterStateProviderIsNotClosed(t *testing.T) {
+	provider := &closableClusterStateProvider{err: errors.New("cluster state is not available")}
+	if _, err := NewSolrClient(&SolrConfig{Collection: "test"}, WithClusterStateProvider(provider)); err == nil {
+		t.Fatalf("expected cluster state error")
+	}
+	if provider.isClosed() {
+		t.Fatalf("the provider of the caller should not be closed when the client fails to start")
+	}
+
+	provider = &closableClusterStateProvider{}
+	solrClient, err := NewSolrClient(&SolrConfig{Collection: "test"}, WithClusterStateProvider(provider))
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	solrClient.Close()
+	if provider.isClosed() {
+		t.Fatalf("the provider of the caller should not be closed with the client")
+	}
+}
+
+func TestOwnedClusterStateProviderIsClosed(t *testing.T) {
+	server := newClusterStatusServer(t)
+	server.setState(testClusterState([]string{server.nodeName()}, ReplicaActive, server.nodeName()))
+	solrClient := newCloudTestClient(t, server)
+	if !solrClient.cloud.ownsProvider {
+		t.Fatalf("the provider created from the cloud config should be owned by the client")
+	}
+	provider := &closableClusterStateProvider{}
+	solrClient.cloud.provider = provider
+	solrClient.Close()
+	if !provider.isClosed() {
+		t.Fatalf("the provider of the client should be closed with the client")
+	}
+
+	// providers created from the cloud config are wrapped in a fallback provider
+	provider = &closableClusterStateProvider{}
+	cloud := &cloudState{provider: &fallbackClusterStateProvider{providers: []ClusterStateProvider{provider}}, ownsProvider: true}
+	cloud.close()
+	if !provider.isClosed() {
+		t.Fatalf("the delegates of the fallback provider should be closed")
+	}
+}
+
+func TestZkClusterStateProviderClose(t *testing.T) {
+	connection := &fakeZkConnection{err: zk.ErrNoServer}
+	provider := NewZkClusterStateProviderWithConnection(connection, "/solr")
+	provider.Close()
+	if !connection.closed {
+		t.Fatalf("the ZooKeeper connection should be closed")
+	}
+	if _, err := provider.ClusterState(context.Background(), "test"); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("a closed provider should not connect again, got: %v", err)
+	}
+}
+
+func TestZkLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	zkLogger{logger: logger}.Printf("failed to connect to %s: %v", "127.0.0.1:2181", "connection refused")
+	expected := []string{"WARN ZooKeeper client: failed to connect to 127.0.0.1:2181: connection refused"}
+	if messages := logger.recorded(); !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("unexpected log messages: %v", messages)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"net/url"
+	"strings"
+)
+
+// CursorMarkStart is the cursor mark of the first page
+const CursorMarkStart = "*"
+
+// Cursor start deep paging with cursorMark: the cursor mark is set to the first page, the uniqueKey field is added
+// to the sort as tiebreaker (if the sort does not contain it yet) and the start parameter is removed (it must be 0 with cursors)
+func (q *SolrQuery) Cursor(uniqueKey string) {
+	q.CursorMark(CursorMarkStart)
+	q.SetParam("sort", sortWithTiebreaker(q.params.Get("sort"), uniqueKey))
+	q.params.Del("start")
+}
+
+// CursorMark sets the cursor mark of the query (use the nextCursorMark of the previous response to get the next page)
+func (q *SolrQuery) CursorMark(cursorMark string) {
+	q.SetParam("cursorMark", cursorMark)
+}
+
+// WalkCursor send the query with cursorMark (starting from the first page, if the query has no cursor mark) and call the callback
+// for every document, until the cursor stops advancing or the callback returns an error (that error is returned)
+func (solrClient *SolrClient) WalkCursor(ctx context.Context, solrQuery *SolrQuery, callback func(doc SolrDocument) error) error {
+	for doc, err := range SearchCursor[SolrDocument](ctx, solrClient, solrQuery) {
+		if err != nil {
+			return err
+		}
+		if err := callback(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchCursor returns an iterator over every document that matches the query, the pages are fetched with cursorMark
+// (starting from the first page, if the query has no cursor mark), see SearchAll
+func SearchCursor[T any](ctx context.Context, solrClient *SolrClient, solrQuery *SolrQuery) iter.Seq2[T, error] {
+	cursorQuery := CreateSolrQuery()
+	if solrQuery != nil {
+		cursorQuery = solrQuery.Clone()
+	}
+	uniqueKey := solrClient.solrConfig.uniqueKey()
+	if len(cursorQuery.GetParam("cursorMark")) == 0 {
+		cursorQuery.Cursor(uniqueKey)
+	} else {
+		cursorQuery.SetParam("sort", sortWithTiebreaker(cursorQuery.GetParam("sort"), uniqueKey))
+		cursorQuery.params.Del("start")
+	}
+	return SearchAll[T](ctx, solrClient, cursorQuery)
+}
+
+// validateCursorParams returns an error if the params have a cursor mark, but Solr would reject the cursor request:
+// start is not 0 or the sort does not contain the uniqueKey field as tiebreaker (Cursor, SearchCursor and WalkCursor set both)
+func validateCursorParams(params url.Values, uniqueKey string) error {
+	if len(params.Get("cursorMark")) == 0 {
+		return nil
+	}
+	if start := params.Get("start"); len(start) != 0 && start != "0" {
+		return fmt.Errorf("start must be 0 with cursorMark, got: %s", start)
+	}
+	sort := params.Get("sort")
+	if sortWithTiebreaker(sort, uniqueKey) != sort {
+		return fmt.Errorf("sort must contain the uniqueKey field (%s) with cursorMark, got: %q (use Cursor, SearchCursor or WalkCursor)", uniqueKey, sort)
+	}
+	return nil
+}
+
+// sortWithTiebreaker add the uniqueKey field to the sort clauses (ascending), if it is not sorted by that field yet
+func sortWithTiebreaker(sort string, uniqueKey string) string {
+	for _, clause := range strings.Split(sort, ",") {
+		fields := strings.Fields(clause)
+		if len(fields) != 0 && fields[0] == uniqueKey {
+			return sort
+		}
+	}
+	if len(strings.TrimSpace(sort)) == 0 {
+		// default sort of Solr is by score
+		return "score desc," + uniqueKey + " asc"
+	}
+	return sort + "," + uniqueKey + " asc"
+}
@@ -0,0 +1,163 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidateCursorParams(t *testing.T) {
+	testCases := []struct {
+		name   string
+		params url.Values
+		valid  bool
+	}{
+		{"no cursor mark", url.Values{"start": {"10"}}, true},
+		{"tiebreaker in sort", url.Values{"cursorMark": {"*"}, "sort": {"score desc,id asc"}}, true},
+		{"sorted by uniqueKey only", url.Values{"cursorMark": {"*"}, "sort": {"id desc"}}, true},
+		{"zero start", url.Values{"cursorMark": {"*"}, "sort": {"id asc"}, "start": {"0"}}, true},
+		{"non-zero start", url.Values{"cursorMark": {"*"}, "sort": {"id asc"}, "start": {"10"}}, false},
+		{"missing sort", url.Values{"cursorMark": {"AoE"}}, false},
+		{"sort without tiebreaker", url.Values{"cursorMark": {"*"}, "sort": {"timestamp desc"}}, false},
+		{"field with uniqueKey prefix", url.Values{"cursorMark": {"*"}, "sort": {"id_s asc"}}, false},
+	}
+	for _, testCase := range testCases {
+		err := validateCursorParams(testCase.params, "id")
+		if (err == nil) != testCase.valid {
+			t.Errorf("%s: expected valid %v, got: %v", testCase.name, testCase.valid, err)
+		}
+	}
+}
+
+func TestCursorQuery(t *testing.T) {
+	testCases := []struct {
+		sort     string
+		expected string
+	}{
+		{"", "score desc,id asc"},
+		{"timestamp desc", "timestamp desc,id asc"},
+		{"id desc", "id desc"},
+		{"timestamp desc, id desc", "timestamp desc, id desc"},
+	}
+	for _, testCase := range testCases {
+		q := CreateSolrQuery()
+		q.Start(20)
+		if len(testCase.sort) != 0 {
+			q.Sort(testCase.sort)
+		}
+		q.Cursor("id")
+		if sort := q.GetParam("sort"); sort != testCase.expected {
+			t.Errorf("sort %q: expected %q, got %q", testCase.sort, testCase.expected, sort)
+		}
+		if len(q.GetParam("start")) != 0 || q.GetParam("cursorMark") != CursorMarkStart {
+			t.Errorf("sort %q: unexpected cursor params: %v", testCase.sort, q.params.Encode())
+		}
+	}
+}
+
+func newCursorTestClient(t *testing.T, solrConfig *SolrConfig) *SolrClient {
+	solrClient, err := NewSolrClient(solrConfig)
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+	return solrClient
+}
+
+// newCursorTestServer serves numDocs documents in pages with cursorMark, and records the params of the requests
+func newCursorTestServer(t *testing.T, numDocs int) (*httptest.Server, *[]url.Values) {
+	var mutex sync.Mutex
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mutex.Lock()
+		requests = append(requests, r.Form)
+		mutex.Unlock()
+		offset := 0
+		if cursorMark := r.Form.Get("cursorMark"); cursorMark != CursorMarkStart {
+			fmt.Sscanf(cursorMark, "page-%d", &offset)
+		}
+		var docs []string
+		for i := offset; i < numDocs && i < offset+2; i++ {
+			docs = append(docs, fmt.Sprintf(`{"id":"doc-%d"}`, i))
+		}
+		nextCursorMark := fmt.Sprintf("page-%d", offset+len(docs))
+		fmt.Fprintf(w, `{"responseHeader":{"status":0},"response":{"numFound":%d,"start":0,"docs":[%s]},"nextCursorMark":"%s"}`,
+			numDocs, strings.Join(docs, ","), nextCursorMark)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestQueryRejectsInvalidCursor(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+	solrClient := newCursorTestClient(t, &SolrConfig{Url: server.URL, Collection: "test"})
+
+	q := CreateSolrQuery()
+	q.CursorMark(CursorMarkStart)
+	if _, _, err := solrClient.Query(q); err == nil || !strings.Contains(err.Error(), "uniqueKey") {
+		t.Fatalf("expected missing tiebreaker error, got: %v", err)
+	}
+	q.Sort("id asc")
+	q.Start(10)
+	var docs []SolrDocument
+	if _, _, err := solrClient.QueryInto(q, &docs); err == nil || !strings.Contains(err.Error(), "start") {
+		t.Fatalf("expected non-zero start error, got: %v", err)
+	}
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Fatalf("invalid cursor requests should not be sent")
+	}
+}
+
+func TestWalkCursorAddsTiebreaker(t *testing.T) {
+	server, requests := newCursorTestServer(t, 5)
+	solrClient := newCursorTestClient(t, &SolrConfig{Url: server.URL, Collection: "test"})
+
+	q := CreateSolrQuery()
+	q.Sort("timestamp desc")
+	q.CursorMark(CursorMarkStart)
+	q.Start(10)
+	var ids []string
+	err := solrClient.WalkCursor(context.Background(), q, func(doc SolrDocument) error {
+		ids = append(ids, doc["id"].(string))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(ids, ",") != "doc-0,doc-1,doc-2,doc-3,doc-4" {
+		t.Fatalf("unexpected documents: %v", ids)
+	}
+	for _, params := range *requests {
+		if params.Get("sort") != "timestamp desc,id asc" || len(params.Get("start")) != 0 {
+			t.Fatalf("unexpected cursor params: %v", params.Encode())
+		}
+	}
+	if q.GetParam("sort") != "timestamp desc" || q.GetParam("start") != "10" {
+		t.Fatalf("the query of the caller should not be modified: %v", q.params.Encode())
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBodyLength limits how much of a non-JSON error body is kept in the error message
+const maxErrorBodyLength = 512
+
+// maxErrorResponseLength limits how much of an error response is read if the response size is not limited, Solr error
+// objects contain the stack trace, so the body has to be read further than maxErrorBodyLength to decode them
+const maxErrorResponseLength = 128 * maxErrorBodyLength
+
+// SolrError represents a failed Solr call, it holds the HTTP status and the details of the Solr error object (if the response contained any)
+type SolrError struct {
+	HTTPStatus int
+	Code       int
+	Msg        string
+	Metadata   map[string]string
+	Trace      string
+}
+
+// Error returns the string representation of the Solr error
+func (e *SolrError) Error() string {
+	msg := e.Msg
+	if len(msg) == 0 {
+		msg = http.StatusText(e.HTTPStatus)
+	}
+	if e.Code != 0 && e.Code != e.HTTPStatus {
+		return fmt.Sprintf("solr error (http status: %d, code: %d): %s", e.HTTPStatus, e.Code, msg)
+	}
+	return fmt.Sprintf("solr error (http status: %d): %s", e.HTTPStatus, msg)
+}
+
+// ErrorClass returns the Solr exception class name from the error metadata (if it exists)
+func (e *SolrError) ErrorClass() string {
+	return e.Metadata["error-class"]
+}
+
+// RootErrorClass returns the Solr root exception class name from the error metadata (if it exists)
+func (e *SolrError) RootErrorClass() string {
+	return e.Metadata["root-error-class"]
+}
+
+// status returns the Solr error code, or the HTTP status if Solr did not send an error code
+func (e *SolrError) status() int {
+	if e.Code != 0 {
+		return e.Code
+	}
+	return e.HTTPStatus
+}
+
+// newSolrError create a Solr error from an HTTP status and the (possibly non-JSON) response body
+func newSolrError(httpStatus int, errorData *SolrErrorData, body []byte) *SolrError {
+	solrError := &SolrError{HTTPStatus: httpStatus}
+	if errorData != nil {
+		solrError.Code = errorData.Code
+		solrError.Msg = errorData.Msg
+		solrError.Trace = errorData.Trace
+		solrError.Metadata = parseErrorMetadata(errorData.Metadata)
+	} else {
+		solrError.Msg = strings.TrimSpace(string(body))
+		if len(solrError.Msg) > maxErrorBodyLength {
+			solrError.Msg = solrError.Msg[:maxErrorBodyLength] + "..."
+		}
+	}
+	if len(solrError.Msg) == 0 && len(solrError.Trace) != 0 {
+		solrError.Msg = strings.SplitN(solrError.Trace, "\n", 2)[0]
+	}
+	return solrError
+}
+
+// parseErrorMetadata transform Solr error metadata (flat alternating key-value array) into a map
+func parseErrorMetadata(metadata json.RawMessage) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	result := make(map[string]string)
+	var keyValues []interface{}
+	if err := json.Unmarshal(metadata, &keyValues); err == nil {
+		for i := 0; i+1 < len(keyValues); i += 2 {
+			result[fmt.Sprint(keyValues[i])] = fmt.Sprint(keyValues[i+1])
+		}
+		return result
+	}
+	var metadataMap map[string]interface{}
+	if err := json.Unmarshal(metadata, &metadataMap); err == nil {
+		for key, value := range metadataMap {
+			result[key] = fmt.Sprint(value)
+		}
+	}
+	return result
+}
+
+// AsSolrError returns the Solr error from the error chain, or nil if the error was not caused by Solr
+func AsSolrError(err error) *SolrError {
+	var solrError *SolrError
+	if errors.As(err, &solrError) {
+		return solrError
+	}
+	return nil
+}
+
+// IsBadRequest returns true if Solr rejected the request as invalid (e.g. query syntax error or unknown field)
+func IsBadRequest(err error) bool {
+	return hasSolrErrorStatus(err, http.StatusBadRequest)
+}
+
+// IsUnauthorized returns true if Solr rejected the request because of missing or invalid credentials
+func IsUnauthorized(err error) bool {
+	return hasSolrErrorStatus(err, http.StatusUnauthorized)
+}
+
+// IsForbidden returns true if the authenticated user has no permission for the request
+func IsForbidden(err error) bool {
+	return hasSolrErrorStatus(err, http.StatusForbidden)
+}
+
+// IsNotFound returns true if the collection, core or handler does not exist
+func IsNotFound(err error) bool {
+	return hasSolrErrorStatus(err, http.StatusNotFound)
+}
+
+// IsVersionConflict returns true if an optimistic concurrency (_version_) check failed during an update
+func IsVersionConflict(err error) bool {
+	return hasSolrErrorStatus(err, http.StatusConflict)
+}
+
+// IsServerError returns true if Solr failed with a server side error (5xx)
+func IsServerError(err error) bool {
+	solrError := AsSolrError(err)
+	return solrError != nil && (solrError.status() >= 500 || solrError.HTTPStatus >= 500)
+}
+
+func hasSolrErrorStatus(err error, status int) bool {
+	solrError := AsSolrError(err)
+	return solrError != nil && (solrError.status() == status || solrError.HTTPStatus == status)
+}
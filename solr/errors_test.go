@@ -0,0 +1,183 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const solrErrorBody = `{"responseHeader":{"status":400,"QTime":1},"error":{
+	"metadata":["error-class","org.apache.solr.common.SolrException","root-error-class","org.apache.solr.parser.ParseException"],
+	"msg":"org.apache.solr.search.SyntaxError: Cannot parse 'level:(ERROR': Encountered \"<EOF>\"",
+	"trace":"org.apache.solr.common.SolrException: org.apache.solr.search.SyntaxError\n\tat org.apache.solr.handler.component.QueryComponent.prepare",
+	"code":400}}`
+
+func decodeErrorResponse(httpStatus int, body string) error {
+	var solrResponse SolrResponseData
+	return decodeResponseStream(httpStatus, newResponseReader(strings.NewReader(body), 0), &solrResponse)
+}
+
+func TestSolrErrorFromJSONBody(t *testing.T) {
+	err := decodeErrorResponse(400, solrErrorBody)
+	solrError := AsSolrError(err)
+	if solrError == nil {
+		t.Fatalf("expected Solr error, got: %v", err)
+	}
+	if solrError.HTTPStatus != 400 || solrError.Code != 400 || !strings.HasPrefix(solrError.Msg, "org.apache.solr.search.SyntaxError: Cannot parse") {
+		t.Fatalf("unexpected Solr error: %+v", solrError)
+	}
+	expectedMetadata := map[string]string{"error-class": "org.apache.solr.common.SolrException",
+		"root-error-class": "org.apache.solr.parser.ParseException"}
+	if !reflect.DeepEqual(solrError.Metadata, expectedMetadata) {
+		t.Fatalf("unexpected metadata: %v", solrError.Metadata)
+	}
+	if solrError.ErrorClass() != "org.apache.solr.common.SolrException" || solrError.RootErrorClass() != "org.apache.solr.parser.ParseException" {
+		t.Fatalf("unexpected error classes: %s, %s", solrError.ErrorClass(), solrError.RootErrorClass())
+	}
+	if !strings.Contains(solrError.Trace, "\n\tat org.apache.solr.handler.component.QueryComponent.prepare") {
+		t.Fatalf("unexpected trace: %s", solrError.Trace)
+	}
+	if !strings.HasPrefix(err.Error(), "solr error (http status: 400): org.apache.solr.search.SyntaxError") {
+		t.Fatalf("unexpected error message: %s", err.Error())
+	}
+
+	// the error object of a 200 response fails the request as well
+	if solrError := AsSolrError(decodeErrorResponse(200, solrErrorBody)); solrError == nil || solrError.HTTPStatus != 200 || solrError.Code != 400 {
+		t.Fatalf("expected Solr error for an error object in a 200 response, got: %+v", solrError)
+	}
+}
+
+func TestSolrErrorMessages(t *testing.T) {
+	testCases := []struct {
+		name       string
+		httpStatus int
+		body       string
+		expected   string
+	}{
+		{"metadata object and code different from the status", 500,
+			`{"error":{"metadata":{"error-class":"java.lang.IllegalStateException"},"msg":"boom","code":503}}`,
+			"solr error (http status: 500, code: 503): boom"},
+		{"message from the first line of the trace", 500,
+			`{"error":{"trace":"java.lang.NullPointerException\n\tat org.apache.solr.Foo","code":500}}`,
+			"solr error (http status: 500): java.lang.NullPointerException"},
+		{"message from the HTTP status", 503, "", "solr error (http status: 503): Service Unavailable"},
+		{"JSON body without error object", 502, `{"status":"down"}`, `solr error (http status: 502): {"status":"down"}`},
+		{"response header status", 200, `{"responseHeader":{"status":500}}`, "solr error (http status: 200, code: 500): response header status: 500"},
+	}
+	for _, testCase := range testCases {
+		err := decodeErrorResponse(testCase.httpStatus, testCase.body)
+		if err == nil || err.Error() != testCase.expected {
+			t.Errorf("%s: expected %q, got: %v", testCase.name, testCase.expected, err)
+		}
+	}
+	solrError := AsSolrError(decodeErrorResponse(500, `{"error":{"metadata":{"error-class":"java.lang.IllegalStateException"},"code":500}}`))
+	if solrError == nil || solrError.ErrorClass() != "java.lang.IllegalStateException" || solrError.RootErrorClass() != "" {
+		t.Fatalf("unexpected metadata from object: %+v", solrError)
+	}
+}
+
+func TestSolrErrorFromHTMLBody(t *testing.T) {
+	body := "<html>\n<head><title>Error 401 Authentication required</title></head>\n<body><h2>HTTP ERROR 401</h2></body>\n</html>\n"
+	err := decodeErrorResponse(http.StatusUnauthorized, body)
+	solrError := AsSolrError(err)
+	if solrError == nil || solrError.HTTPStatus != 401 || solrError.Code != 0 || solrError.Metadata != nil || len(solrError.Trace) != 0 {
+		t.Fatalf("unexpected Solr error: %+v", solrError)
+	}
+	if solrError.Msg != strings.TrimSpace(body) || !IsUnauthorized(err) {
+		t.Fatalf("the HTML body should be kept as message: %q", solrError.Msg)
+	}
+
+	longBody := "<html>" + strings.Repeat("x", 2*maxErrorBodyLength) + "</html>"
+	solrError = AsSolrError(decodeErrorResponse(http.StatusBadGateway, longBody))
+	if solrError == nil || len(solrError.Msg) != maxErrorBodyLength+3 || !strings.HasSuffix(solrError.Msg, "...") {
+		t.Fatalf("long bodies should be truncated: %d", len(solrError.Msg))
+	}
+
+	// a successful status with a non-JSON body is an error as well
+	if err := decodeErrorResponse(http.StatusOK, "<html>ok</html>"); err == nil || !strings.Contains(err.Error(), "unexpected non-JSON response: <html>ok</html>") {
+		t.Fatalf("expected non-JSON response error, got: %v", err)
+	}
+}
+
+func TestSolrErrorFromServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(solrErrorBody))
+	}))
+	t.Cleanup(server.Close)
+	solrClient, err := NewSolrClient(&SolrConfig{Url: server.URL, Collection: "test"})
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+	_, _, err = solrClient.Query(CreateSolrQuery())
+	solrError := AsSolrError(err)
+	if solrError == nil || solrError.Code != 400 || solrError.ErrorClass() != "org.apache.solr.common.SolrException" || !IsBadRequest(err) {
+		t.Fatalf("expected the Solr error of the response, got: %v", err)
+	}
+}
+
+func TestSolrErrorHelpers(t *testing.T) {
+	helpers := []struct {
+		name  string
+		match func(error) bool
+	}{{"IsBadRequest", IsBadRequest}, {"IsUnauthorized", IsUnauthorized}, {"IsForbidden", IsForbidden}, {"IsNotFound", IsNotFound},
+		{"IsVersionConflict", IsVersionConflict}, {"IsServerError", IsServerError}}
+	testCases := []struct {
+		name     string
+		err      error
+		expected []string
+	}{
+		{"400", &SolrError{HTTPStatus: 400, Code: 400}, []string{"IsBadRequest"}},
+		{"401 without code", &SolrError{HTTPStatus: 401}, []string{"IsUnauthorized"}},
+		{"403", &SolrError{HTTPStatus: 403, Code: 403}, []string{"IsForbidden"}},
+		{"404", &SolrError{HTTPStatus: 404, Code: 404}, []string{"IsNotFound"}},
+		{"409 version conflict", &SolrError{HTTPStatus: 409, Code: 409, Msg: "version conflict for 1 expected=1 actual=2"},
+			[]string{"IsVersionConflict"}},
+		{"wrapped 409", fmt.Errorf("update failed: %w", &SolrError{HTTPStatus: 409, Code: 409}), []string{"IsVersionConflict"}},
+		{"500", &SolrError{HTTPStatus: 500, Code: 500}, []string{"IsServerError"}},
+		{"503", &SolrError{HTTPStatus: 503}, []string{"IsServerError"}},
+		{"code from the response header of a 200 response", &SolrError{HTTPStatus: 200, Code: 400}, []string{"IsBadRequest"}},
+		{"server error code in a 400 response", &SolrError{HTTPStatus: 400, Code: 500}, []string{"IsBadRequest", "IsServerError"}},
+		{"not a Solr error", errors.New("connection refused"), nil},
+		{"nil", nil, nil},
+	}
+	for _, testCase := range testCases {
+		var matched []string
+		for _, helper := range helpers {
+			if helper.match(testCase.err) {
+				matched = append(matched, helper.name)
+			}
+		}
+		if !reflect.DeepEqual(matched, testCase.expected) {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.expected, matched)
+		}
+	}
+
+	// version conflict decoded from the response of an update
+	err := decodeErrorResponse(http.StatusConflict, `{"responseHeader":{"status":409},"error":{"metadata":["error-class",
+		"org.apache.solr.common.SolrException","root-error-class","org.apache.solr.common.SolrException"],
+		"msg":"version conflict for doc1 expected=1 actual=1650000000000000000","code":409}}`)
+	if !IsVersionConflict(err) || IsBadRequest(err) || IsServerError(err) {
+		t.Fatalf("expected version conflict, got: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"iter"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// errExportStopped is returned by the export consumer if the caller stopped the iteration
+var errExportStopped = errors.New("export stopped")
+
+// Export stream every document that matches the query with the /export handler (sorted docValues results without paging),
+// the response is decoded incrementally, so the next documents are read from Solr only when the caller asks for them;
+// the query should set the fl and sort parameters (only docValues fields can be exported), q is *:* by default;
+// the iteration stops after the first error (it is yielded with the zero value of T) or when the context is done
+func Export[T any](ctx context.Context, solrClient *SolrClient, solrQuery *SolrQuery) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		err := solrClient.export(ctx, solrQuery, func(doc map[string]interface{}) error {
+			var typed T
+			if err := unmarshalValue(doc, reflect.ValueOf(&typed).Elem()); err != nil {
+				return err
+			}
+			if !yield(typed, nil) {
+				return errExportStopped
+			}
+			return nil
+		})
+		if err != nil && err != errExportStopped {
+			yield(zero, err)
+		}
+	}
+}
+
+// ExportChannel stream every document that matches the query with the /export handler into a channel (see Export),
+// the export blocks while the documents channel is full; the error channel receives the error of the export (if there is any),
+// both channels are closed at the end of the export; cancel the context to stop the export early
+func (solrClient *SolrClient) ExportChannel(ctx context.Context, solrQuery *SolrQuery, bufferSize int) (<-chan SolrDocument, <-chan error) {
+	docs := make(chan SolrDocument, bufferSize)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(docs)
+		for doc, err := range Export[SolrDocument](ctx, solrClient, solrQuery) {
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case docs <- doc:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return docs, errs
+}
+
+// export send the export request and call the consumer for every document of the response stream
+func (solrClient *SolrClient) export(ctx context.Context, solrQuery *SolrQuery, consume func(doc map[string]interface{}) error) error {
+	if solrQuery == nil || len(solrQuery.GetParam("fl")) == 0 || len(solrQuery.GetParam("sort")) == 0 {
+		return fmt.Errorf("export requires fl and sort parameters")
+	}
+	exportQuery := solrQuery.Clone()
+	if len(exportQuery.GetParam("q")) == 0 {
+		exportQuery.SetParam("q", "*:*")
+	}
+	exportQuery.SetParam("wt", "json")
+	request := &solrRequest{method: "POST", handler: "export", operation: OperationExport, params: *exportQuery.params, idempotent: true}
+
+	ctx, span := solrClient.startSpan(ctx, request)
+	start := time.Now()
+	err := solrClient.withCollectionBreaker(ctx, func() (time.Duration, error) {
+		return solrClient.streamExport(ctx, request, consume)
+	})
+	if err == errExportStopped {
+		solrClient.metrics.ObserveRequest(solrClient.solrConfig.Collection, request.operation, time.Since(start), nil)
+		endSpan(span, nil, nil)
+	} else {
+		solrClient.metrics.ObserveRequest(solrClient.solrConfig.Collection, request.operation, time.Since(start), err)
+		endSpan(span, nil, err)
+	}
+	return err
+}
+
+// streamExport open the export stream on a live node (nodes are failed over until the response is started) and decode it,
+// nodes with open circuit are skipped; the returned latency is the time until the response is started (the circuit breakers
+// use it, so long exports do not count as slow calls)
+func (solrClient *SolrClient) streamExport(ctx context.Context, request *solrRequest, consume func(doc map[string]interface{}) error) (time.Duration, error) {
+	tried := make(map[*solrNode]bool)
+	for {
+		node := solrClient.pickNode(request, tried)
+		if node == nil {
+			return 0, fmt.Errorf("no Solr node is available for collection: %s", solrClient.solrConfig.Collection)
+		}
+		tried[node] = true
+		var nodeBreaker *circuitBreaker
+		if solrClient.breakers != nil {
+			nodeBreaker = solrClient.breakers.get(nodeCircuitKey(node.url))
+			if openErr := nodeBreaker.allow(); openErr != nil {
+				if len(tried) >= solrClient.nodes.size() {
+					return 0, openErr
+				}
+				continue
+			}
+		}
+		start := time.Now()
+		atomic.AddInt64(&node.outstanding, 1)
+		response, err := solrClient.sendToNode(ctx, node, request)
+		latency := time.Since(start)
+		if err != nil {
+			atomic.AddInt64(&node.outstanding, -1)
+			if nodeBreaker != nil {
+				nodeBreaker.recordOutcome(ctx, err, latency)
+			}
+			if ctx.Err() == nil && isNodeFailure(err) {
+				solrClient.nodes.markDead(node)
+				solrClient.reportNodeHealth()
+				if len(tried) < solrClient.nodes.size() {
+					continue
+				}
+			}
+			return latency, err
+		}
+		err = solrClient.decodeExport(ctx, request, response.StatusCode, response.Body, consume)
+		response.Body.Close()
+		atomic.AddInt64(&node.outstanding, -1)
+		if nodeBreaker != nil {
+			nodeBreaker.recordOutcome(ctx, err, latency)
+		}
+		return latency, err
+	}
+}
+
+// decodeExport decode the export response stream document by document
+func (solrClient *SolrClient) decodeExport(ctx context.Context, request *solrRequest, httpStatus int, body io.Reader,
+	consume func(doc map[string]interface{}) error) error {
+	reader := newResponseReader(body, 0)
+	defer func() {
+		solrClient.metrics.ObserveBytes(solrClient.solrConfig.Collection, request.operation, int64(len(request.body)), reader.count)
+	}()
+	if httpStatus < 200 || httpStatus >= 300 {
+		bodyBytes, err := ioutil.ReadAll(io.LimitReader(reader, maxErrorBodyLength*4))
+		if err != nil {
+			return &connectionError{err: err}
+		}
+		return decodeResponse(httpStatus, bodyBytes, nil)
+	}
+	err := decodeExportStream(json.NewDecoder(reader), httpStatus, consume)
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil && err != errExportStopped {
+		return ctxErr
+	}
+	return err
+}
+
+// decodeExportStream decode {"responseHeader":{...},"response":{"numFound":N,"docs":[...]}} without reading the whole stream into memory
+func decodeExportStream(decoder *json.Decoder, httpStatus int, consume func(doc map[string]interface{}) error) error {
+	decoder.UseNumber()
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "responseHeader":
+			var responseHeader SolrResponseHeader
+			if err := decoder.Decode(&responseHeader); err != nil {
+				return err
+			}
+			if responseHeader.Status != 0 {
+				return &SolrError{HTTPStatus: httpStatus, Code: int(responseHeader.Status), Msg: fmt.Sprintf("response header status: %d", responseHeader.Status)}
+			}
+		case "error":
+			var errorData SolrErrorData
+			if err := decoder.Decode(&errorData); err != nil {
+				return err
+			}
+			return newSolrError(httpStatus, &errorData, nil)
+		case "response":
+			if err := decodeExportDocs(decoder, httpStatus, consume); err != nil {
+				return err
+			}
+		default:
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return err
+			}
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+func decodeExportDocs(decoder *json.Decoder, httpStatus int, consume func(doc map[string]interface{}) error) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key != "docs" {
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := expectDelim(decoder, '['); err != nil {
+			return err
+		}
+		for decoder.More() {
+			var doc map[string]interface{}
+			if err := decoder.Decode(&doc); err != nil {
+				return err
+			}
+			if exception, ok := doc["EXCEPTION"]; ok {
+				// the export handler reports failures after the response is started as an exception document
+				return &SolrError{HTTPStatus: httpStatus, Msg: fmt.Sprint(exception)}
+			}
+			if err := consume(doc); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// expectDelim read the next JSON token, it should be the delimiter
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("unexpected JSON token in Solr response: %v (expected %s)", token, delim)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pieceReader returns one piece of the data per read, and counts the pieces that have been read
+type pieceReader struct {
+	pieces []string
+	read   int
+}
+
+func (reader *pieceReader) Read(p []byte) (int, error) {
+	if reader.read >= len(reader.pieces) {
+		return 0, io.EOF
+	}
+	piece := reader.pieces[reader.read]
+	n := copy(p, piece)
+	if n < len(piece) {
+		reader.pieces[reader.read] = piece[n:]
+	} else {
+		reader.read++
+	}
+	return n, nil
+}
+
+// exportPieces returns an export response split into pieces: header, one piece per document, footer
+func exportPieces(numDocs int) []string {
+	pieces := []string{`{"responseHeader":{"status":0},"response":{"numFound":` + fmt.Sprint(numDocs) + `,"docs":[`}
+	for i := 0; i < numDocs; i++ {
+		separator := ""
+		if i > 0 {
+			separator = ","
+		}
+		pieces = append(pieces, fmt.Sprintf(`%s{"id":"doc-%d","seq_num":%d}`, separator, i, i))
+	}
+	return append(pieces, `]}}`)
+}
+
+func newExportTestClient(t *testing.T, solrConfig *SolrConfig) *SolrClient {
+	solrClient, err := NewSolrClient(solrConfig)
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+	return solrClient
+}
+
+func exportQuery() *SolrQuery {
+	q := CreateSolrQuery()
+	q.AddFields([]string{"id", "seq_num"})
+	q.Sort("id asc")
+	return q
+}
+
+func TestDecodeExportStreamIncremental(t *testing.T) {
+	reader := &pieceReader{pieces: exportPieces(100)}
+	var readAtFirstDoc int
+	var docs []map[string]interface{}
+	err := decodeExportStream(json.NewDecoder(reader), 200, func(doc map[string]interface{}) error {
+		if len(docs) == 0 {
+			readAtFirstDoc = reader.read
+		}
+		docs = append(docs, doc)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(docs) != 100 || docs[99]["id"] != "doc-99" || docs[99]["seq_num"] != json.Number("99") {
+		t.Fatalf("unexpected documents: %d, last: %v", len(docs), docs[len(docs)-1])
+	}
+	if readAtFirstDoc > 3 {
+		t.Fatalf("the first document should be consumed before the rest of the stream is read, read pieces: %d", readAtFirstDoc)
+	}
+}
+
+func TestDecodeExportStreamException(t *testing.T) {
+	pieces := exportPieces(3)
+	pieces = append(pieces[:3], `,{"EXCEPTION":"java.io.IOException: early EOF","responseHeader":{"status":400}}`, `]}}`)
+	var consumed int
+	err := decodeExportStream(json.NewDecoder(&pieceReader{pieces: pieces}), 200, func(doc map[string]interface{}) error {
+		consumed++
+		return nil
+	})
+	solrError := AsSolrError(err)
+	if solrError == nil || !strings.Contains(solrError.Msg, "early EOF") {
+		t.Fatalf("expected the exception as SolrError, got: %v", err)
+	}
+	if consumed != 2 {
+		t.Fatalf("the documents before the exception should be consumed, got: %d", consumed)
+	}
+}
+
+func TestDecodeExportStreamStopped(t *testing.T) {
+	reader := &pieceReader{pieces: exportPieces(100)}
+	var consumed int
+	err := decodeExportStream(json.NewDecoder(reader), 200, func(doc map[string]interface{}) error {
+		consumed++
+		if consumed == 2 {
+			return errExportStopped
+		}
+		return nil
+	})
+	if err != errExportStopped {
+		t.Fatalf("expected errExportStopped, got: %v", err)
+	}
+	if consumed != 2 || reader.read > 4 {
+		t.Fatalf("the stream should not be read after the stop, consumed: %d, read pieces: %d", consumed, reader.read)
+	}
+}
+
+func TestExportStopEarly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/test/export") {
+			http.NotFound(w, r)
+			return
+		}
+		for _, piece := range exportPieces(1000) {
+			w.Write([]byte(piece))
+		}
+	}))
+	defer server.Close()
+	solrClient := newExportTestClient(t, &SolrConfig{Url: server.URL, Collection: "test"})
+
+	var ids []string
+	for doc, err := range Export[SolrDocument](context.Background(), solrClient, exportQuery()) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ids = append(ids, doc["id"].(string))
+		if len(ids) == 3 {
+			break
+		}
+	}
+	if strings.Join(ids, ",") != "doc-0,doc-1,doc-2" {
+		t.Fatalf("unexpected documents: %v", ids)
+	}
+}
+
+func TestExportChannelContextCancel(t *testing.T) {
+	requestDone := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(requestDone)
+		w.Write([]byte(`{"responseHeader":{"status":0},"response":{"numFound":-1,"docs":[`))
+		// an endless export, it is written until the client goes away
+		for i := 0; ; i++ {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			if _, err := fmt.Fprintf(w, `{"id":"doc-%d"}`, i); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}))
+	defer server.Close()
+	solrClient := newExportTestClient(t, &SolrConfig{Url: server.URL, Collection: "test"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	docs, errs := solrClient.ExportChannel(ctx, exportQuery(), 0)
+	if doc := <-docs; doc["id"] != "doc-0" {
+		t.Fatalf("unexpected first document: %v", doc)
+	}
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for docs != nil {
+		select {
+		case _, ok := <-docs:
+			if !ok {
+				docs = nil
+			}
+		case <-timeout:
+			t.Fatalf("the documents channel is not closed after the cancellation")
+		}
+	}
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got: %v", err)
+		}
+	case <-timeout:
+		t.Fatalf("the error channel is not closed after the cancellation")
+	}
+	select {
+	case <-requestDone:
+	case <-timeout:
+		t.Fatalf("the export request is not closed after the cancellation")
+	}
+}
+
+func TestExportCollectionCircuitOpen(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	breakerConfig := DefaultCircuitBreakerConfig()
+	breakerConfig.MinimumRequests = 1
+	solrClient := newExportTestClient(t, &SolrConfig{Url: server.URL, Collection: "test", CircuitBreakerConfig: breakerConfig})
+
+	for i := 0; i < 2; i++ {
+		for _, err := range Export[SolrDocument](context.Background(), solrClient, exportQuery()) {
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if i == 1 && !IsCircuitOpen(err) {
+				t.Fatalf("expected open circuit, got: %v", err)
+			}
+		}
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("the export should not be sent while the circuit is open, requests: %d", requests)
+	}
+	if state := solrClient.CircuitBreakerStates()[collectionCircuitKey("test")]; state != CircuitOpen {
+		t.Fatalf("expected open collection circuit, got: %s", state)
+	}
+}
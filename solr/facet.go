@@ -0,0 +1,207 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// FacetCounts holds the typed facet_counts section of a response (classic facet parameters)
+type FacetCounts struct {
+	FacetQueries   map[string]int64               `json:"facet_queries"`
+	FacetFields    map[string]FacetValues         `json:"facet_fields"`
+	FacetRanges    map[string]*RangeFacetCounts   `json:"facet_ranges"`
+	FacetIntervals map[string]FacetValues         `json:"facet_intervals"`
+	FacetPivot     map[string][]*PivotFacetCounts `json:"facet_pivot"`
+}
+
+// FacetValue is a value of a facet with its count
+type FacetValue struct {
+	Value string
+	Count int64
+}
+
+// FacetValues holds the facet values in the order of the response, it can be decoded from the flat [value, count, ...] array
+// (default json.nl format) or from an object (json.nl=map, facet intervals)
+type FacetValues []FacetValue
+
+// RangeFacetCounts is the result of a facet.range field
+type RangeFacetCounts struct {
+	Counts  FacetValues `json:"counts"`
+	Start   interface{} `json:"start"`
+	End     interface{} `json:"end"`
+	Gap     interface{} `json:"gap"`
+	Before  int64       `json:"before"`
+	After   int64       `json:"after"`
+	Between int64       `json:"between"`
+}
+
+// PivotFacetCounts is a node of a facet.pivot result
+type PivotFacetCounts struct {
+	Field   string                       `json:"field"`
+	Value   interface{}                  `json:"value"`
+	Count   int64                        `json:"count"`
+	Pivot   []*PivotFacetCounts          `json:"pivot"`
+	Queries map[string]int64             `json:"queries"`
+	Ranges  map[string]*RangeFacetCounts `json:"ranges"`
+}
+
+// AddFacetQuery add a query facet (facet.query), the count of the documents that match the query is returned
+// in the facet_queries section (FacetQuery adds a filter query instead)
+func (q *SolrQuery) AddFacetQuery(query string) {
+	q.SetParam("facet", "true")
+	q.AddParam("facet.query", query)
+}
+
+// FacetRange add a range facet for a field (facet.range), the start, end and gap are set as per-field parameters,
+// e.g. FacetRange("logtime", "NOW-1DAY", "NOW", "+1HOUR")
+func (q *SolrQuery) FacetRange(field string, start string, end string, gap string) {
+	q.SetParam("facet", "true")
+	q.AddParam("facet.range", field)
+	q.FieldFacetParam(field, "range.start", start)
+	q.FieldFacetParam(field, "range.end", end)
+	q.FieldFacetParam(field, "range.gap", gap)
+}
+
+// FacetInterval add an interval facet for a field (facet.interval), e.g. FacetInterval("seq_num", "[0,100)", "[100,*]")
+func (q *SolrQuery) FacetInterval(field string, intervals ...string) {
+	q.SetParam("facet", "true")
+	q.AddParam("facet.interval", field)
+	for _, interval := range intervals {
+		q.params.Add(fmt.Sprintf("f.%s.facet.interval.set", field), interval)
+	}
+}
+
+// FacetMinCount sets the minimum count of the facet values (facet.mincount)
+func (q *SolrQuery) FacetMinCount(minCount int) {
+	q.SetParam("facet.mincount", strconv.Itoa(minCount))
+}
+
+// FacetLimit sets the maximum number of facet values per field (facet.limit, -1 means unlimited)
+func (q *SolrQuery) FacetLimit(limit int) {
+	q.SetParam("facet.limit", strconv.Itoa(limit))
+}
+
+// FacetSort sets the sort of the facet values (facet.sort: count or index)
+func (q *SolrQuery) FacetSort(sort string) {
+	q.SetParam("facet.sort", sort)
+}
+
+// FacetPrefix sets the prefix of the facet values (facet.prefix)
+func (q *SolrQuery) FacetPrefix(prefix string) {
+	q.SetParam("facet.prefix", prefix)
+}
+
+// FieldFacetParam sets a per-field facet parameter (f.<field>.facet.<param>), e.g. FieldFacetParam("level", "limit", "5")
+func (q *SolrQuery) FieldFacetParam(field string, param string, value string) {
+	q.SetParam(fmt.Sprintf("f.%s.facet.%s", field, param), value)
+}
+
+// ParseFacetCounts returns the typed facet_counts section of the response (nil if the response has no facet counts),
+// responses of the client are decoded into the typed section once, while they are read, other responses (e.g. decoded
+// with json.Unmarshal) are converted from the FacetCounts map
+func (solrResponse *SolrResponseData) ParseFacetCounts() (*FacetCounts, error) {
+	if solrResponse.FacetCounts == nil {
+		return nil, nil
+	}
+	if solrResponse.facetCounts != nil {
+		return solrResponse.facetCounts, nil
+	}
+	raw, err := json.Marshal(solrResponse.FacetCounts)
+	if err != nil {
+		return nil, err
+	}
+	var facetCounts FacetCounts
+	if err := json.Unmarshal(raw, &facetCounts); err != nil {
+		return nil, err
+	}
+	return &facetCounts, nil
+}
+
+// decodeFacetCounts decode the typed facet_counts section, if it cannot be decoded, ParseFacetCounts returns the error
+func (solrResponse *SolrResponseData) decodeFacetCounts(raw json.RawMessage) {
+	var facetCounts FacetCounts
+	if err := json.Unmarshal(raw, &facetCounts); err == nil {
+		solrResponse.facetCounts = &facetCounts
+	}
+}
+
+// Count returns the count of a facet value (0 if the value does not exist)
+func (values FacetValues) Count(value string) int64 {
+	for _, facetValue := range values {
+		if facetValue.Value == value {
+			return facetValue.Count
+		}
+	}
+	return 0
+}
+
+// UnmarshalJSON decode the facet values from a flat [value, count, ...] array or from an object (keeping the order)
+func (values *FacetValues) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	token, err := decoder.Token()
+	if err != nil || token == nil {
+		return err
+	}
+	facetValues := FacetValues{}
+	switch token {
+	case json.Delim('['):
+		for decoder.More() {
+			var value interface{}
+			if err := decoder.Decode(&value); err != nil {
+				return err
+			}
+			if !decoder.More() {
+				return fmt.Errorf("facet value without count: %v", value)
+			}
+			var count int64
+			if err := decoder.Decode(&count); err != nil {
+				return err
+			}
+			facetValues = append(facetValues, FacetValue{Value: facetValueString(value), Count: count})
+		}
+	case json.Delim('{'):
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			var count int64
+			if err := decoder.Decode(&count); err != nil {
+				return err
+			}
+			facetValues = append(facetValues, FacetValue{Value: fmt.Sprint(key), Count: count})
+		}
+	default:
+		return fmt.Errorf("unexpected JSON token in facet values: %v", token)
+	}
+	*values = facetValues
+	return nil
+}
+
+// facetValueString returns the string form of a facet value (the missing value bucket is null)
+func facetValueString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if text, ok := value.(string); ok {
+		return text
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFacetValuesUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected FacetValues
+		invalid  bool
+	}{
+		{name: "flat array", data: `["INFO",80,"ERROR",20,"WARN",0]`,
+			expected: FacetValues{{"INFO", 80}, {"ERROR", 20}, {"WARN", 0}}},
+		{name: "json.nl=map", data: `{"INFO":80,"ERROR":20}`,
+			expected: FacetValues{{"INFO", 80}, {"ERROR", 20}}},
+		{name: "numeric values", data: `[404,3,500,1]`,
+			expected: FacetValues{{"404", 3}, {"500", 1}}},
+		{name: "null missing bucket", data: `["INFO",80,null,5]`,
+			expected: FacetValues{{"INFO", 80}, {"", 5}}},
+		{name: "empty array", data: `[]`, expected: FacetValues{}},
+		{name: "null", data: `null`, expected: nil},
+		{name: "odd-length array", data: `["INFO",80,"ERROR"]`, invalid: true},
+		{name: "non-numeric count", data: `["INFO","80"]`, invalid: true},
+		{name: "scalar", data: `"INFO"`, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var values FacetValues
+			err := json.Unmarshal([]byte(test.data), &values)
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("expected error, got: %v", values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(values, test.expected) {
+				t.Fatalf("unexpected facet values: %v, expected: %v", values, test.expected)
+			}
+		})
+	}
+	values := FacetValues{{"INFO", 80}, {"", 5}}
+	if values.Count("INFO") != 80 || values.Count("") != 5 || values.Count("DEBUG") != 0 {
+		t.Fatalf("unexpected counts: %v", values)
+	}
+}
+
+// parseFacetCountsFixture decode a response with the facet_counts section, and parse the typed facet counts
+func parseFacetCountsFixture(t *testing.T, facetCounts string) *FacetCounts {
+	var solrResponse SolrResponseData
+	if err := json.Unmarshal([]byte(`{"responseHeader":{"status":0},"facet_counts":`+facetCounts+`}`), &solrResponse); err != nil {
+		t.Fatalf("cannot decode response: %v", err)
+	}
+	counts, err := solrResponse.ParseFacetCounts()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return counts
+}
+
+func TestParseFacetCountsFieldsAndQueries(t *testing.T) {
+	counts := parseFacetCountsFixture(t, `{"facet_queries":{"level:ERROR":20},"facet_fields":{"level":["INFO",80,"ERROR",20]}}`)
+	if counts.FacetQueries["level:ERROR"] != 20 || counts.FacetFields["level"].Count("INFO") != 80 {
+		t.Fatalf("unexpected facet counts: %+v", counts)
+	}
+	var empty SolrResponseData
+	if counts, err := empty.ParseFacetCounts(); counts != nil || err != nil {
+		t.Fatalf("expected nil facet counts, got: %v, %v", counts, err)
+	}
+}
+
+func TestParseFacetCountsDecodedWithResponse(t *testing.T) {
+	body := `{"responseHeader":{"status":0},"response":{"numFound":0,"start":0,"docs":[]},
+		"facet_counts":{"facet_queries":{"level:ERROR":20},"facet_fields":{"level":["INFO",80,"ERROR",20]}}}`
+	var solrResponse SolrResponseData
+	if err := decodeResponseStream(200, newResponseReader(strings.NewReader(body), 0), &solrResponse); err != nil {
+		t.Fatalf("cannot decode response: %v", err)
+	}
+	if solrResponse.facetCounts == nil {
+		t.Fatalf("the typed facet counts should be decoded with the response")
+	}
+	// the untyped section is kept
+	if fields, ok := solrResponse.FacetCounts["facet_fields"].(map[string]interface{}); !ok || fields["level"] == nil {
+		t.Fatalf("unexpected untyped facet counts: %v", solrResponse.FacetCounts)
+	}
+	counts, err := solrResponse.ParseFacetCounts()
+	if err != nil || counts != solrResponse.facetCounts {
+		t.Fatalf("expected the facet counts decoded with the response, got: %v, %v", counts, err)
+	}
+	if counts.FacetQueries["level:ERROR"] != 20 || counts.FacetFields["level"].Count("ERROR") != 20 {
+		t.Fatalf("unexpected facet counts: %+v", counts)
+	}
+
+	// a section that does not fit the typed counts fails only when it is parsed
+	body = `{"responseHeader":{"status":0},"facet_counts":{"facet_queries":{"level:ERROR":"many"}}}`
+	solrResponse = SolrResponseData{}
+	if err := decodeResponseStream(200, newResponseReader(strings.NewReader(body), 0), &solrResponse); err != nil {
+		t.Fatalf("cannot decode response: %v", err)
+	}
+	if counts, err := solrResponse.ParseFacetCounts(); err == nil {
+		t.Fatalf("expected error for invalid facet counts, got: %+v", counts)
+	}
+}
+
+func TestParseFacetCountsRanges(t *testing.T) {
+	counts := parseFacetCountsFixture(t, `{"facet_ranges":{
+		"logtime":{"counts":["2018-11-10T10:00:00Z",5,"2018-11-10T11:00:00Z",7],"gap":"+1HOUR","start":"2018-11-10T10:00:00Z","end":"2018-11-10T12:00:00Z","before":1,"after":2,"between":12},
+		"seq_num":{"counts":[0,10,100,3],"gap":100,"start":0,"end":200}}}`)
+	logtime := counts.FacetRanges["logtime"]
+	if logtime == nil || logtime.Counts.Count("2018-11-10T11:00:00Z") != 7 || logtime.Gap != "+1HOUR" ||
+		logtime.Before != 1 || logtime.After != 2 || logtime.Between != 12 {
+		t.Fatalf("unexpected range facet: %+v", logtime)
+	}
+	seqNum := counts.FacetRanges["seq_num"]
+	if seqNum == nil || !reflect.DeepEqual(seqNum.Counts, FacetValues{{"0", 10}, {"100", 3}}) || seqNum.Gap != float64(100) {
+		t.Fatalf("unexpected range facet: %+v", seqNum)
+	}
+}
+
+func TestParseFacetCountsIntervals(t *testing.T) {
+	counts := parseFacetCountsFixture(t, `{"facet_intervals":{"seq_num":{"[0,100)":40,"[100,*]":60}}}`)
+	intervals := counts.FacetIntervals["seq_num"]
+	if len(intervals) != 2 || intervals.Count("[0,100)") != 40 || intervals.Count("[100,*]") != 60 {
+		t.Fatalf("unexpected interval facet: %v", intervals)
+	}
+}
+
+func TestParseFacetCountsPivot(t *testing.T) {
+	counts := parseFacetCountsFixture(t, `{"facet_pivot":{"level,host":[
+		{"field":"level","value":"INFO","count":80,"pivot":[{"field":"host","value":"host1","count":50},{"field":"host","value":"host2","count":30}]},
+		{"field":"level","value":"ERROR","count":20,"queries":{"type:ambari":4},"ranges":{"seq_num":{"counts":[0,20],"gap":100,"start":0,"end":100}}}]}}`)
+	pivots := counts.FacetPivot["level,host"]
+	if len(pivots) != 2 {
+		t.Fatalf("unexpected pivot facet: %+v", pivots)
+	}
+	info := pivots[0]
+	if info.Field != "level" || info.Value != "INFO" || info.Count != 80 || len(info.Pivot) != 2 ||
+		info.Pivot[1].Value != "host2" || info.Pivot[1].Count != 30 {
+		t.Fatalf("unexpected pivot node: %+v", info)
+	}
+	errorNode := pivots[1]
+	if errorNode.Queries["type:ambari"] != 4 || errorNode.Ranges["seq_num"].Counts.Count("0") != 20 || errorNode.Pivot != nil {
+		t.Fatalf("unexpected pivot node: %+v", errorNode)
+	}
+}
+
+func TestFacetQueryParams(t *testing.T) {
+	q := CreateSolrQuery()
+	q.FacetQuery("level:ERROR")
+	q.AddFacetQuery("level:WARN")
+	q.AddPivotFields([]string{"level", "host"})
+	encoded := q.Encode()
+	for _, param := range []string{"fq=level%3AERROR", "facet.query=level%3AWARN", "facet.pivot=level%2Chost", "facet=true"} {
+		if !strings.Contains(encoded, param) {
+			t.Errorf("%s is missing from the query: %s", param, encoded)
+		}
+	}
+}
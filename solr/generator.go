@@ -24,6 +24,8 @@ import (
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"net"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
@@ -45,19 +47,50 @@ func (p SolrDataProcessor) Process(batchContext *processor.BatchContext) error {
 	return err
 }
 
-// HandleError handle errors during time based buffer processing (it is not used by this generator)
+// HandleError handle errors during time based buffer processing (it is not used by this generator), errors are written to the logger of the Solr client
 func (p SolrDataProcessor) HandleError(batchContext *processor.BatchContext, err error) {
-	fmt.Println(err)
+	if p.SolrClient == nil {
+		log.Println(err)
+		return
+	}
+	p.SolrClient.logger.Error("Processing Solr data failed", LogField("error", err))
+}
+
+// generatorSolrConfig returns a copy of the Solr config with a retry policy that retries updates as well: generated documents
+// have unique ids, so sending them again is safe (the config and the policy of the caller are not modified)
+func generatorSolrConfig(solrConfig *SolrConfig) *SolrConfig {
+	generatorConfig := *solrConfig
+	if solrConfig.RetryPolicy != nil {
+		policy := *solrConfig.RetryPolicy
+		policy.IdempotentOperations = map[Operation]bool{OperationUpdate: true}
+		for operation, idempotent := range solrConfig.RetryPolicy.IdempotentOperations {
+			if operation != OperationUpdate {
+				policy.IdempotentOperations[operation] = idempotent
+			}
+		}
+		generatorConfig.RetryPolicy = &policy
+	}
+	return &generatorConfig
 }
 
 // GenerateSolrData Use to generate Solr data, also scp keytab file to local if kerberos and ssl config is enabled
-func GenerateSolrData(solrConfig *SolrConfig, sshConfig *SSHConfig, iniFileLocation string) {
-	if sshConfig.Enabled {
+func GenerateSolrData(solrConfig *SolrConfig, sshConfig *SSHConfig, iniFileLocation string) error {
+	var kerberosConfig *KerberosConfig
+	if solrConfig.SecurityConfig != nil {
+		kerberosConfig = solrConfig.SecurityConfig.kerberosConfig
+	}
+	if sshConfig.Enabled && kerberosConfig == nil {
+		log.Println("Kerberos is not configured, krb5.conf and keytab are not copied from " + sshConfig.Hostname)
+	}
+	if sshConfig.Enabled && kerberosConfig != nil {
 		privateKeyContent, err := ioutil.ReadFile(sshConfig.PrivateKeyPath)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(privateKeyContent))
+		if err != nil {
+			return err
 		}
-		signer, _ := ssh.ParsePrivateKey([]byte(privateKeyContent))
 		clientConfig := &ssh.ClientConfig{
 			User: sshConfig.Username,
 			Auth: []ssh.AuthMethod{
@@ -67,21 +100,25 @@ func GenerateSolrData(solrConfig *SolrConfig, sshConfig *SSHConfig, iniFileLocat
 		}
 		client, err := ssh.Dial("tcp", sshConfig.Hostname+":22", clientConfig)
 		if err != nil {
-			panic("Failed to dial: " + err.Error())
+			return fmt.Errorf("failed to dial: %v", err)
 		}
 		sftpClient, err := sftp.NewClient(client)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer sftpClient.Close()
 
-		copyFileToLocal(sshConfig.RemoteKrb5Conf, solrConfig.SecurityConfig.kerberosConfig.krb5confPath, sftpClient)
-		copyFileToLocal(sshConfig.RemoteKeytab, solrConfig.SecurityConfig.kerberosConfig.keytab, sftpClient)
+		if err := copyFileToLocal(sshConfig.RemoteKrb5Conf, kerberosConfig.krb5confPath, sftpClient); err != nil {
+			return err
+		}
+		if err := copyFileToLocal(sshConfig.RemoteKeytab, kerberosConfig.keytab, sftpClient); err != nil {
+			return err
+		}
 	}
 
 	cfg, err := ini.Load(iniFileLocation)
 	if err != nil {
-		log.Fatal("Fail to read file: " + iniFileLocation)
+		return fmt.Errorf("fail to read file %s: %v", iniFileLocation, err)
 	}
 	numWrites, _ := cfg.Section("generator").Key("num_writes").Int()
 	docsPerWrite, _ := cfg.Section("generator").Key("num_docs_per_write").Int()
@@ -96,11 +133,27 @@ func GenerateSolrData(solrConfig *SolrConfig, sshConfig *SSHConfig, iniFileLocat
 	dateField := cfg.Section("generator").Key("date_field").String()
 	messageFields := strings.Split(cfg.Section("generator").Key("message_fields").String(), ",")
 	numFields := strings.Split(cfg.Section("generator").Key("num_fields").String(), ",")
+	metricsAddress := cfg.Section("generator").Key("metrics_address").String()
 
-	solrClient, err := NewSolrClient(solrConfig)
+	options := []ClientOption{WithLogger(NewStdLogger(nil, LevelInfo))}
+	if len(metricsAddress) != 0 {
+		metrics := NewPrometheusMetrics()
+		listener, err := net.Listen("tcp", metricsAddress)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		go http.Serve(listener, mux)
+		log.Println("Serving client metrics on http://" + listener.Addr().String() + "/metrics")
+		options = append(options, WithMetrics(metrics))
+	}
+	solrClient, err := NewSolrClient(generatorSolrConfig(solrConfig), options...)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer solrClient.Close()
 
 	batchContext := processor.CreateDefaultBatchContext()
 	batchContext.MaxBufferSize = docsPerWrite
@@ -118,8 +171,11 @@ func GenerateSolrData(solrConfig *SolrConfig, sshConfig *SSHConfig, iniFileLocat
 		randomMsg := fmt.Sprintf("Sending %d documents to Solr: %d/%d ...", docsPerWrite, i, numWrites)
 		log.Println(randomMsg)
 	}
-	proc.Process(batchContext)
+	if err := proc.Process(batchContext); err != nil {
+		return err
+	}
 	log.Println("Solr random documents generation has finished.")
+	return nil
 }
 
 func createRandomSolrDoc(clusterField string, clusterNum int, filterableField string, filterableFieldNum int, levelField string, levels []string,
@@ -140,16 +196,17 @@ func createRandomSolrDoc(clusterField string, clusterNum int, filterableField st
 	return solrDoc
 }
 
-func copyFileToLocal(srcFilePath string, destFilePath string, sftpClient *sftp.Client) {
+func copyFileToLocal(srcFilePath string, destFilePath string, sftpClient *sftp.Client) error {
 	srcFile, err := sftpClient.Open(srcFilePath)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer srcFile.Close()
 	destFile, err := os.Create(destFilePath)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer destFile.Close()
-	srcFile.WriteTo(destFile)
+	_, err = srcFile.WriteTo(destFile)
+	return err
 }
@@ -0,0 +1,88 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"strconv"
+)
+
+// GroupedResult is the result of a grouping command (group.field, group.query or group.func) of the grouped section
+type GroupedResult struct {
+	Matches int64 `json:"matches"`
+	// NGroups is the number of groups (only if group.ngroups is enabled)
+	NGroups int64   `json:"ngroups,omitempty"`
+	Groups  []Group `json:"groups,omitempty"`
+	// DocList holds the documents of a group.query command (or of every command with group.format=simple)
+	DocList *SolrResponse `json:"doclist,omitempty"`
+}
+
+// Group is a group of documents with the same group value
+type Group struct {
+	GroupValue interface{}  `json:"groupValue"`
+	DocList    SolrResponse `json:"doclist"`
+}
+
+// GroupField group the results by the values of a field (group.field)
+func (q *SolrQuery) GroupField(field string) {
+	q.SetParam("group", "true")
+	q.AddParam("group.field", field)
+}
+
+// GroupQuery add a group of the documents that match the query (group.query)
+func (q *SolrQuery) GroupQuery(query string) {
+	q.SetParam("group", "true")
+	q.AddParam("group.query", query)
+}
+
+// GroupFunc group the results by the values of a function query (group.func)
+func (q *SolrQuery) GroupFunc(function string) {
+	q.SetParam("group", "true")
+	q.AddParam("group.func", function)
+}
+
+// GroupLimit sets the number of documents per group (group.limit)
+func (q *SolrQuery) GroupLimit(limit int) {
+	q.SetParam("group.limit", strconv.Itoa(limit))
+}
+
+// GroupOffset sets the offset of the documents per group (group.offset)
+func (q *SolrQuery) GroupOffset(offset int) {
+	q.SetParam("group.offset", strconv.Itoa(offset))
+}
+
+// GroupSort sets the sort of the documents within the groups (group.sort)
+func (q *SolrQuery) GroupSort(sort string) {
+	q.SetParam("group.sort", sort)
+}
+
+// GroupNGroups request the number of groups (group.ngroups)
+func (q *SolrQuery) GroupNGroups() {
+	q.SetParam("group.ngroups", "true")
+}
+
+// Collapse add a collapsing filter query ({!collapse field=...}), only the top document of every field value is returned,
+// use FilterQueryExpr with LocalParams("collapse") for the other collapse parameters (e.g. min, max, nullPolicy)
+func (q *SolrQuery) Collapse(field string) {
+	q.FilterQueryExpr(LocalParams("collapse").Param("field", field))
+}
+
+// Expand request the collapsed documents of the returned groups in the expanded section (expand=true), rows is the number
+// of documents per group (expand.rows, the Solr default is used if it is not positive)
+func (q *SolrQuery) Expand(rows int) {
+	q.SetParam("expand", "true")
+	if rows > 0 {
+		q.SetParam("expand.rows", strconv.Itoa(rows))
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeGroupingFixture(t *testing.T, body string) *SolrResponseData {
+	var solrResponse SolrResponseData
+	if err := decodeResponseStream(200, newResponseReader(strings.NewReader(body), 0), &solrResponse); err != nil {
+		t.Fatalf("cannot decode fixture: %v", err)
+	}
+	return &solrResponse
+}
+
+func TestGroupFieldResponse(t *testing.T) {
+	solrResponse := decodeGroupingFixture(t, `{"responseHeader":{"status":0},"grouped":{"host":{"matches":7,"ngroups":3,"groups":[
+		{"groupValue":"host1","doclist":{"numFound":4,"start":0,"maxScore":1.5,"docs":[{"id":"1"},{"id":"2"}]}},
+		{"groupValue":42,"doclist":{"numFound":2,"start":0,"docs":[{"id":"3"}]}},
+		{"groupValue":null,"doclist":{"numFound":1,"start":0,"docs":[{"id":"4"}]}}]}}}`)
+	host := solrResponse.Grouped["host"]
+	if host == nil || host.Matches != 7 || host.NGroups != 3 || len(host.Groups) != 3 || host.DocList != nil {
+		t.Fatalf("unexpected group.field result: %+v", host)
+	}
+	first := host.Groups[0]
+	if first.GroupValue != "host1" || first.DocList.NumFound != 4 || first.DocList.MaxScore != 1.5 || len(first.DocList.Docs) != 2 ||
+		first.DocList.Docs[1]["id"] != "2" {
+		t.Fatalf("unexpected group: %+v", first)
+	}
+	if host.Groups[1].GroupValue != 42.0 || host.Groups[2].GroupValue != nil {
+		t.Fatalf("unexpected group values: %#v %#v", host.Groups[1].GroupValue, host.Groups[2].GroupValue)
+	}
+}
+
+func TestGroupQueryResponse(t *testing.T) {
+	solrResponse := decodeGroupingFixture(t, `{"responseHeader":{"status":0},"grouped":{
+		"level:ERROR":{"matches":7,"doclist":{"numFound":2,"start":0,"docs":[{"id":"5"},{"id":"6"}]}},
+		"level:FATAL":{"matches":7,"doclist":{"numFound":0,"start":0,"docs":[]}}}}`)
+	errors := solrResponse.Grouped["level:ERROR"]
+	if errors == nil || errors.Matches != 7 || len(errors.Groups) != 0 || errors.DocList == nil || errors.DocList.NumFound != 2 ||
+		errors.DocList.Docs[0]["id"] != "5" {
+		t.Fatalf("unexpected group.query result: %+v", errors)
+	}
+	if fatal := solrResponse.Grouped["level:FATAL"]; fatal == nil || fatal.DocList == nil || fatal.DocList.NumFound != 0 {
+		t.Fatalf("unexpected empty group.query result: %+v", fatal)
+	}
+}
+
+func TestGroupFormatSimpleResponse(t *testing.T) {
+	solrResponse := decodeGroupingFixture(t, `{"responseHeader":{"status":0},"grouped":{"host":{"matches":7,"ngroups":2,
+		"doclist":{"numFound":7,"start":0,"docs":[{"id":"1","host":"host1"},{"id":"3","host":"host2"}]}}}}`)
+	host := solrResponse.Grouped["host"]
+	if host == nil || host.NGroups != 2 || len(host.Groups) != 0 || host.DocList == nil || host.DocList.NumFound != 7 ||
+		len(host.DocList.Docs) != 2 || host.DocList.Docs[1]["host"] != "host2" {
+		t.Fatalf("unexpected group.format=simple result: %+v", host)
+	}
+}
+
+func TestExpandedResponse(t *testing.T) {
+	solrResponse := decodeGroupingFixture(t, `{"responseHeader":{"status":0},
+		"response":{"numFound":2,"start":0,"docs":[{"id":"1","host":"host1"},{"id":"3","host":"host2"}]},
+		"expanded":{"host1":{"numFound":3,"start":0,"docs":[{"id":"2"},{"id":"7"}]},"host2":{"numFound":0,"start":0,"docs":[]}}}`)
+	if solrResponse.Response.NumFound != 2 || len(solrResponse.Response.Docs) != 2 {
+		t.Fatalf("unexpected collapsed documents: %+v", solrResponse.Response)
+	}
+	host1 := solrResponse.Expanded["host1"]
+	if host1.NumFound != 3 || len(host1.Docs) != 2 || host1.Docs[1]["id"] != "7" {
+		t.Fatalf("unexpected expanded group: %+v", host1)
+	}
+	if host2, ok := solrResponse.Expanded["host2"]; !ok || host2.NumFound != 0 || len(solrResponse.Expanded) != 2 {
+		t.Fatalf("unexpected expanded section: %+v", solrResponse.Expanded)
+	}
+}
+
+func TestGroupingParams(t *testing.T) {
+	q := CreateSolrQuery()
+	q.GroupField("host")
+	q.GroupField("level")
+	q.GroupQuery("level:ERROR")
+	q.GroupFunc("ms(NOW,logtime)")
+	q.GroupLimit(5)
+	q.GroupOffset(10)
+	q.GroupSort("logtime desc")
+	q.GroupNGroups()
+	expected := url.Values{
+		"group":         {"true"},
+		"group.field":   {"host", "level"},
+		"group.query":   {"level:ERROR"},
+		"group.func":    {"ms(NOW,logtime)"},
+		"group.limit":   {"5"},
+		"group.offset":  {"10"},
+		"group.sort":    {"logtime desc"},
+		"group.ngroups": {"true"},
+	}
+	if !reflect.DeepEqual(*q.params, expected) {
+		t.Fatalf("unexpected grouping params: %v", q.params.Encode())
+	}
+}
+
+func TestCollapseExpandParams(t *testing.T) {
+	testCases := []struct {
+		name     string
+		build    func(q *SolrQuery)
+		expected url.Values
+	}{
+		{"collapse", func(q *SolrQuery) { q.Collapse("host") }, url.Values{"fq": {"{!collapse field='host'}"}}},
+		{"collapse with filter", func(q *SolrQuery) {
+			q.AddParam("fq", "level:ERROR")
+			q.Collapse("host")
+		}, url.Values{"fq": {"level:ERROR", "{!collapse field='host'}"}}},
+		{"collapse parameters", func(q *SolrQuery) {
+			q.FilterQueryExpr(LocalParams("collapse").Param("field", "host").Param("max", "logtime").Param("nullPolicy", "expand"))
+		}, url.Values{"fq": {"{!collapse field='host' max='logtime' nullPolicy='expand'}"}}},
+		{"expand", func(q *SolrQuery) { q.Expand(5) }, url.Values{"expand": {"true"}, "expand.rows": {"5"}}},
+		{"expand with default rows", func(q *SolrQuery) { q.Expand(0) }, url.Values{"expand": {"true"}}},
+		{"collapse and expand", func(q *SolrQuery) {
+			q.Collapse("host")
+			q.Expand(3)
+		}, url.Values{"fq": {"{!collapse field='host'}"}, "expand": {"true"}, "expand.rows": {"3"}}},
+	}
+	for _, testCase := range testCases {
+		q := CreateSolrQuery()
+		testCase.build(q)
+		if !reflect.DeepEqual(*q.params, testCase.expected) {
+			t.Errorf("%s: unexpected params: %v", testCase.name, q.params.Encode())
+		}
+	}
+}
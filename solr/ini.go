@@ -15,13 +15,14 @@
 package solr
 
 import (
+	"fmt"
 	"github.com/go-ini/ini"
-	"log"
+	"strings"
+	"time"
 )
 
 // GenerateIniFile create an ini file to a specific location
-func GenerateIniFile(iniFileLocation string) {
-	log.Println("Generating new INI config file: " + iniFileLocation)
+func GenerateIniFile(iniFileLocation string) error {
 	cfg := ini.Empty()
 
 	cfg.NewSection("security")
@@ -30,13 +31,39 @@ func GenerateIniFile(iniFileLocation string) {
 	cfg.Section("security").NewKey("kerberosPrincipal", "solr/myhostname")
 	cfg.Section("security").NewKey("kerberosRealm", "EXAMPLE.COM")
 	cfg.Section("security").NewKey("kerberosKrb5Path", "/tmp/krb5.conf")
+	cfg.Section("security").NewKey("kerberosCCache", "")
+	cfg.Section("security").NewKey("kerberosServicePrincipal", "")
+	cfg.Section("security").NewKey("basicAuthEnabled", "false")
+	cfg.Section("security").NewKey("basicAuthUsername", "solr")
+	cfg.Section("security").NewKey("basicAuthPassword", "")
+	cfg.Section("security").NewKey("basicAuthPasswordFile", "")
+	cfg.Section("security").NewKey("jwtEnabled", "false")
+	cfg.Section("security").NewKey("jwtToken", "")
+	cfg.Section("security").NewKey("jwtTokenFile", "")
 
 	cfg.NewSection("solr")
 	cfg.Section("solr").NewKey("url", "http://localhost:8983")
 	cfg.Section("solr").NewKey("context", "/solr")
 	cfg.Section("solr").NewKey("collection", "hadoop_logs")
 	cfg.Section("solr").NewKey("ssl", "false")
+	cfg.Section("solr").NewKey("ssl_ca_cert", "")
+	cfg.Section("solr").NewKey("ssl_client_cert", "")
+	cfg.Section("solr").NewKey("ssl_client_key", "")
+	cfg.Section("solr").NewKey("ssl_server_name", "")
+	cfg.Section("solr").NewKey("insecure", "false")
 	cfg.Section("solr").NewKey("connection_timeout", "60")
+	cfg.Section("solr").NewKey("response_header_timeout", "10")
+	cfg.Section("solr").NewKey("load_balancer_strategy", "round_robin")
+	cfg.Section("solr").NewKey("health_check_interval", "60")
+	cfg.Section("solr").NewKey("retry_max_attempts", "3")
+	cfg.Section("solr").NewKey("retry_initial_backoff_ms", "100")
+	cfg.Section("solr").NewKey("retry_max_backoff_ms", "5000")
+	cfg.Section("solr").NewKey("circuit_breaker_enabled", "false")
+	cfg.Section("solr").NewKey("max_response_size", "0")
+	cfg.Section("solr").NewKey("cloud_mode", "false")
+	cfg.Section("solr").NewKey("zk_hosts", "")
+	cfg.Section("solr").NewKey("zk_chroot", "/solr")
+	cfg.Section("solr").NewKey("cluster_state_refresh_interval", "30")
 
 	cfg.NewSection("ssh")
 	cfg.Section("ssh").NewKey("enabled", "false")
@@ -61,14 +88,15 @@ func GenerateIniFile(iniFileLocation string) {
 	cfg.Section("generator").NewKey("date_field", "logtime")
 	cfg.Section("generator").NewKey("message_fields", "log_message")
 	cfg.Section("generator").NewKey("num_fields", "seq_num")
-	cfg.SaveTo(iniFileLocation)
+	cfg.Section("generator").NewKey("metrics_address", "")
+	return cfg.SaveTo(iniFileLocation)
 }
 
 // GenerateSolrConfig create sample ini file for Solr data generation
-func GenerateSolrConfig(iniFileLocation string) (SolrConfig, SSHConfig) {
+func GenerateSolrConfig(iniFileLocation string) (SolrConfig, SSHConfig, error) {
 	cfg, err := ini.Load(iniFileLocation)
 	if err != nil {
-		log.Fatal("Fail to read file: " + iniFileLocation)
+		return SolrConfig{}, SSHConfig{}, fmt.Errorf("fail to read file %s: %v", iniFileLocation, err)
 	}
 
 	kerberosEnabled, _ := cfg.Section("security").Key("kerberosEnabled").Bool()
@@ -76,12 +104,41 @@ func GenerateSolrConfig(iniFileLocation string) (SolrConfig, SSHConfig) {
 	principal := cfg.Section("security").Key("kerberosPrincipal").String()
 	realm := cfg.Section("security").Key("kerberosRealm").String()
 	krb5Path := cfg.Section("security").Key("kerberosKrb5Path").String()
+	ccachePath := cfg.Section("security").Key("kerberosCCache").String()
+	servicePrincipal := cfg.Section("security").Key("kerberosServicePrincipal").String()
+	basicAuthEnabled, _ := cfg.Section("security").Key("basicAuthEnabled").Bool()
+	basicAuthUsername := cfg.Section("security").Key("basicAuthUsername").String()
+	basicAuthPassword := cfg.Section("security").Key("basicAuthPassword").String()
+	basicAuthPasswordFile := cfg.Section("security").Key("basicAuthPasswordFile").String()
+	jwtEnabled, _ := cfg.Section("security").Key("jwtEnabled").Bool()
+	jwtToken := cfg.Section("security").Key("jwtToken").String()
+	jwtTokenFile := cfg.Section("security").Key("jwtTokenFile").String()
 
 	solrUrl := cfg.Section("solr").Key("url").String()
 	solrContext := cfg.Section("solr").Key("context").String()
 	solrCollection := cfg.Section("solr").Key("collection").String()
 	solrTlsEnabled, _ := cfg.Section("solr").Key("ssl").Bool()
+	solrCACert := cfg.Section("solr").Key("ssl_ca_cert").String()
+	solrClientCert := cfg.Section("solr").Key("ssl_client_cert").String()
+	solrClientKey := cfg.Section("solr").Key("ssl_client_key").String()
+	solrServerName := cfg.Section("solr").Key("ssl_server_name").String()
+	solrInsecure, _ := cfg.Section("solr").Key("insecure").Bool()
 	solrConnectionTimeout, _ := cfg.Section("solr").Key("connection_timeout").Int()
+	solrResponseHeaderTimeout, _ := cfg.Section("solr").Key("response_header_timeout").Int()
+	loadBalancerStrategy, err := ParseLoadBalancerStrategy(cfg.Section("solr").Key("load_balancer_strategy").String())
+	if err != nil {
+		return SolrConfig{}, SSHConfig{}, err
+	}
+	healthCheckInterval, _ := cfg.Section("solr").Key("health_check_interval").Int()
+	retryMaxAttempts, _ := cfg.Section("solr").Key("retry_max_attempts").Int()
+	retryInitialBackoff, _ := cfg.Section("solr").Key("retry_initial_backoff_ms").Int()
+	retryMaxBackoff, _ := cfg.Section("solr").Key("retry_max_backoff_ms").Int()
+	circuitBreakerEnabled, _ := cfg.Section("solr").Key("circuit_breaker_enabled").Bool()
+	maxResponseSize, _ := cfg.Section("solr").Key("max_response_size").Int64()
+	cloudMode, _ := cfg.Section("solr").Key("cloud_mode").Bool()
+	zkHosts := cfg.Section("solr").Key("zk_hosts").String()
+	zkChroot := cfg.Section("solr").Key("zk_chroot").String()
+	clusterStateRefreshInterval, _ := cfg.Section("solr").Key("cluster_state_refresh_interval").Int()
 
 	sshEnabled, _ := cfg.Section("ssh").Key("enabled").Bool()
 	sshUsername := cfg.Section("ssh").Key("username").String()
@@ -93,14 +150,73 @@ func GenerateSolrConfig(iniFileLocation string) (SolrConfig, SSHConfig) {
 
 	securityConfig := SecurityConfig{}
 	if kerberosEnabled {
-		securityConfig = InitSecurityConfig(krb5Path, keytabPath, principal, realm)
+		if len(keytabPath) == 0 && len(ccachePath) != 0 {
+			securityConfig = InitCCacheSecurityConfig(krb5Path, ccachePath)
+		} else {
+			securityConfig = InitSecurityConfig(krb5Path, keytabPath, principal, realm)
+		}
+		securityConfig.WithServicePrincipal(servicePrincipal)
+	}
+	if basicAuthEnabled {
+		if len(basicAuthPasswordFile) != 0 {
+			password, err := ReadPasswordFile(basicAuthPasswordFile)
+			if err != nil {
+				return SolrConfig{}, SSHConfig{}, err
+			}
+			basicAuthPassword = password
+		}
+		securityConfig.WithBasicAuth(basicAuthUsername, basicAuthPassword)
 	}
+	if jwtEnabled {
+		if len(jwtTokenFile) != 0 {
+			securityConfig.WithBearerTokenFile(jwtTokenFile)
+		} else {
+			securityConfig.WithBearerToken(jwtToken)
+		}
+	}
+	if err := securityConfig.Validate(); err != nil {
+		return SolrConfig{}, SSHConfig{}, err
+	}
+
+	tlsConfig := TLSConfig{Enabled: solrTlsEnabled, CACertPath: solrCACert, CertPath: solrClientCert,
+		KeyPath: solrClientKey, ServerName: solrServerName}
 
-	solrConfig := SolrConfig{solrUrl, solrCollection, &securityConfig, solrContext,
-		TLSConfig{}, !solrTlsEnabled, solrConnectionTimeout}
+	solrUrls := ParseSolrUrls(solrUrl)
+	if len(solrUrls) != 0 {
+		solrUrl = solrUrls[0]
+	}
+
+	solrConfig := SolrConfig{Url: solrUrl, Collection: solrCollection, SecurityConfig: &securityConfig, SolrUrlContext: solrContext,
+		TlsConfig: tlsConfig, Insecure: solrInsecure, ConnectTimeoutSeconds: solrConnectionTimeout,
+		ResponseHeaderTimeoutSeconds: solrResponseHeaderTimeout, Urls: solrUrls,
+		LoadBalancerConfig:   LoadBalancerConfig{Strategy: loadBalancerStrategy, HealthCheckIntervalSeconds: healthCheckInterval},
+		MaxResponseSizeBytes: maxResponseSize}
+	if retryMaxAttempts > 1 {
+		retryPolicy := DefaultRetryPolicy()
+		retryPolicy.MaxAttempts = retryMaxAttempts
+		if retryInitialBackoff > 0 {
+			retryPolicy.InitialBackoff = time.Duration(retryInitialBackoff) * time.Millisecond
+		}
+		if retryMaxBackoff > 0 {
+			retryPolicy.MaxBackoff = time.Duration(retryMaxBackoff) * time.Millisecond
+		}
+		solrConfig.RetryPolicy = retryPolicy
+	}
+	if circuitBreakerEnabled {
+		solrConfig.CircuitBreakerConfig = DefaultCircuitBreakerConfig()
+	}
+	if cloudMode {
+		var zkHostList []string
+		for _, zkHost := range strings.Split(zkHosts, ",") {
+			if zkHost = strings.TrimSpace(zkHost); len(zkHost) != 0 {
+				zkHostList = append(zkHostList, zkHost)
+			}
+		}
+		solrConfig.CloudConfig = &CloudConfig{ZkHosts: zkHostList, ZkChroot: zkChroot, RefreshIntervalSeconds: clusterStateRefreshInterval}
+	}
 
 	sshConfig := SSHConfig{Enabled: sshEnabled, Username: sshUsername, PrivateKeyPath: sshPrivateKeyPath,
 		DownloadLocation: sshDownloadLocation, RemoteKrb5Conf: remoteKrb5Conf, RemoteKeytab: remoteKeytab, Hostname: sshHostname}
 
-	return solrConfig, sshConfig
+	return solrConfig, sshConfig, nil
 }
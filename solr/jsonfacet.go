@@ -0,0 +1,370 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONFacet is a facet of the JSON Facet API: a terms, range, query or heatmap facet, or an aggregation function
+type JSONFacet interface {
+	facetJSON() interface{}
+}
+
+// FacetBuilder builds a terms, range, query or heatmap facet of the JSON Facet API
+type FacetBuilder struct {
+	params map[string]interface{}
+	facets map[string]JSONFacet
+}
+
+// AggregationFunc is an aggregation function of the JSON Facet API (e.g. avg(price))
+type AggregationFunc string
+
+// FacetResult is a node of the JSON Facet API response: the root of the facets, a bucket or the result of a query facet
+type FacetResult struct {
+	// Val is the value of the bucket (nil for the root and for query facets)
+	Val   interface{}
+	Count int64
+	// Stats holds the results of the aggregation functions (int64, float64, string or []interface{} values, or map[string]interface{}
+	// for aggregations with object results, e.g. relatedness())
+	Stats map[string]interface{}
+	// BucketFacets holds the results of the terms and range sub-facets
+	BucketFacets map[string]*BucketFacetResult
+	// QueryFacets holds the results of the query sub-facets (objects with count, but without buckets)
+	QueryFacets map[string]*FacetResult
+	// Heatmaps holds the results of the heatmap sub-facets
+	Heatmaps map[string]*HeatmapResult
+}
+
+// BucketFacetResult is the result of a terms or range facet
+type BucketFacetResult struct {
+	Buckets    []*FacetResult `json:"buckets"`
+	NumBuckets int64          `json:"numBuckets"`
+	AllBuckets *FacetResult   `json:"allBuckets"`
+	Missing    *FacetResult   `json:"missing"`
+	Before     *FacetResult   `json:"before"`
+	After      *FacetResult   `json:"after"`
+	Between    *FacetResult   `json:"between"`
+}
+
+// HeatmapResult is the result of a heatmap facet
+type HeatmapResult struct {
+	GridLevel    int       `json:"gridLevel"`
+	Columns      int       `json:"columns"`
+	Rows         int       `json:"rows"`
+	MinX         float64   `json:"minX"`
+	MaxX         float64   `json:"maxX"`
+	MinY         float64   `json:"minY"`
+	MaxY         float64   `json:"maxY"`
+	CountsInts2D [][]int64 `json:"counts_ints2D"`
+	CountsPng    string    `json:"counts_png"`
+}
+
+// TermsFacet create a terms facet (buckets by the values of a field)
+func TermsFacet(field string) *FacetBuilder {
+	return newFacetBuilder("terms").Param("field", field)
+}
+
+// RangeFacet create a range facet (buckets by ranges of a numeric or date field), e.g. RangeFacet("logtime", "NOW-1DAY", "NOW", "+1HOUR")
+func RangeFacet(field string, start interface{}, end interface{}, gap interface{}) *FacetBuilder {
+	return newFacetBuilder("range").Param("field", field).Param("start", start).Param("end", end).Param("gap", gap)
+}
+
+// QueryFacet create a query facet (a single bucket of the documents that match the query)
+func QueryFacet(query QueryExpr) *FacetBuilder {
+	return newFacetBuilder("query").Param("q", query.String())
+}
+
+// HeatmapFacet create a heatmap facet of a spatial field
+func HeatmapFacet(field string) *FacetBuilder {
+	return newFacetBuilder("heatmap").Param("field", field)
+}
+
+// Avg create an avg aggregation
+func Avg(field string) AggregationFunc {
+	return aggregation("avg", field)
+}
+
+// Sum create a sum aggregation
+func Sum(field string) AggregationFunc {
+	return aggregation("sum", field)
+}
+
+// Min create a min aggregation
+func Min(field string) AggregationFunc {
+	return aggregation("min", field)
+}
+
+// Max create a max aggregation
+func Max(field string) AggregationFunc {
+	return aggregation("max", field)
+}
+
+// Unique create a unique aggregation (number of unique values)
+func Unique(field string) AggregationFunc {
+	return aggregation("unique", field)
+}
+
+// HLL create an hll aggregation (distributed cardinality estimate)
+func HLL(field string) AggregationFunc {
+	return aggregation("hll", field)
+}
+
+// Percentile create a percentile aggregation, e.g. Percentile("qtime", 50, 99)
+func Percentile(field string, percentiles ...float64) AggregationFunc {
+	args := []string{field}
+	for _, percentile := range percentiles {
+		args = append(args, strconv.FormatFloat(percentile, 'f', -1, 64))
+	}
+	return aggregation("percentile", args...)
+}
+
+// Aggregation create any aggregation function of the JSON Facet API, e.g. Aggregation("sumsq", "price")
+func Aggregation(function string, args ...string) AggregationFunc {
+	return aggregation(function, args...)
+}
+
+// AddJSONFacet add a named facet to the json.facet parameter of the query
+func (q *SolrQuery) AddJSONFacet(name string, facet JSONFacet) error {
+	facets := make(map[string]json.RawMessage)
+	if existing := q.params.Get("json.facet"); len(existing) != 0 {
+		if err := json.Unmarshal([]byte(existing), &facets); err != nil {
+			return fmt.Errorf("invalid json.facet parameter: %v", err)
+		}
+	}
+	facetJSON, err := json.Marshal(facet.facetJSON())
+	if err != nil {
+		return err
+	}
+	facets[name] = facetJSON
+	jsonFacet, err := json.Marshal(facets)
+	if err != nil {
+		return err
+	}
+	q.SetParam("json.facet", string(jsonFacet))
+	return nil
+}
+
+// Param sets a parameter of the facet (e.g. domain, method, refine)
+func (facet *FacetBuilder) Param(key string, value interface{}) *FacetBuilder {
+	facet.params[key] = value
+	return facet
+}
+
+// Limit sets the maximum number of buckets
+func (facet *FacetBuilder) Limit(limit int) *FacetBuilder {
+	return facet.Param("limit", limit)
+}
+
+// Offset sets the number of buckets to skip
+func (facet *FacetBuilder) Offset(offset int) *FacetBuilder {
+	return facet.Param("offset", offset)
+}
+
+// MinCount sets the minimum count of the buckets
+func (facet *FacetBuilder) MinCount(minCount int) *FacetBuilder {
+	return facet.Param("mincount", minCount)
+}
+
+// Sort sets the sort of the buckets, e.g. "count desc" or "avg_price asc"
+func (facet *FacetBuilder) Sort(sort string) *FacetBuilder {
+	return facet.Param("sort", sort)
+}
+
+// Prefix sets the prefix of the bucket values (terms facet)
+func (facet *FacetBuilder) Prefix(prefix string) *FacetBuilder {
+	return facet.Param("prefix", prefix)
+}
+
+// Missing request a bucket for the documents without value
+func (facet *FacetBuilder) Missing() *FacetBuilder {
+	return facet.Param("missing", true)
+}
+
+// NumBuckets request the total number of buckets
+func (facet *FacetBuilder) NumBuckets() *FacetBuilder {
+	return facet.Param("numBuckets", true)
+}
+
+// AllBuckets request a bucket over the union of every bucket
+func (facet *FacetBuilder) AllBuckets() *FacetBuilder {
+	return facet.Param("allBuckets", true)
+}
+
+// Facet add a named sub-facet or aggregation, it is calculated for every bucket
+func (facet *FacetBuilder) Facet(name string, subFacet JSONFacet) *FacetBuilder {
+	if facet.facets == nil {
+		facet.facets = make(map[string]JSONFacet)
+	}
+	facet.facets[name] = subFacet
+	return facet
+}
+
+func (facet *FacetBuilder) facetJSON() interface{} {
+	facetJSON := make(map[string]interface{}, len(facet.params)+1)
+	for key, value := range facet.params {
+		facetJSON[key] = value
+	}
+	if len(facet.facets) != 0 {
+		subFacets := make(map[string]interface{}, len(facet.facets))
+		for name, subFacet := range facet.facets {
+			subFacets[name] = subFacet.facetJSON()
+		}
+		facetJSON["facet"] = subFacets
+	}
+	return facetJSON
+}
+
+func (function AggregationFunc) facetJSON() interface{} {
+	return string(function)
+}
+
+// Stat returns an aggregation result as float64 (false if it does not exist or it is not a number)
+func (result *FacetResult) Stat(name string) (float64, bool) {
+	switch value := result.Stats[name].(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+// BucketFacet returns the result of a terms or range sub-facet (nil if it does not exist)
+func (result *FacetResult) BucketFacet(name string) *BucketFacetResult {
+	return result.BucketFacets[name]
+}
+
+// QueryFacet returns the result of a query sub-facet (nil if it does not exist)
+func (result *FacetResult) QueryFacet(name string) *FacetResult {
+	return result.QueryFacets[name]
+}
+
+// UnmarshalJSON decode a facet node, the sub-facets are recognized by their shape: objects with buckets are terms or range facets,
+// objects with gridLevel are heatmaps, objects with count are query facets, anything else is an aggregation result
+func (result *FacetResult) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for key, raw := range fields {
+		switch key {
+		case "val":
+			var val interface{}
+			if err := decodeJSONNumbers(raw, &val); err != nil {
+				return err
+			}
+			result.Val = normalizeNumbers(val)
+			continue
+		case "count":
+			if err := json.Unmarshal(raw, &result.Count); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := result.decodeSubFacet(key, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (result *FacetResult) decodeSubFacet(key string, raw json.RawMessage) error {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return result.decodeStat(key, raw)
+	}
+	var shape map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &shape); err != nil {
+		return err
+	}
+	if !isFacetShape(shape) {
+		// aggregation with object result, e.g. relatedness(): {"relatedness":0.1,"foreground_popularity":...}
+		return result.decodeStat(key, raw)
+	}
+	if _, ok := shape["buckets"]; ok {
+		var bucketFacet BucketFacetResult
+		if err := json.Unmarshal(raw, &bucketFacet); err != nil {
+			return err
+		}
+		if result.BucketFacets == nil {
+			result.BucketFacets = make(map[string]*BucketFacetResult)
+		}
+		result.BucketFacets[key] = &bucketFacet
+		return nil
+	}
+	if _, ok := shape["gridLevel"]; ok {
+		var heatmap HeatmapResult
+		if err := json.Unmarshal(raw, &heatmap); err != nil {
+			return err
+		}
+		if result.Heatmaps == nil {
+			result.Heatmaps = make(map[string]*HeatmapResult)
+		}
+		result.Heatmaps[key] = &heatmap
+		return nil
+	}
+	var queryFacet FacetResult
+	if err := json.Unmarshal(raw, &queryFacet); err != nil {
+		return err
+	}
+	if result.QueryFacets == nil {
+		result.QueryFacets = make(map[string]*FacetResult)
+	}
+	result.QueryFacets[key] = &queryFacet
+	return nil
+}
+
+// decodeStat decode an aggregation result (numbers are decoded as int64 or float64)
+func (result *FacetResult) decodeStat(key string, raw json.RawMessage) error {
+	var stat interface{}
+	if err := decodeJSONNumbers(raw, &stat); err != nil {
+		return err
+	}
+	if result.Stats == nil {
+		result.Stats = make(map[string]interface{})
+	}
+	result.Stats[key] = normalizeNumbers(stat)
+	return nil
+}
+
+// isFacetShape returns true if the object is the result of a sub-facet (terms, range, heatmap or query facet)
+func isFacetShape(shape map[string]json.RawMessage) bool {
+	for _, key := range []string{"buckets", "gridLevel", "count"} {
+		if _, ok := shape[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func newFacetBuilder(facetType string) *FacetBuilder {
+	return &FacetBuilder{params: map[string]interface{}{"type": facetType}}
+}
+
+func aggregation(function string, args ...string) AggregationFunc {
+	return AggregationFunc(fmt.Sprintf("%s(%s)", function, strings.Join(args, ",")))
+}
+
+// decodeJSONNumbers decode JSON with json.Number values (so integers are not converted to float64)
+func decodeJSONNumbers(raw json.RawMessage, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
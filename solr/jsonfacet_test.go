@@ -0,0 +1,218 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const jsonFacetFixture = `{
+  "responseHeader": {"status": 0, "QTime": 3},
+  "response": {"numFound": 120, "start": 0, "docs": []},
+  "facets": {
+    "count": 120,
+    "avg_qtime": 12.5,
+    "unique_hosts": 4,
+    "max_logtime": "2018-03-14T15:09:26Z",
+    "qtime_percentiles": [10, 99.5],
+    "levels": {
+      "numBuckets": 3,
+      "allBuckets": {"count": 120},
+      "missing": {"count": 2},
+      "buckets": [
+        {"val": "INFO", "count": 100, "avg_qtime": 10,
+          "hosts": {"buckets": [{"val": "host1", "count": 60}, {"val": "host2", "count": 40}]},
+          "errors": {"count": 0}},
+        {"val": "ERROR", "count": 18, "avg_qtime": 25.25,
+          "hosts": {"buckets": [{"val": "host1", "count": 18}]},
+          "errors": {"count": 18, "max_qtime": 300}}
+      ]
+    },
+    "qtimes": {
+      "buckets": [{"val": 0, "count": 80}, {"val": 100, "count": 30}],
+      "before": {"count": 1},
+      "after": {"count": 9},
+      "between": {"count": 110, "avg_qtime": 11}
+    },
+    "versions": {"buckets": [{"val": 1594932413838131200, "count": 1}, {"val": 2.5, "count": 2}]},
+    "slow": {"count": 7, "hosts": {"buckets": [{"val": "host3", "count": 7}]}},
+    "empty": {"count": 0},
+    "locations": {"gridLevel": 2, "columns": 2, "rows": 1, "minX": -180, "maxX": 180, "minY": -90, "maxY": 90,
+      "counts_ints2D": [[1, 0]]},
+    "related": {"relatedness": 0.25, "foreground_popularity": 0.1, "background_popularity": 0.05}
+  }
+}`
+
+func decodeJSONFacetFixture(t *testing.T) *FacetResult {
+	var solrResponse SolrResponseData
+	if err := json.Unmarshal([]byte(jsonFacetFixture), &solrResponse); err != nil {
+		t.Fatalf("cannot decode fixture: %v", err)
+	}
+	if solrResponse.Facets == nil {
+		t.Fatalf("facets are missing")
+	}
+	return solrResponse.Facets
+}
+
+func TestFacetResultStats(t *testing.T) {
+	facets := decodeJSONFacetFixture(t)
+	if facets.Count != 120 || facets.Val != nil {
+		t.Fatalf("unexpected root: count %d, val %v", facets.Count, facets.Val)
+	}
+	expected := map[string]interface{}{
+		"avg_qtime":         12.5,
+		"unique_hosts":      int64(4),
+		"max_logtime":       "2018-03-14T15:09:26Z",
+		"qtime_percentiles": []interface{}{int64(10), 99.5},
+		"related": map[string]interface{}{"relatedness": 0.25, "foreground_popularity": 0.1,
+			"background_popularity": 0.05},
+	}
+	if !reflect.DeepEqual(facets.Stats, expected) {
+		t.Fatalf("unexpected stats:\n%#v\nexpected:\n%#v", facets.Stats, expected)
+	}
+	if value, ok := facets.Stat("unique_hosts"); !ok || value != 4 {
+		t.Fatalf("unexpected int stat: %v %v", value, ok)
+	}
+	if _, ok := facets.Stat("max_logtime"); ok {
+		t.Fatalf("string stat should not be returned as number")
+	}
+	if _, ok := facets.QueryFacets["related"]; ok {
+		t.Fatalf("object valued aggregation should not be decoded as query facet")
+	}
+}
+
+func TestFacetResultTermsFacet(t *testing.T) {
+	levels := decodeJSONFacetFixture(t).BucketFacet("levels")
+	if levels == nil || levels.NumBuckets != 3 || levels.AllBuckets.Count != 120 || levels.Missing.Count != 2 || len(levels.Buckets) != 2 {
+		t.Fatalf("unexpected terms facet: %+v", levels)
+	}
+	info, errorBucket := levels.Buckets[0], levels.Buckets[1]
+	if info.Val != "INFO" || info.Count != 100 || errorBucket.Val != "ERROR" || errorBucket.Count != 18 {
+		t.Fatalf("unexpected buckets: %+v %+v", info, errorBucket)
+	}
+	if avg, ok := errorBucket.Stat("avg_qtime"); !ok || avg != 25.25 {
+		t.Fatalf("unexpected bucket stat: %v", avg)
+	}
+	hosts := info.BucketFacet("hosts")
+	if hosts == nil || len(hosts.Buckets) != 2 || hosts.Buckets[1].Val != "host2" || hosts.Buckets[1].Count != 40 {
+		t.Fatalf("unexpected nested terms facet: %+v", hosts)
+	}
+	if errors := info.QueryFacet("errors"); errors == nil || errors.Count != 0 {
+		t.Fatalf("unexpected empty nested query facet: %+v", errors)
+	}
+	if maxQtime, ok := errorBucket.QueryFacet("errors").Stat("max_qtime"); !ok || maxQtime != 300 {
+		t.Fatalf("unexpected stat of nested query facet: %v", maxQtime)
+	}
+}
+
+func TestFacetResultRangeFacet(t *testing.T) {
+	qtimes := decodeJSONFacetFixture(t).BucketFacet("qtimes")
+	if qtimes == nil || len(qtimes.Buckets) != 2 || qtimes.Buckets[1].Val != int64(100) || qtimes.Buckets[1].Count != 30 {
+		t.Fatalf("unexpected range facet: %+v", qtimes)
+	}
+	if qtimes.Before.Count != 1 || qtimes.After.Count != 9 || qtimes.Between.Count != 110 {
+		t.Fatalf("unexpected before/after/between: %+v %+v %+v", qtimes.Before, qtimes.After, qtimes.Between)
+	}
+	if avg, ok := qtimes.Between.Stat("avg_qtime"); !ok || avg != 11 {
+		t.Fatalf("unexpected between stat: %v", avg)
+	}
+	versions := decodeJSONFacetFixture(t).BucketFacet("versions")
+	if versions.Buckets[0].Val != int64(1594932413838131200) || versions.Buckets[1].Val != 2.5 {
+		t.Fatalf("numeric values should be decoded as int64 or float64: %#v %#v", versions.Buckets[0].Val, versions.Buckets[1].Val)
+	}
+}
+
+func TestFacetResultQueryAndHeatmapFacets(t *testing.T) {
+	facets := decodeJSONFacetFixture(t)
+	slow := facets.QueryFacet("slow")
+	if slow == nil || slow.Count != 7 || slow.BucketFacet("hosts").Buckets[0].Val != "host3" {
+		t.Fatalf("unexpected query facet: %+v", slow)
+	}
+	if empty := facets.QueryFacet("empty"); empty == nil || empty.Count != 0 {
+		t.Fatalf("unexpected empty query facet: %+v", empty)
+	}
+	expected := &HeatmapResult{GridLevel: 2, Columns: 2, Rows: 1, MinX: -180, MaxX: 180, MinY: -90, MaxY: 90, CountsInts2D: [][]int64{{1, 0}}}
+	if heatmap := facets.Heatmaps["locations"]; !reflect.DeepEqual(heatmap, expected) {
+		t.Fatalf("unexpected heatmap: %+v", heatmap)
+	}
+}
+
+func TestAddJSONFacet(t *testing.T) {
+	q := CreateSolrQuery()
+	levels := TermsFacet("level").Limit(10).MinCount(1).Sort("count desc").Missing().NumBuckets().AllBuckets().
+		Facet("avg_qtime", Avg("qtime")).
+		Facet("hosts", TermsFacet("host").Prefix("web").Offset(5))
+	if err := q.AddJSONFacet("levels", levels); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.AddJSONFacet("qtimes", RangeFacet("qtime", 0, 1000, 100).Param("other", "all")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.AddJSONFacet("slow", QueryFacet(Range("qtime", "1000", "*")).Facet("p99", Percentile("qtime", 99, 99.9))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.AddJSONFacet("hosts", Unique("host")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.AddJSONFacet("locations", HeatmapFacet("location").Param("gridLevel", 2)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{"hosts":"unique(host)",` +
+		`"levels":{"allBuckets":true,"facet":{"avg_qtime":"avg(qtime)","hosts":{"field":"host","offset":5,"prefix":"web","type":"terms"}},` +
+		`"field":"level","limit":10,"mincount":1,"missing":true,"numBuckets":true,"sort":"count desc","type":"terms"},` +
+		`"locations":{"field":"location","gridLevel":2,"type":"heatmap"},` +
+		`"qtimes":{"end":1000,"field":"qtime","gap":100,"other":"all","start":0,"type":"range"},` +
+		`"slow":{"facet":{"p99":"percentile(qtime,99,99.9)"},"q":"qtime:[1000 TO *]","type":"query"}}`
+	if jsonFacet := q.GetParam("json.facet"); jsonFacet != expected {
+		t.Fatalf("unexpected json.facet:\n%s\nexpected:\n%s", jsonFacet, expected)
+	}
+
+	if err := q.AddJSONFacet("levels", TermsFacet("level")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var facets map[string]json.RawMessage
+	json.Unmarshal([]byte(q.GetParam("json.facet")), &facets)
+	if len(facets) != 5 || string(facets["levels"]) != `{"field":"level","type":"terms"}` {
+		t.Fatalf("a facet with the same name should be replaced: %s", q.GetParam("json.facet"))
+	}
+
+	q.SetParam("json.facet", "{invalid")
+	if err := q.AddJSONFacet("levels", TermsFacet("level")); err == nil {
+		t.Fatalf("expected invalid json.facet error")
+	}
+}
+
+func TestAggregationFunctions(t *testing.T) {
+	testCases := []struct {
+		function AggregationFunc
+		expected string
+	}{
+		{Avg("price"), "avg(price)"},
+		{Sum("price"), "sum(price)"},
+		{Min("price"), "min(price)"},
+		{Max("price"), "max(price)"},
+		{Unique("host"), "unique(host)"},
+		{HLL("host"), "hll(host)"},
+		{Percentile("qtime", 50, 99.9), "percentile(qtime,50,99.9)"},
+		{Aggregation("relatedness", "$fore", "$back"), "relatedness($fore,$back)"},
+	}
+	for _, testCase := range testCases {
+		if string(testCase.function) != testCase.expected {
+			t.Errorf("expected %s, got %s", testCase.expected, testCase.function)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/jcmturner/gokrb5.v4/client"
+	"gopkg.in/jcmturner/gokrb5.v4/config"
+	"gopkg.in/jcmturner/gokrb5.v4/credentials"
+	"gopkg.in/jcmturner/gokrb5.v4/keytab"
+)
+
+// kerberosRenewalCheckInterval is the period of checking whether the TGT of the kerberos session needs a new login
+const kerberosRenewalCheckInterval = time.Minute
+
+// kerberosSession holds the logged in kerberos client of a Solr client, and keeps its TGT valid for long-running processes,
+// every Solr client has its own session (the kerberos config can be shared between clients)
+type kerberosSession struct {
+	kerberosConfig *KerberosConfig
+	logger         Logger
+	mutex          sync.RWMutex
+	kerberosClient *client.Client
+	ccacheModTime  time.Time
+	stop           chan struct{}
+	stopOnce       sync.Once
+}
+
+// InitCCacheSecurityConfig set security config with kerberos, using an existing credential cache (e.g. created by kinit) instead of a keytab
+func InitCCacheSecurityConfig(krb5Path string, ccachePath string) SecurityConfig {
+	kerberosConfig := KerberosConfig{krb5confPath: krb5Path, ccachePath: ccachePath}
+	return SecurityConfig{kerberosEnabled: true, kerberosConfig: &kerberosConfig}
+}
+
+// WithServicePrincipal set the service principal (SPN) of Solr, e.g.: HTTP/solr-host.example.com@EXAMPLE.COM,
+// if it is not set, the SPN is derived from the Solr host: HTTP/<host>
+func (securityConfig *SecurityConfig) WithServicePrincipal(servicePrincipal string) *SecurityConfig {
+	if securityConfig.kerberosConfig != nil {
+		securityConfig.kerberosConfig.servicePrincipal = servicePrincipal
+	}
+	return securityConfig
+}
+
+// newKerberosSession login with the keytab or credential cache of the kerberos config and start TGT renewal,
+// failed renewals are reported to the logger
+func newKerberosSession(kerberosConfig *KerberosConfig, logger Logger) (*kerberosSession, error) {
+	session, err := loginKerberosSession(kerberosConfig, logger)
+	if err != nil {
+		return nil, err
+	}
+	go session.renew(kerberosRenewalCheckInterval)
+	return session, nil
+}
+
+// loginKerberosSession login with the keytab or credential cache of the kerberos config, without TGT renewal
+func loginKerberosSession(kerberosConfig *KerberosConfig, logger Logger) (*kerberosSession, error) {
+	if len(kerberosConfig.keytab) == 0 && len(kerberosConfig.ccachePath) == 0 {
+		return nil, fmt.Errorf("kerberos is enabled but neither keytab nor credential cache is configured")
+	}
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	session := &kerberosSession{kerberosConfig: kerberosConfig, logger: logger, stop: make(chan struct{})}
+	if err := session.login(); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// login create a new kerberos client and obtain a TGT from the KDC (keytab) or from the credential cache
+func (session *kerberosSession) login() error {
+	kerberosConfig := session.kerberosConfig
+	krb5Config := config.NewConfig()
+	if len(kerberosConfig.krb5confPath) != 0 {
+		loadedConfig, err := config.Load(kerberosConfig.krb5confPath)
+		if err != nil {
+			return fmt.Errorf("cannot load krb5 config: %v", err)
+		}
+		krb5Config = loadedConfig
+	}
+	if host, realm := splitServicePrincipalRealm(kerberosConfig.servicePrincipal); len(realm) != 0 {
+		// gokrb5 resolves the realm of a service from the domain_realm section, so the realm of the SPN is mapped there
+		krb5Config.DomainRealm[host] = realm
+	}
+	var kerberosClient client.Client
+	var ccacheModTime time.Time
+	if len(kerberosConfig.keytab) != 0 {
+		kt, err := keytab.Load(kerberosConfig.keytab)
+		if err != nil {
+			return fmt.Errorf("cannot load keytab: %v", err)
+		}
+		kerberosClient = client.NewClientWithKeytab(kerberosConfig.principal, kerberosConfig.realm, kt)
+		kerberosClient.WithConfig(krb5Config)
+		if err := kerberosClient.Login(); err != nil {
+			return fmt.Errorf("kerberos login failed: %v", err)
+		}
+	} else {
+		fileInfo, err := os.Stat(kerberosConfig.ccachePath)
+		if err != nil {
+			return fmt.Errorf("cannot access credential cache: %v", err)
+		}
+		ccache, err := credentials.LoadCCache(kerberosConfig.ccachePath)
+		if err != nil {
+			return fmt.Errorf("cannot load credential cache: %v", err)
+		}
+		kerberosClient, err = client.NewClientFromCCache(ccache)
+		if err != nil {
+			return fmt.Errorf("kerberos login from credential cache failed: %v", err)
+		}
+		kerberosClient.WithConfig(krb5Config)
+		ccacheModTime = fileInfo.ModTime()
+	}
+	session.mutex.Lock()
+	session.kerberosClient = &kerberosClient
+	session.ccacheModTime = ccacheModTime
+	session.mutex.Unlock()
+	return nil
+}
+
+// renew periodically check the TGT of the session, and login again if it is (almost) expired, or if the credential cache was refreshed (e.g. by kinit or k5start)
+func (session *kerberosSession) renew(checkInterval time.Duration) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-session.stop:
+			return
+		case <-ticker.C:
+			if session.needsLogin(checkInterval) {
+				if err := session.login(); err != nil {
+					session.logger.Warn("Kerberos login failed, the TGT is not renewed", LogField("principal", session.kerberosConfig.principal), LogField("error", err))
+				}
+			}
+		}
+	}
+}
+
+// needsLogin returns true if the TGT expires before the next check, or the credential cache was refreshed since the last login
+func (session *kerberosSession) needsLogin(checkInterval time.Duration) bool {
+	session.mutex.RLock()
+	kerberosClient := session.kerberosClient
+	ccacheModTime := session.ccacheModTime
+	session.mutex.RUnlock()
+	if len(session.kerberosConfig.keytab) == 0 {
+		if fileInfo, err := os.Stat(session.kerberosConfig.ccachePath); err == nil && fileInfo.ModTime().After(ccacheModTime) {
+			return true
+		}
+	}
+	tgtSession, err := kerberosClient.GetSessionFromRealm(kerberosClient.Credentials.Realm)
+	if err != nil {
+		return true
+	}
+	return time.Now().UTC().Add(checkInterval).After(tgtSession.EndTime)
+}
+
+// setSPNEGOHeader set the SPNEGO authorization header on the request, if it fails, login is retried once
+func (session *kerberosSession) setSPNEGOHeader(request *http.Request) error {
+	spn := session.servicePrincipal(request)
+	session.mutex.RLock()
+	kerberosClient := session.kerberosClient
+	session.mutex.RUnlock()
+	err := kerberosClient.SetSPNEGOHeader(request, spn)
+	if err == nil {
+		return nil
+	}
+	if loginErr := session.login(); loginErr != nil {
+		return fmt.Errorf("cannot set SPNEGO header: %v (login retry failed: %v)", err, loginErr)
+	}
+	session.mutex.RLock()
+	kerberosClient = session.kerberosClient
+	session.mutex.RUnlock()
+	return kerberosClient.SetSPNEGOHeader(request, spn)
+}
+
+// servicePrincipal returns the configured SPN or HTTP/<host> based on the request url, without the realm part:
+// gokrb5 accepts only <service>/<host> SPNs, the realm of the configured SPN is passed as a domain_realm mapping at login
+func (session *kerberosSession) servicePrincipal(request *http.Request) string {
+	spn := session.kerberosConfig.servicePrincipal
+	if len(spn) == 0 {
+		return "HTTP/" + request.URL.Hostname()
+	}
+	if atIndex := strings.Index(spn, "@"); atIndex != -1 {
+		spn = spn[:atIndex]
+	}
+	return spn
+}
+
+// splitServicePrincipalRealm returns the host and the realm of a <service>/<host>@<REALM> SPN (empty realm if it has no realm part)
+func splitServicePrincipalRealm(servicePrincipal string) (string, string) {
+	atIndex := strings.Index(servicePrincipal, "@")
+	if atIndex == -1 {
+		return "", ""
+	}
+	name := servicePrincipal[:atIndex]
+	return name[strings.LastIndex(name, "/")+1:], servicePrincipal[atIndex+1:]
+}
+
+// close stop the TGT renewal of the session
+func (session *kerberosSession) close() {
+	session.stopOnce.Do(func() {
+		close(session.stop)
+	})
+}
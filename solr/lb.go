@@ -0,0 +1,278 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultHealthCheckIntervalSeconds is used for probing dead Solr nodes if no interval is configured
+const defaultHealthCheckIntervalSeconds = 60
+
+// healthCheckTimeout is the timeout of one health check request
+const healthCheckTimeout = 10 * time.Second
+
+// LoadBalancerStrategy defines how requests are spread across the live Solr nodes
+type LoadBalancerStrategy int
+
+const (
+	// RoundRobin sends requests to the live nodes one after another
+	RoundRobin LoadBalancerStrategy = iota
+	// LeastOutstanding sends requests to the live node with the fewest in-flight requests
+	LeastOutstanding
+)
+
+// ParseLoadBalancerStrategy parse load balancer strategy name (round_robin or least_outstanding)
+func ParseLoadBalancerStrategy(strategy string) (LoadBalancerStrategy, error) {
+	switch strings.ToLower(strings.TrimSpace(strategy)) {
+	case "", "round_robin":
+		return RoundRobin, nil
+	case "least_outstanding":
+		return LeastOutstanding, nil
+	}
+	return RoundRobin, fmt.Errorf("unknown load balancer strategy: %s", strategy)
+}
+
+// solrNode represents one Solr node (base url with url context, e.g.: http://myhost:8983/solr) of the node pool
+type solrNode struct {
+	url         string
+	alive       int32
+	outstanding int64
+}
+
+// nodePool holds the Solr nodes of a client, and picks the node for the next request
+type nodePool struct {
+	mutex    sync.RWMutex
+	nodes    []*solrNode
+	strategy LoadBalancerStrategy
+	counter  uint64
+}
+
+// connectionError marks errors where Solr could not be reached or the response could not be read
+type connectionError struct {
+	err error
+}
+
+func (e *connectionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connectionError) Unwrap() error {
+	return e.err
+}
+
+// ParseSolrUrls split a comma separated list of Solr node urls
+func ParseSolrUrls(urls string) []string {
+	var result []string
+	for _, solrUrl := range strings.Split(urls, ",") {
+		solrUrl = strings.TrimRight(strings.TrimSpace(solrUrl), "/")
+		if len(solrUrl) != 0 {
+			result = append(result, solrUrl)
+		}
+	}
+	return result
+}
+
+// nodeUrls returns the configured Solr node urls (Urls if set, otherwise Url)
+func (solrConfig *SolrConfig) nodeUrls() []string {
+	if len(solrConfig.Urls) != 0 {
+		return solrConfig.Urls
+	}
+	return []string{solrConfig.Url}
+}
+
+// nodeBaseUrls returns the configured Solr node urls with the url context
+func (solrConfig *SolrConfig) nodeBaseUrls() []string {
+	var baseUrls []string
+	for _, nodeUrl := range solrConfig.nodeUrls() {
+		baseUrls = append(baseUrls, nodeUrl+solrConfig.SolrUrlContext)
+	}
+	return baseUrls
+}
+
+func newNodePool(urls []string, strategy LoadBalancerStrategy) *nodePool {
+	pool := &nodePool{strategy: strategy}
+	pool.setNodes(urls)
+	return pool
+}
+
+// setNodes replace the nodes of the pool, the state of already known nodes is kept, the urls of the removed nodes are returned
+func (pool *nodePool) setNodes(urls []string) []string {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	existing := make(map[string]*solrNode)
+	for _, node := range pool.nodes {
+		existing[node.url] = node
+	}
+	nodes := make([]*solrNode, 0, len(urls))
+	for _, nodeUrl := range urls {
+		if node, ok := existing[nodeUrl]; ok {
+			nodes = append(nodes, node)
+		} else {
+			nodes = append(nodes, &solrNode{url: nodeUrl, alive: 1})
+		}
+		delete(existing, nodeUrl)
+	}
+	pool.nodes = nodes
+	removed := make([]string, 0, len(existing))
+	for nodeUrl := range existing {
+		removed = append(removed, nodeUrl)
+	}
+	return removed
+}
+
+// get returns the node of the pool with the url, or a new node that is not part of the pool
+func (pool *nodePool) get(nodeUrl string) *solrNode {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	for _, node := range pool.nodes {
+		if node.url == nodeUrl {
+			return node
+		}
+	}
+	return &solrNode{url: nodeUrl, alive: 1}
+}
+
+func (pool *nodePool) size() int {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	return len(pool.nodes)
+}
+
+// pick select a live node that was not tried yet, if every untried node is dead, a dead one is picked as a last resort
+func (pool *nodePool) pick(tried map[*solrNode]bool) *solrNode {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	var live, dead []*solrNode
+	for _, node := range pool.nodes {
+		if tried[node] {
+			continue
+		}
+		if atomic.LoadInt32(&node.alive) == 1 {
+			live = append(live, node)
+		} else {
+			dead = append(dead, node)
+		}
+	}
+	candidates := live
+	if len(candidates) == 0 {
+		candidates = dead
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	if pool.strategy == LeastOutstanding {
+		selected := candidates[0]
+		for _, node := range candidates[1:] {
+			if atomic.LoadInt64(&node.outstanding) < atomic.LoadInt64(&selected.outstanding) {
+				selected = node
+			}
+		}
+		return selected
+	}
+	next := atomic.AddUint64(&pool.counter, 1)
+	return candidates[int((next-1)%uint64(len(candidates)))]
+}
+
+func (pool *nodePool) deadNodes() []*solrNode {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	var dead []*solrNode
+	for _, node := range pool.nodes {
+		if atomic.LoadInt32(&node.alive) == 0 {
+			dead = append(dead, node)
+		}
+	}
+	return dead
+}
+
+func (pool *nodePool) markDead(node *solrNode) {
+	atomic.StoreInt32(&node.alive, 0)
+}
+
+func (pool *nodePool) markAlive(node *solrNode) {
+	atomic.StoreInt32(&node.alive, 1)
+}
+
+// LiveNodes returns the Solr node urls (with url context) that are considered alive by the client
+func (solrClient *SolrClient) LiveNodes() []string {
+	solrClient.nodes.mutex.RLock()
+	defer solrClient.nodes.mutex.RUnlock()
+	var liveNodes []string
+	for _, node := range solrClient.nodes.nodes {
+		if atomic.LoadInt32(&node.alive) == 1 {
+			liveNodes = append(liveNodes, node.url)
+		}
+	}
+	return liveNodes
+}
+
+// isNodeFailure returns true if the node should be marked as dead because of the error (connection error or 5xx response)
+func isNodeFailure(err error) bool {
+	var connErr *connectionError
+	if errors.As(err, &connErr) {
+		return true
+	}
+	solrError := AsSolrError(err)
+	return solrError != nil && solrError.HTTPStatus >= 500
+}
+
+// startHealthCheck probe dead nodes periodically, and mark them alive again if they respond
+func (solrClient *SolrClient) startHealthCheck(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-solrClient.stop:
+				return
+			case <-ticker.C:
+				for _, node := range solrClient.nodes.deadNodes() {
+					if solrClient.checkNode(node) == nil {
+						solrClient.nodes.markAlive(node)
+						solrClient.reportNodeHealth()
+					}
+				}
+			}
+		}
+	}()
+}
+
+// checkNode send a system info request to a Solr node, returns error if the node is not healthy
+func (solrClient *SolrClient) checkNode(node *solrNode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	uri := fmt.Sprintf("%s/admin/info/system?wt=json", node.url)
+	request, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	response, err := solrClient.roundTrip(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("health check of %s failed with http status: %d", node.url, response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func pickUrls(pool *nodePool, count int) []string {
+	var urls []string
+	for i := 0; i < count; i++ {
+		if node := pool.pick(nil); node != nil {
+			urls = append(urls, node.url)
+		}
+	}
+	return urls
+}
+
+func TestParseLoadBalancerStrategy(t *testing.T) {
+	for name, expected := range map[string]LoadBalancerStrategy{"": RoundRobin, "round_robin": RoundRobin,
+		" Least_Outstanding ": LeastOutstanding} {
+		if strategy, err := ParseLoadBalancerStrategy(name); err != nil || strategy != expected {
+			t.Errorf("%q: expected %v, got %v, %v", name, expected, strategy, err)
+		}
+	}
+	if _, err := ParseLoadBalancerStrategy("random"); err == nil {
+		t.Errorf("expected error for an unknown strategy")
+	}
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	pool := newNodePool([]string{"http://solr1", "http://solr2", "http://solr3"}, RoundRobin)
+	expected := []string{"http://solr1", "http://solr2", "http://solr3", "http://solr1", "http://solr2", "http://solr3"}
+	if urls := pickUrls(pool, 6); !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("unexpected round robin order: %v", urls)
+	}
+
+	pool.markDead(pool.get("http://solr2"))
+	for _, nodeUrl := range pickUrls(pool, 4) {
+		if nodeUrl == "http://solr2" {
+			t.Fatalf("dead node is picked while live nodes exist")
+		}
+	}
+
+	tried := map[*solrNode]bool{pool.get("http://solr1"): true}
+	for i := 0; i < 3; i++ {
+		if node := pool.pick(tried); node == nil || node.url != "http://solr3" {
+			t.Fatalf("expected the only untried live node, got: %v", node)
+		}
+	}
+}
+
+func TestPickLeastOutstanding(t *testing.T) {
+	pool := newNodePool([]string{"http://solr1", "http://solr2", "http://solr3"}, LeastOutstanding)
+	atomic.StoreInt64(&pool.get("http://solr1").outstanding, 3)
+	atomic.StoreInt64(&pool.get("http://solr2").outstanding, 1)
+	atomic.StoreInt64(&pool.get("http://solr3").outstanding, 2)
+	if node := pool.pick(nil); node.url != "http://solr2" {
+		t.Fatalf("expected the node with the fewest in-flight requests, got: %s", node.url)
+	}
+	if node := pool.pick(map[*solrNode]bool{pool.get("http://solr2"): true}); node.url != "http://solr3" {
+		t.Fatalf("expected the untried node with the fewest in-flight requests, got: %s", node.url)
+	}
+	pool.markDead(pool.get("http://solr2"))
+	if node := pool.pick(nil); node.url != "http://solr3" {
+		t.Fatalf("expected the live node with the fewest in-flight requests, got: %s", node.url)
+	}
+	// ties are resolved by the order of the nodes
+	atomic.StoreInt64(&pool.get("http://solr1").outstanding, 2)
+	if node := pool.pick(nil); node.url != "http://solr1" {
+		t.Fatalf("expected the first node on tie, got: %s", node.url)
+	}
+}
+
+func TestPickDeadNodeAsLastResort(t *testing.T) {
+	for _, strategy := range []LoadBalancerStrategy{RoundRobin, LeastOutstanding} {
+		pool := newNodePool([]string{"http://solr1", "http://solr2"}, strategy)
+		solr1, solr2 := pool.get("http://solr1"), pool.get("http://solr2")
+		pool.markDead(solr1)
+		pool.markDead(solr2)
+		node := pool.pick(nil)
+		if node == nil {
+			t.Fatalf("%v: expected a dead node if every node is dead", strategy)
+		}
+		if next := pool.pick(map[*solrNode]bool{node: true}); next == nil || next == node {
+			t.Fatalf("%v: expected the other dead node, got: %v", strategy, next)
+		}
+		if node := pool.pick(map[*solrNode]bool{solr1: true, solr2: true}); node != nil {
+			t.Fatalf("%v: expected no node if every node is tried, got: %s", strategy, node.url)
+		}
+		// a live node is preferred over the dead ones
+		pool.markAlive(solr2)
+		if node := pool.pick(nil); node != solr2 {
+			t.Fatalf("%v: expected the live node, got: %s", strategy, node.url)
+		}
+	}
+	if node := newNodePool(nil, RoundRobin).pick(nil); node != nil {
+		t.Fatalf("expected no node from an empty pool, got: %v", node)
+	}
+}
+
+func TestSetNodes(t *testing.T) {
+	pool := newNodePool([]string{"http://solr1", "http://solr2", "http://solr3"}, RoundRobin)
+	solr2 := pool.get("http://solr2")
+	pool.markDead(solr2)
+	atomic.StoreInt64(&solr2.outstanding, 5)
+
+	removed := pool.setNodes([]string{"http://solr4", "http://solr2"})
+	sort.Strings(removed)
+	if !reflect.DeepEqual(removed, []string{"http://solr1", "http://solr3"}) {
+		t.Fatalf("unexpected removed nodes: %v", removed)
+	}
+	if pool.size() != 2 || pool.nodes[0].url != "http://solr4" || pool.nodes[1] != solr2 {
+		t.Fatalf("unexpected nodes: %v, %v", pool.nodes[0], pool.nodes[1])
+	}
+	if atomic.LoadInt32(&solr2.alive) != 0 || atomic.LoadInt64(&solr2.outstanding) != 5 {
+		t.Fatalf("the state of the existing node should be kept: %+v", solr2)
+	}
+	if atomic.LoadInt32(&pool.nodes[0].alive) != 1 {
+		t.Fatalf("new nodes should be alive")
+	}
+	if removed := pool.setNodes([]string{"http://solr4", "http://solr2"}); len(removed) != 0 {
+		t.Fatalf("expected no removed nodes, got: %v", removed)
+	}
+	// a node that is not part of the pool is returned as a new node
+	if node := pool.get("http://solr1"); node.url != "http://solr1" || atomic.LoadInt32(&node.alive) != 1 || pool.size() != 2 {
+		t.Fatalf("unexpected node outside of the pool: %+v", node)
+	}
+}
+
+func TestIsNodeFailure(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"connection error", &connectionError{err: errors.New("connection refused")}, true},
+		{"wrapped connection error", fmt.Errorf("request failed: %w", &connectionError{err: errors.New("EOF")}), true},
+		{"400", &SolrError{HTTPStatus: 400, Code: 400}, false},
+		{"401", &SolrError{HTTPStatus: 401}, false},
+		{"404", &SolrError{HTTPStatus: 404}, false},
+		{"409", &SolrError{HTTPStatus: 409, Code: 409}, false},
+		{"500", &SolrError{HTTPStatus: 500, Code: 500}, true},
+		{"503", fmt.Errorf("query failed: %w", &SolrError{HTTPStatus: 503}), true},
+		{"other error", errors.New("cannot encode request"), false},
+		{"nil", nil, false},
+	}
+	for _, testCase := range testCases {
+		if actual := isNodeFailure(testCase.err); actual != testCase.expected {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.expected, actual)
+		}
+	}
+}
+
+func TestHealthCheckMarksNodeAlive(t *testing.T) {
+	var healthy int32
+	var healthChecks int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/solr/admin/info/system" {
+			atomic.AddInt32(&healthChecks, 1)
+			if atomic.LoadInt32(&healthy) == 0 {
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Write([]byte(`{"responseHeader":{"status":0}}`))
+	}))
+	t.Cleanup(server.Close)
+	metrics := NewPrometheusMetrics()
+	solrConfig := &SolrConfig{Urls: []string{server.URL, "http://127.0.0.1:1"}, SolrUrlContext: "/solr", Collection: "test"}
+	solrClient, err := NewSolrClient(solrConfig, WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+
+	node := solrClient.nodes.get(server.URL + "/solr")
+	solrClient.nodes.markDead(node)
+	if err := solrClient.checkNode(node); err == nil {
+		t.Fatalf("expected health check error for 503")
+	}
+	solrClient.startHealthCheck(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&healthChecks) < 2 || atomic.LoadInt32(&node.alive) != 0 {
+		t.Fatalf("the node should be probed and kept dead while it fails, health checks: %d", atomic.LoadInt32(&healthChecks))
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&node.alive) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the node is not marked alive by the health check")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if liveNodes := solrClient.LiveNodes(); !reflect.DeepEqual(liveNodes, []string{server.URL + "/solr", "http://127.0.0.1:1/solr"}) {
+		t.Fatalf("unexpected live nodes: %v", liveNodes)
+	}
+	probed := atomic.LoadInt32(&healthChecks)
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt32(&healthChecks) != probed {
+		t.Fatalf("live nodes should not be probed")
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// LogLevel represents the severity of a log message
+type LogLevel int
+
+const (
+	// LevelDebug is used for detailed messages, e.g. every request url
+	LevelDebug LogLevel = iota
+	// LevelInfo is used for informational messages
+	LevelInfo
+	// LevelWarn is used for recoverable problems, e.g. failed cluster state refresh
+	LevelWarn
+	// LevelError is used for failures
+	LevelError
+)
+
+// Field represents a structured logging field
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger is the logging interface of the Solr client, it can be injected with the WithLogger client option
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+// NopLogger drops every log message, it is the default logger of the Solr client
+type NopLogger struct{}
+
+// StdLogger writes log messages with a standard library logger (key=value fields), messages below the minimum level are dropped
+type StdLogger struct {
+	logger   *log.Logger
+	minLevel LogLevel
+}
+
+// SlogLogger writes log messages with a log/slog logger
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// LogField create a structured logging field
+func LogField(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// WithLogger set the logger of the Solr client
+func WithLogger(logger Logger) ClientOption {
+	return func(solrClient *SolrClient) {
+		solrClient.logger = logger
+	}
+}
+
+// String returns the name of the log level
+func (level LogLevel) String() string {
+	switch level {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// Debug drops the message
+func (NopLogger) Debug(msg string, fields ...Field) {}
+
+// Info drops the message
+func (NopLogger) Info(msg string, fields ...Field) {}
+
+// Warn drops the message
+func (NopLogger) Warn(msg string, fields ...Field) {}
+
+// Error drops the message
+func (NopLogger) Error(msg string, fields ...Field) {}
+
+// NewStdLogger create a logger that writes with the standard library logger (log.Default() if nil)
+func NewStdLogger(logger *log.Logger, minLevel LogLevel) *StdLogger {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &StdLogger{logger: logger, minLevel: minLevel}
+}
+
+// Debug write a debug message
+func (stdLogger *StdLogger) Debug(msg string, fields ...Field) {
+	stdLogger.log(LevelDebug, msg, fields)
+}
+
+// Info write an info message
+func (stdLogger *StdLogger) Info(msg string, fields ...Field) {
+	stdLogger.log(LevelInfo, msg, fields)
+}
+
+// Warn write a warning message
+func (stdLogger *StdLogger) Warn(msg string, fields ...Field) {
+	stdLogger.log(LevelWarn, msg, fields)
+}
+
+// Error write an error message
+func (stdLogger *StdLogger) Error(msg string, fields ...Field) {
+	stdLogger.log(LevelError, msg, fields)
+}
+
+func (stdLogger *StdLogger) log(level LogLevel, msg string, fields []Field) {
+	if level < stdLogger.minLevel {
+		return
+	}
+	var builder strings.Builder
+	builder.WriteString(level.String())
+	builder.WriteString(" ")
+	builder.WriteString(msg)
+	for _, field := range fields {
+		builder.WriteString(fmt.Sprintf(" %s=%v", field.Key, field.Value))
+	}
+	stdLogger.logger.Print(builder.String())
+}
+
+// NewSlogLogger create a logger that writes with a log/slog logger (slog.Default() if nil)
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogLogger{logger: logger}
+}
+
+// Debug write a debug message
+func (slogLogger *SlogLogger) Debug(msg string, fields ...Field) {
+	slogLogger.log(slog.LevelDebug, msg, fields)
+}
+
+// Info write an info message
+func (slogLogger *SlogLogger) Info(msg string, fields ...Field) {
+	slogLogger.log(slog.LevelInfo, msg, fields)
+}
+
+// Warn write a warning message
+func (slogLogger *SlogLogger) Warn(msg string, fields ...Field) {
+	slogLogger.log(slog.LevelWarn, msg, fields)
+}
+
+// Error write an error message
+func (slogLogger *SlogLogger) Error(msg string, fields ...Field) {
+	slogLogger.log(slog.LevelError, msg, fields)
+}
+
+func (slogLogger *SlogLogger) log(level slog.Level, msg string, fields []Field) {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range fields {
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+	slogLogger.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
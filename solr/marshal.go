@@ -0,0 +1,630 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SolrDateFormat is the format of the Solr date fields (UTC, ISO-8601)
+const SolrDateFormat = "2006-01-02T15:04:05.999999999Z"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+var structFieldsCache sync.Map
+
+// documentField holds the mapping of a struct field to a Solr field, dynamic fields (e.g. attr_*) are mapped to map[string]T fields
+type documentField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	dynamic   bool
+	prefix    string
+	suffix    string
+}
+
+// documentsResponseData is used to decode the documents of a response lazily (with json.Number values), the other parts are decoded
+// into the embedded SolrResponseData
+type documentsResponseData struct {
+	SolrResponseData
+	Response documentsResponse `json:"response"`
+}
+
+type documentsResponse struct {
+	NumFound int64             `json:"numFound,omitempty"`
+	Start    int64             `json:"start,omitempty"`
+	MaxScore float32           `json:"maxScore,omitempty"`
+	Docs     []json.RawMessage `json:"docs,omitempty"`
+}
+
+// MarshalDocument convert a struct (or pointer to struct) into a Solr document, struct fields are mapped with `solr:"field_name,omitempty"` tags,
+// time.Time values are converted to Solr dates, slices to multi-valued fields, nested structs to child documents, and map[string]T fields
+// with a wildcard tag (e.g. `solr:"attr_*"`) to dynamic fields; fields with `solr:"-"` tag and nil pointers are skipped
+func MarshalDocument(v interface{}) (SolrDocument, error) {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, fmt.Errorf("cannot marshal nil pointer to Solr document")
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot marshal %s to Solr document, struct is expected", value.Type())
+	}
+	return marshalStruct(value)
+}
+
+// MarshalDocuments convert a slice of structs into Solr documents (a single struct is converted to one document)
+func MarshalDocuments(v interface{}) ([]SolrDocument, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		doc, err := MarshalDocument(v)
+		if err != nil {
+			return nil, err
+		}
+		return []SolrDocument{doc}, nil
+	}
+	docs := make([]SolrDocument, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		doc, err := MarshalDocument(value.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// UnmarshalDocument fill a struct (v should be a pointer to struct) from a Solr document, based on the `solr` struct tags
+func UnmarshalDocument(doc SolrDocument, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("cannot unmarshal Solr document into %T, non-nil pointer is expected", v)
+	}
+	return unmarshalValue(map[string]interface{}(doc), value.Elem())
+}
+
+// UnmarshalDocuments fill a slice of structs (v should be a pointer to slice) from Solr documents
+func UnmarshalDocuments(docs []SolrDocument, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("cannot unmarshal Solr documents into %T, non-nil pointer to slice is expected", v)
+	}
+	rawDocs := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		rawDocs = append(rawDocs, map[string]interface{}(doc))
+	}
+	return unmarshalValue(rawDocs, value.Elem())
+}
+
+// AddStructs send structs (a slice of structs or a single struct) to Solr as documents, see MarshalDocument for the field mapping
+func (solrClient *SolrClient) AddStructs(docs interface{}, parameters *url.Values, commit bool) (bool, *SolrResponseData, error) {
+	return solrClient.AddStructsContext(context.Background(), docs, parameters, commit)
+}
+
+// AddStructsContext send structs to Solr as documents, the request is cancelled when the context is done
+func (solrClient *SolrClient) AddStructsContext(ctx context.Context, docs interface{}, parameters *url.Values, commit bool) (bool, *SolrResponseData, error) {
+	solrDocs, err := MarshalDocuments(docs)
+	if err != nil {
+		return false, nil, err
+	}
+	return solrClient.UpdateContext(ctx, solrDocs, parameters, commit)
+}
+
+// QueryInto get Solr data based on parameters and decode the documents into v (pointer to a slice of structs), see UnmarshalDocument for the field mapping,
+// the returned response holds every other part of the response (header, numFound, facets etc.)
+func (solrClient *SolrClient) QueryInto(solrQuery *SolrQuery, v interface{}) (bool, *SolrResponseData, error) {
+	return solrClient.QueryIntoContext(context.Background(), solrQuery, v)
+}
+
+// QueryIntoContext get Solr data based on parameters and decode the documents into v, the request is cancelled when the context is done
+func (solrClient *SolrClient) QueryIntoContext(ctx context.Context, solrQuery *SolrQuery, v interface{}) (bool, *SolrResponseData, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Slice {
+		return false, nil, fmt.Errorf("cannot decode Solr documents into %T, non-nil pointer to slice is expected", v)
+	}
+	solrResponse, rawDocs, err := solrClient.queryDocuments(ctx, solrQuery)
+	if err != nil {
+		return false, nil, err
+	}
+	if err := unmarshalValue(rawDocs, value.Elem()); err != nil {
+		return false, nil, err
+	}
+	return true, solrResponse.responseData(), nil
+}
+
+// queryDocuments get Solr data based on parameters, the documents are returned separately (decoded with json.Number values)
+func (solrClient *SolrClient) queryDocuments(ctx context.Context, solrQuery *SolrQuery) (*documentsResponseData, []interface{}, error) {
+	if solrQuery == nil {
+		solrQuery = CreateSolrQuery()
+	}
+	if err := validateCursorParams(*solrQuery.params, solrClient.solrConfig.uniqueKey()); err != nil {
+		return nil, nil, err
+	}
+	var solrResponse documentsResponseData
+	request := &solrRequest{method: "POST", handler: "select", operation: OperationSelect, params: *solrQuery.params, idempotent: true}
+	if err := solrClient.execute(ctx, request, &solrResponse); err != nil {
+		return nil, nil, err
+	}
+	rawDocs := make([]interface{}, 0, len(solrResponse.Response.Docs))
+	for _, rawDoc := range solrResponse.Response.Docs {
+		var doc map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(rawDoc))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, nil, err
+		}
+		rawDocs = append(rawDocs, doc)
+	}
+	return &solrResponse, rawDocs, nil
+}
+
+// responseData returns the response without the documents
+func (solrResponse *documentsResponseData) responseData() *SolrResponseData {
+	responseData := solrResponse.SolrResponseData
+	responseData.Response = SolrResponse{NumFound: solrResponse.Response.NumFound, Start: solrResponse.Response.Start,
+		MaxScore: solrResponse.Response.MaxScore}
+	return &responseData
+}
+
+// documentFields returns the Solr field mapping of a struct type (cached)
+func documentFields(structType reflect.Type) []documentField {
+	if fields, ok := structFieldsCache.Load(structType); ok {
+		return fields.([]documentField)
+	}
+	fields := collectDocumentFields(structType, nil)
+	structFieldsCache.Store(structType, fields)
+	return fields
+}
+
+func collectDocumentFields(structType reflect.Type, parentIndex []int) []documentField {
+	var fields []documentField
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		tag, hasTag := structField.Tag.Lookup("solr")
+		if tag == "-" {
+			continue
+		}
+		index := append(append([]int(nil), parentIndex...), i)
+		// fields of embedded structs are promoted, embedded struct pointers are mapped as nested structs (child documents)
+		if structField.Anonymous && !hasTag && structField.Type.Kind() == reflect.Struct {
+			fields = append(fields, collectDocumentFields(structField.Type, index)...)
+			continue
+		}
+		if len(structField.PkgPath) != 0 {
+			continue
+		}
+		field := documentField{name: structField.Name, index: index}
+		if hasTag {
+			options := strings.Split(tag, ",")
+			if len(options[0]) != 0 {
+				field.name = options[0]
+			}
+			for _, option := range options[1:] {
+				if option == "omitempty" {
+					field.omitEmpty = true
+				}
+			}
+		}
+		if wildcard := strings.Index(field.name, "*"); wildcard >= 0 && structField.Type.Kind() == reflect.Map &&
+			structField.Type.Key().Kind() == reflect.String {
+			field.dynamic = true
+			field.prefix = field.name[:wildcard]
+			field.suffix = field.name[wildcard+1:]
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// matches returns the dynamic part of the Solr field name, if it matches the dynamic field pattern
+func (field *documentField) matches(name string) (string, bool) {
+	if len(name) < len(field.prefix)+len(field.suffix) || !strings.HasPrefix(name, field.prefix) || !strings.HasSuffix(name, field.suffix) {
+		return "", false
+	}
+	return name[len(field.prefix) : len(name)-len(field.suffix)], true
+}
+
+func marshalStruct(value reflect.Value) (SolrDocument, error) {
+	doc := make(SolrDocument)
+	for _, field := range documentFields(value.Type()) {
+		fieldValue := value.FieldByIndex(field.index)
+		if field.omitEmpty && isEmptyValue(fieldValue) {
+			continue
+		}
+		if field.dynamic {
+			iter := fieldValue.MapRange()
+			for iter.Next() {
+				marshaled, err := marshalValue(iter.Value())
+				if err != nil {
+					return nil, err
+				}
+				if marshaled != nil {
+					doc[field.prefix+iter.Key().String()+field.suffix] = marshaled
+				}
+			}
+			continue
+		}
+		marshaled, err := marshalValue(fieldValue)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal field %s: %v", field.name, err)
+		}
+		if marshaled != nil {
+			doc[field.name] = marshaled
+		}
+	}
+	return doc, nil
+}
+
+func marshalValue(value reflect.Value) (interface{}, error) {
+	switch value.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(value.Elem())
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return value.Interface().(time.Time).UTC().Format(SolrDateFormat), nil
+		}
+		return marshalStruct(value)
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return nil, nil
+		}
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			// byte slices are encoded as base64 strings (Solr binary fields)
+			return value.Interface(), nil
+		}
+		values := make([]interface{}, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			marshaled, err := marshalValue(value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, marshaled)
+		}
+		return values, nil
+	case reflect.Map:
+		if value.IsNil() {
+			return nil, nil
+		}
+		if value.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type: %s", value.Type().Key())
+		}
+		values := make(map[string]interface{}, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			marshaled, err := marshalValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			values[iter.Key().String()] = marshaled
+		}
+		return values, nil
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return nil, fmt.Errorf("unsupported type: %s", value.Type())
+	}
+	return value.Interface(), nil
+}
+
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return value.IsNil()
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return value.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+func unmarshalStruct(doc map[string]interface{}, value reflect.Value) error {
+	fields := documentFields(value.Type())
+	mapped := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if !field.dynamic {
+			mapped[field.name] = true
+		}
+	}
+	for _, field := range fields {
+		fieldValue := value.FieldByIndex(field.index)
+		if !field.dynamic {
+			raw, ok := doc[field.name]
+			if !ok {
+				continue
+			}
+			if err := unmarshalValue(raw, fieldValue); err != nil {
+				return fmt.Errorf("cannot unmarshal field %s: %v", field.name, err)
+			}
+			continue
+		}
+		for name, raw := range doc {
+			if mapped[name] {
+				continue
+			}
+			key, ok := field.matches(name)
+			if !ok {
+				continue
+			}
+			if fieldValue.IsNil() {
+				fieldValue.Set(reflect.MakeMap(fieldValue.Type()))
+			}
+			elem := reflect.New(fieldValue.Type().Elem()).Elem()
+			if err := unmarshalValue(raw, elem); err != nil {
+				return fmt.Errorf("cannot unmarshal field %s: %v", name, err)
+			}
+			fieldValue.SetMapIndex(reflect.ValueOf(key).Convert(fieldValue.Type().Key()), elem)
+		}
+	}
+	return nil
+}
+
+func unmarshalValue(raw interface{}, value reflect.Value) error {
+	if raw == nil {
+		return nil
+	}
+	if doc, ok := raw.(SolrDocument); ok {
+		// child documents of marshaled structs
+		raw = map[string]interface{}(doc)
+	}
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		return unmarshalValue(raw, value.Elem())
+	case reflect.Interface:
+		if value.NumMethod() != 0 {
+			return fmt.Errorf("unsupported type: %s", value.Type())
+		}
+		value.Set(reflect.ValueOf(normalizeNumbers(raw)))
+		return nil
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			switch typed := raw.(type) {
+			case string:
+				decoded, err := base64.StdEncoding.DecodeString(typed)
+				if err != nil {
+					return fmt.Errorf("cannot decode binary value: %v", err)
+				}
+				value.SetBytes(decoded)
+				return nil
+			case []byte:
+				value.SetBytes(append([]byte(nil), typed...))
+				return nil
+			}
+		}
+		rawValues, ok := raw.([]interface{})
+		if !ok {
+			// single value of a multi-valued field
+			rawValues = []interface{}{raw}
+		}
+		values := reflect.MakeSlice(value.Type(), len(rawValues), len(rawValues))
+		for i, rawValue := range rawValues {
+			if err := unmarshalValue(rawValue, values.Index(i)); err != nil {
+				return err
+			}
+		}
+		value.Set(values)
+		return nil
+	case reflect.Map:
+		rawMap, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot unmarshal %T into %s", raw, value.Type())
+		}
+		if value.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type: %s", value.Type().Key())
+		}
+		if value.IsNil() {
+			value.Set(reflect.MakeMap(value.Type()))
+		}
+		for key, rawValue := range rawMap {
+			elem := reflect.New(value.Type().Elem()).Elem()
+			if err := unmarshalValue(rawValue, elem); err != nil {
+				return err
+			}
+			value.SetMapIndex(reflect.ValueOf(key).Convert(value.Type().Key()), elem)
+		}
+		return nil
+	}
+
+	if rawValues, ok := raw.([]interface{}); ok {
+		// single valued struct field from a multi-valued Solr field
+		if len(rawValues) == 0 {
+			return nil
+		}
+		if len(rawValues) > 1 {
+			return fmt.Errorf("cannot unmarshal %d values into %s", len(rawValues), value.Type())
+		}
+		raw = rawValues[0]
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() == timeType {
+			text, ok := raw.(string)
+			if !ok {
+				return fmt.Errorf("cannot unmarshal %T into time.Time", raw)
+			}
+			date, err := time.Parse(time.RFC3339Nano, text)
+			if err != nil {
+				return err
+			}
+			value.Set(reflect.ValueOf(date))
+			return nil
+		}
+		doc, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot unmarshal %T into %s", raw, value.Type())
+		}
+		return unmarshalStruct(doc, value)
+	case reflect.String:
+		text, ok := raw.(string)
+		if !ok {
+			if number, isNumber := raw.(json.Number); isNumber {
+				text = number.String()
+			} else {
+				return fmt.Errorf("cannot unmarshal %T into %s", raw, value.Type())
+			}
+		}
+		value.SetString(text)
+		return nil
+	case reflect.Bool:
+		switch typed := raw.(type) {
+		case bool:
+			value.SetBool(typed)
+		case string:
+			parsed, err := strconv.ParseBool(typed)
+			if err != nil {
+				return err
+			}
+			value.SetBool(parsed)
+		default:
+			return fmt.Errorf("cannot unmarshal %T into %s", raw, value.Type())
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, err := rawInt(raw)
+		if err != nil {
+			return err
+		}
+		if value.OverflowInt(number) {
+			return fmt.Errorf("value %d overflows %s", number, value.Type())
+		}
+		value.SetInt(number)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, err := rawInt(raw)
+		if err != nil {
+			return err
+		}
+		if number < 0 || value.OverflowUint(uint64(number)) {
+			return fmt.Errorf("value %d overflows %s", number, value.Type())
+		}
+		value.SetUint(uint64(number))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		number, err := rawFloat(raw)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(number)
+		return nil
+	}
+	return fmt.Errorf("unsupported type: %s", value.Type())
+}
+
+func rawInt(raw interface{}) (int64, error) {
+	switch typed := raw.(type) {
+	case json.Number:
+		if number, err := typed.Int64(); err == nil {
+			return number, nil
+		}
+		return 0, fmt.Errorf("cannot unmarshal %s into integer", typed)
+	case float64:
+		if typed != math.Trunc(typed) {
+			return 0, fmt.Errorf("cannot unmarshal %v into integer", typed)
+		}
+		return int64(typed), nil
+	case string:
+		return strconv.ParseInt(typed, 10, 64)
+	}
+	// numbers of marshaled structs (documents that were not sent through JSON)
+	number := reflect.ValueOf(raw)
+	switch number.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if number.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("value %d overflows int64", number.Uint())
+		}
+		return int64(number.Uint()), nil
+	case reflect.Float32:
+		return rawInt(number.Float())
+	}
+	return 0, fmt.Errorf("cannot unmarshal %T into integer", raw)
+}
+
+func rawFloat(raw interface{}) (float64, error) {
+	switch typed := raw.(type) {
+	case json.Number:
+		return typed.Float64()
+	case float64:
+		return typed, nil
+	case string:
+		return strconv.ParseFloat(typed, 64)
+	}
+	number := reflect.ValueOf(raw)
+	switch number.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(number.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(number.Uint()), nil
+	case reflect.Float32:
+		return number.Float(), nil
+	}
+	return 0, fmt.Errorf("cannot unmarshal %T into float", raw)
+}
+
+// normalizeNumbers convert json.Number values to int64 (or float64 if they are not integers), so interface{} fields get the same kind
+// of values regardless how the response was decoded
+func normalizeNumbers(raw interface{}) interface{} {
+	switch typed := raw.(type) {
+	case json.Number:
+		if number, err := typed.Int64(); err == nil {
+			return number
+		}
+		number, _ := typed.Float64()
+		return number
+	case []interface{}:
+		values := make([]interface{}, len(typed))
+		for i, value := range typed {
+			values[i] = normalizeNumbers(value)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(typed))
+		for key, value := range typed {
+			values[key] = normalizeNumbers(value)
+		}
+		return values
+	}
+	return raw
+}
@@ -0,0 +1,244 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type marshalTestChild struct {
+	ID   string `solr:"id"`
+	Name string `solr:"name_s,omitempty"`
+}
+
+type marshalTestAudit struct {
+	Source string `solr:"source_s,omitempty"`
+}
+
+type marshalTestDocument struct {
+	marshalTestAudit
+	ID        string             `solr:"id"`
+	Title     string             `solr:"title_s,omitempty"`
+	Count     int                `solr:"count_i,omitempty"`
+	Skipped   string             `solr:"-"`
+	Rating    *float64           `solr:"rating_d"`
+	Owner     *string            `solr:"owner_s,omitempty"`
+	Created   time.Time          `solr:"created_dt"`
+	Updated   *time.Time         `solr:"updated_dt,omitempty"`
+	Tags      []string           `solr:"tags_ss"`
+	Scores    []int64            `solr:"scores_ls,omitempty"`
+	Version   int64              `solr:"_version_"`
+	Counter   uint64             `solr:"counter_l"`
+	Attrs     map[string]string  `solr:"attr_*"`
+	Children  []marshalTestChild `solr:"_childDocuments_,omitempty"`
+	Untagged  bool
+	Data      []byte `solr:"data_bin,omitempty"`
+	unexposed string
+}
+
+// wireRoundTrip send the document through JSON, and decode it the same way as documents of query responses are decoded
+func wireRoundTrip(t *testing.T, doc SolrDocument) SolrDocument {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("cannot encode document: %v", err)
+	}
+	var decoded SolrDocument
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		t.Fatalf("cannot decode document: %v", err)
+	}
+	return decoded
+}
+
+func TestMarshalDocumentFields(t *testing.T) {
+	rating := 4.5
+	created := time.Date(2018, 3, 14, 15, 9, 26, 535000000, time.FixedZone("CET", 3600))
+	doc, err := MarshalDocument(&marshalTestDocument{ID: "1", Skipped: "skipped", Rating: &rating, Created: created,
+		Tags: []string{"a", "b"}, Attrs: map[string]string{"color": "red"}, unexposed: "hidden"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := SolrDocument{
+		"id":         "1",
+		"rating_d":   4.5,
+		"created_dt": "2018-03-14T14:09:26.535Z",
+		"tags_ss":    []interface{}{"a", "b"},
+		"_version_":  int64(0),
+		"counter_l":  uint64(0),
+		"attr_color": "red",
+		"Untagged":   false,
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Fatalf("unexpected document:\n%#v\nexpected:\n%#v", doc, expected)
+	}
+}
+
+func TestMarshalDocumentRoundTrip(t *testing.T) {
+	rating := 0.0
+	owner := "solr"
+	updated := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		document marshalTestDocument
+		expected marshalTestDocument
+	}{
+		{
+			name:     "empty values",
+			document: marshalTestDocument{ID: "empty"},
+		},
+		{
+			name:     "omitempty values are restored as zero values",
+			document: marshalTestDocument{ID: "zero", Title: "", Count: 0, Scores: []int64{}},
+			expected: marshalTestDocument{ID: "zero"},
+		},
+		{
+			name:     "skipped and unexported fields are not sent",
+			document: marshalTestDocument{ID: "skipped", Skipped: "skipped", unexposed: "hidden"},
+			expected: marshalTestDocument{ID: "skipped"},
+		},
+		{
+			name:     "pointer fields",
+			document: marshalTestDocument{ID: "pointers", Rating: &rating, Owner: &owner, Updated: &updated},
+		},
+		{
+			name:     "dates are sent in UTC",
+			document: marshalTestDocument{ID: "dates", Created: time.Date(2018, 3, 14, 15, 9, 26, 535000000, time.FixedZone("CET", 3600))},
+			expected: marshalTestDocument{ID: "dates", Created: time.Date(2018, 3, 14, 14, 9, 26, 535000000, time.UTC)},
+		},
+		{
+			name:     "multi-valued fields",
+			document: marshalTestDocument{ID: "multi", Tags: []string{"a", "b", "c"}, Scores: []int64{1, -2, 3}},
+		},
+		{
+			name:     "int64 values past 2^53",
+			document: marshalTestDocument{ID: "large", Version: 1<<53 + 1, Counter: 1<<63 - 1, Scores: []int64{-(1<<53 + 1), 1<<62 + 3}},
+		},
+		{
+			name:     "embedded struct fields",
+			document: marshalTestDocument{ID: "embedded", marshalTestAudit: marshalTestAudit{Source: "import"}},
+		},
+		{
+			name:     "binary fields",
+			document: marshalTestDocument{ID: "binary", Data: []byte{0, 1, 2, 0xfe, 0xff}},
+		},
+		{
+			name: "dynamic fields and child documents",
+			document: marshalTestDocument{ID: "nested", Attrs: map[string]string{"color": "red", "size": "xl"},
+				Children: []marshalTestChild{{ID: "child-1", Name: "first"}, {ID: "child-2"}}, Untagged: true},
+		},
+	}
+	for _, testCase := range testCases {
+		expected := testCase.expected
+		if len(expected.ID) == 0 {
+			expected = testCase.document
+		}
+		doc, err := MarshalDocument(testCase.document)
+		if err != nil {
+			t.Fatalf("%s: cannot marshal document: %v", testCase.name, err)
+		}
+		for _, roundTrip := range []struct {
+			name string
+			doc  SolrDocument
+		}{{"in memory", doc}, {"json", wireRoundTrip(t, doc)}} {
+			var actual marshalTestDocument
+			if err := UnmarshalDocument(roundTrip.doc, &actual); err != nil {
+				t.Fatalf("%s (%s): cannot unmarshal document: %v", testCase.name, roundTrip.name, err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s (%s): unexpected document:\n%#v\nexpected:\n%#v", testCase.name, roundTrip.name, actual, expected)
+			}
+		}
+	}
+}
+
+func TestUnmarshalDocumentLargeNumbers(t *testing.T) {
+	var actual marshalTestDocument
+	doc := wireRoundTrip(t, SolrDocument{"id": "1", "_version_": json.Number("9007199254740993"), "scores_ls": []interface{}{json.Number("-9223372036854775808")}})
+	if err := UnmarshalDocument(doc, &actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual.Version != 9007199254740993 || actual.Scores[0] != -9223372036854775808 {
+		t.Fatalf("large numbers lost precision: %d %v", actual.Version, actual.Scores)
+	}
+	var overflow struct {
+		Value int32 `solr:"value_i"`
+	}
+	if err := UnmarshalDocument(SolrDocument{"value_i": json.Number("9007199254740993")}, &overflow); err == nil {
+		t.Fatalf("expected overflow error")
+	}
+}
+
+func TestUnmarshalDocumentErrors(t *testing.T) {
+	var document marshalTestDocument
+	testCases := []struct {
+		name   string
+		doc    SolrDocument
+		target interface{}
+	}{
+		{"non pointer target", SolrDocument{"id": "1"}, document},
+		{"nil pointer target", SolrDocument{"id": "1"}, (*marshalTestDocument)(nil)},
+		{"invalid date", SolrDocument{"created_dt": "yesterday"}, &document},
+		{"multiple values for single valued field", SolrDocument{"title_s": []interface{}{"a", "b"}}, &document},
+		{"fractional value for integer field", SolrDocument{"count_i": 1.5}, &document},
+		{"invalid base64 value for binary field", SolrDocument{"data_bin": "not base64!"}, &document},
+	}
+	for _, testCase := range testCases {
+		if err := UnmarshalDocument(testCase.doc, testCase.target); err == nil {
+			t.Errorf("%s: expected error", testCase.name)
+		}
+	}
+}
+
+func TestMarshalDocumentBinaryAndEmbeddedFields(t *testing.T) {
+	doc, err := MarshalDocument(marshalTestDocument{ID: "1", Data: []byte("solr"), marshalTestAudit: marshalTestAudit{Source: "import"}})
+	if err != nil {
+		t.Fatalf("cannot marshal document: %v", err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{"data_bin": doc["data_bin"], "source_s": doc["source_s"]})
+	if string(body) != `{"data_bin":"c29scg==","source_s":"import"}` {
+		t.Fatalf("unexpected binary or embedded fields: %s", body)
+	}
+	if _, ok := doc["marshalTestAudit"]; ok {
+		t.Fatalf("embedded struct should be promoted: %v", doc)
+	}
+
+	type Audit struct {
+		Source string `solr:"source_s"`
+	}
+	type pointerEmbedded struct {
+		*Audit
+		ID string `solr:"id"`
+	}
+	doc, err = MarshalDocument(pointerEmbedded{ID: "2", Audit: &Audit{Source: "import"}})
+	if err != nil {
+		t.Fatalf("cannot marshal document: %v", err)
+	}
+	child, ok := doc["Audit"].(SolrDocument)
+	if !ok || child["source_s"] != "import" {
+		t.Fatalf("embedded struct pointer should be a nested document: %#v", doc)
+	}
+	var actual pointerEmbedded
+	if err := UnmarshalDocument(wireRoundTrip(t, doc), &actual); err != nil {
+		t.Fatalf("cannot unmarshal document: %v", err)
+	}
+	if actual.ID != "2" || actual.Audit == nil || actual.Audit.Source != "import" {
+		t.Fatalf("unexpected document: %+v", actual)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are the default upper bounds (in seconds) of the request latency histogram
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics records the instrumentation of the Solr client, it can be injected with the WithMetrics client option
+type Metrics interface {
+	// ObserveRequest records a finished client call (including every retry), err is nil if the call succeeded
+	ObserveRequest(collection string, operation Operation, duration time.Duration, err error)
+	// ObserveBytes records the bytes sent and received by a single HTTP round trip
+	ObserveBytes(collection string, operation Operation, sent int64, received int64)
+	// ObserveRetry records that a call is sent again (to the same or to another node)
+	ObserveRetry(collection string, operation Operation)
+	// SetNodeHealth replace the health of the Solr nodes (node url -> alive)
+	SetNodeHealth(nodes map[string]bool)
+}
+
+// NopMetrics drops every measurement, it is the default metrics of the Solr client
+type NopMetrics struct{}
+
+// PrometheusMetrics collects the client metrics in memory and exposes them in Prometheus text format (it is an http.Handler)
+type PrometheusMetrics struct {
+	mutex      sync.Mutex
+	buckets    []float64
+	requests   map[metricLabels]*requestMetrics
+	errors     map[errorLabels]int64
+	nodeHealth map[string]bool
+}
+
+type metricLabels struct {
+	collection string
+	operation  Operation
+}
+
+type errorLabels struct {
+	metricLabels
+	httpStatus string
+	solrCode   string
+}
+
+type requestMetrics struct {
+	success       int64
+	failure       int64
+	bucketCounts  []int64
+	durationSum   float64
+	bytesSent     int64
+	bytesReceived int64
+	retries       int64
+}
+
+// WithMetrics set the metrics of the Solr client
+func WithMetrics(metrics Metrics) ClientOption {
+	return func(solrClient *SolrClient) {
+		solrClient.metrics = metrics
+	}
+}
+
+// ObserveRequest drops the measurement
+func (NopMetrics) ObserveRequest(collection string, operation Operation, duration time.Duration, err error) {
+}
+
+// ObserveBytes drops the measurement
+func (NopMetrics) ObserveBytes(collection string, operation Operation, sent int64, received int64) {}
+
+// ObserveRetry drops the measurement
+func (NopMetrics) ObserveRetry(collection string, operation Operation) {}
+
+// SetNodeHealth drops the measurement
+func (NopMetrics) SetNodeHealth(nodes map[string]bool) {}
+
+// NewPrometheusMetrics create an in-memory metrics collector, DefaultLatencyBuckets are used if no buckets are provided
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	sortedBuckets := append([]float64(nil), buckets...)
+	sort.Float64s(sortedBuckets)
+	return &PrometheusMetrics{buckets: sortedBuckets, requests: make(map[metricLabels]*requestMetrics),
+		errors: make(map[errorLabels]int64), nodeHealth: make(map[string]bool)}
+}
+
+// ObserveRequest count the call, its latency and the error by HTTP status and Solr error code
+func (metrics *PrometheusMetrics) ObserveRequest(collection string, operation Operation, duration time.Duration, err error) {
+	labels := metricLabels{collection: collection, operation: operation}
+	seconds := duration.Seconds()
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	request := metrics.request(labels)
+	if err == nil {
+		request.success++
+	} else {
+		request.failure++
+		httpStatus, solrCode := errorStatus(err)
+		metrics.errors[errorLabels{metricLabels: labels, httpStatus: httpStatus, solrCode: solrCode}]++
+	}
+	for i, bound := range metrics.buckets {
+		if seconds <= bound {
+			request.bucketCounts[i]++
+		}
+	}
+	request.durationSum += seconds
+}
+
+// ObserveBytes count the bytes sent and received
+func (metrics *PrometheusMetrics) ObserveBytes(collection string, operation Operation, sent int64, received int64) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	request := metrics.request(metricLabels{collection: collection, operation: operation})
+	request.bytesSent += sent
+	request.bytesReceived += received
+}
+
+// ObserveRetry count the retry
+func (metrics *PrometheusMetrics) ObserveRetry(collection string, operation Operation) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.request(metricLabels{collection: collection, operation: operation}).retries++
+}
+
+// SetNodeHealth replace the node health gauges
+func (metrics *PrometheusMetrics) SetNodeHealth(nodes map[string]bool) {
+	nodeHealth := make(map[string]bool, len(nodes))
+	for node, alive := range nodes {
+		nodeHealth[node] = alive
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.nodeHealth = nodeHealth
+}
+
+// ServeHTTP write the metrics in Prometheus text format, so the collector can be scraped from an HTTP endpoint
+func (metrics *PrometheusMetrics) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WriteTo(writer)
+}
+
+// WriteTo write the metrics in Prometheus text format
+func (metrics *PrometheusMetrics) WriteTo(writer io.Writer) (int64, error) {
+	var buffer bytes.Buffer
+	metrics.mutex.Lock()
+	requestLabels := make([]metricLabels, 0, len(metrics.requests))
+	for labels := range metrics.requests {
+		requestLabels = append(requestLabels, labels)
+	}
+	sort.Slice(requestLabels, func(i, j int) bool {
+		return requestLabels[i].String() < requestLabels[j].String()
+	})
+
+	writeMetricHeader(&buffer, "solr_client_requests_total", "counter", "Number of Solr client calls by result.")
+	for _, labels := range requestLabels {
+		request := metrics.requests[labels]
+		fmt.Fprintf(&buffer, "solr_client_requests_total{%s,result=\"success\"} %d\n", labels, request.success)
+		fmt.Fprintf(&buffer, "solr_client_requests_total{%s,result=\"error\"} %d\n", labels, request.failure)
+	}
+
+	writeMetricHeader(&buffer, "solr_client_request_duration_seconds", "histogram", "Latency of Solr client calls (including retries).")
+	for _, labels := range requestLabels {
+		request := metrics.requests[labels]
+		for i, bound := range metrics.buckets {
+			fmt.Fprintf(&buffer, "solr_client_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), request.bucketCounts[i])
+		}
+		fmt.Fprintf(&buffer, "solr_client_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, request.success+request.failure)
+		fmt.Fprintf(&buffer, "solr_client_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(request.durationSum))
+		fmt.Fprintf(&buffer, "solr_client_request_duration_seconds_count{%s} %d\n", labels, request.success+request.failure)
+	}
+
+	errorKeys := make([]errorLabels, 0, len(metrics.errors))
+	for labels := range metrics.errors {
+		errorKeys = append(errorKeys, labels)
+	}
+	sort.Slice(errorKeys, func(i, j int) bool {
+		return errorKeys[i].String() < errorKeys[j].String()
+	})
+	writeMetricHeader(&buffer, "solr_client_errors_total", "counter", "Number of failed Solr client calls by HTTP status and Solr error code.")
+	for _, labels := range errorKeys {
+		fmt.Fprintf(&buffer, "solr_client_errors_total{%s} %d\n", labels, metrics.errors[labels])
+	}
+
+	writeMetricHeader(&buffer, "solr_client_sent_bytes_total", "counter", "Number of request body bytes sent to Solr.")
+	for _, labels := range requestLabels {
+		fmt.Fprintf(&buffer, "solr_client_sent_bytes_total{%s} %d\n", labels, metrics.requests[labels].bytesSent)
+	}
+	writeMetricHeader(&buffer, "solr_client_received_bytes_total", "counter", "Number of response body bytes received from Solr.")
+	for _, labels := range requestLabels {
+		fmt.Fprintf(&buffer, "solr_client_received_bytes_total{%s} %d\n", labels, metrics.requests[labels].bytesReceived)
+	}
+	writeMetricHeader(&buffer, "solr_client_retries_total", "counter", "Number of retried Solr requests.")
+	for _, labels := range requestLabels {
+		fmt.Fprintf(&buffer, "solr_client_retries_total{%s} %d\n", labels, metrics.requests[labels].retries)
+	}
+
+	nodes := make([]string, 0, len(metrics.nodeHealth))
+	for node := range metrics.nodeHealth {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	writeMetricHeader(&buffer, "solr_client_node_up", "gauge", "Whether the Solr node is considered alive by the client (1) or not (0).")
+	for _, node := range nodes {
+		up := 0
+		if metrics.nodeHealth[node] {
+			up = 1
+		}
+		fmt.Fprintf(&buffer, "solr_client_node_up{node=\"%s\"} %d\n", escapeLabelValue(node), up)
+	}
+	metrics.mutex.Unlock()
+
+	written, err := writer.Write(buffer.Bytes())
+	return int64(written), err
+}
+
+func (metrics *PrometheusMetrics) request(labels metricLabels) *requestMetrics {
+	request, ok := metrics.requests[labels]
+	if !ok {
+		request = &requestMetrics{bucketCounts: make([]int64, len(metrics.buckets))}
+		metrics.requests[labels] = request
+	}
+	return request
+}
+
+// String returns the labels in Prometheus text format (without braces)
+func (labels metricLabels) String() string {
+	return fmt.Sprintf("collection=\"%s\",operation=\"%s\"", escapeLabelValue(labels.collection), escapeLabelValue(string(labels.operation)))
+}
+
+// String returns the labels in Prometheus text format (without braces)
+func (labels errorLabels) String() string {
+	return fmt.Sprintf("%s,http_status=\"%s\",solr_code=\"%s\"", labels.metricLabels, labels.httpStatus, labels.solrCode)
+}
+
+// errorStatus returns the HTTP status and Solr error code labels of a failed call
+func errorStatus(err error) (string, string) {
+	if solrError := AsSolrError(err); solrError != nil {
+		return strconv.Itoa(solrError.HTTPStatus), strconv.Itoa(solrError.Code)
+	}
+	var connErr *connectionError
+	if errors.As(err, &connErr) {
+		return "connection_error", ""
+	}
+	if IsCircuitOpen(err) {
+		return "circuit_open", ""
+	}
+	return "other", ""
+}
+
+func writeMetricHeader(buffer *bytes.Buffer, name string, metricType string, help string) {
+	fmt.Fprintf(buffer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+// reportNodeHealth send the current health of the nodes to the metrics
+func (solrClient *SolrClient) reportNodeHealth() {
+	solrClient.nodes.mutex.RLock()
+	nodes := make(map[string]bool, len(solrClient.nodes.nodes))
+	for _, node := range solrClient.nodes.nodes {
+		nodes[node.url] = atomic.LoadInt32(&node.alive) == 1
+	}
+	solrClient.nodes.mutex.RUnlock()
+	solrClient.metrics.SetNodeHealth(nodes)
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrapeLines returns the lines of the Prometheus text output that start with the prefix
+func scrapeLines(output string, prefix string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func assertLines(t *testing.T, output string, prefix string, expected ...string) {
+	t.Helper()
+	lines := scrapeLines(output, prefix)
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected %s lines:\n%s\nexpected:\n%s", prefix, strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestPrometheusMetricsWriteTo(t *testing.T) {
+	metrics := NewPrometheusMetrics(1, 0.1)
+	solrError := &SolrError{HTTPStatus: 409, Code: 409, Msg: "version conflict"}
+	metrics.ObserveRequest("logs", OperationSelect, 50*time.Millisecond, nil)
+	metrics.ObserveRequest("logs", OperationSelect, 500*time.Millisecond, nil)
+	metrics.ObserveRequest("logs", OperationSelect, 2*time.Second, errors.New("failed"))
+	metrics.ObserveRequest("logs", OperationUpdate, 100*time.Millisecond, solrError)
+	metrics.ObserveRequest("a\"b\\c\nd", OperationSelect, time.Millisecond, nil)
+	metrics.ObserveBytes("logs", OperationUpdate, 120, 40)
+	metrics.ObserveBytes("logs", OperationUpdate, 30, 10)
+	metrics.ObserveRetry("logs", OperationSelect)
+	metrics.SetNodeHealth(map[string]bool{"http://solr2:8983": false, "http://solr1:8983": true})
+
+	var buffer bytes.Buffer
+	written, err := metrics.WriteTo(&buffer)
+	if err != nil || written != int64(buffer.Len()) {
+		t.Fatalf("unexpected result: %d, %v", written, err)
+	}
+	output := buffer.String()
+
+	for _, header := range []string{
+		"# HELP solr_client_requests_total Number of Solr client calls by result.\n# TYPE solr_client_requests_total counter\n",
+		"# HELP solr_client_request_duration_seconds Latency of Solr client calls (including retries).\n# TYPE solr_client_request_duration_seconds histogram\n",
+		"# TYPE solr_client_errors_total counter\n",
+		"# TYPE solr_client_sent_bytes_total counter\n",
+		"# TYPE solr_client_received_bytes_total counter\n",
+		"# TYPE solr_client_retries_total counter\n",
+		"# HELP solr_client_node_up Whether the Solr node is considered alive by the client (1) or not (0).\n# TYPE solr_client_node_up gauge\n",
+	} {
+		if !strings.Contains(output, header) {
+			t.Errorf("missing metric header: %q", header)
+		}
+	}
+
+	escaped := `collection="a\"b\\c\nd",operation="select"`
+	assertLines(t, output, "solr_client_requests_total{",
+		`solr_client_requests_total{`+escaped+`,result="success"} 1`,
+		`solr_client_requests_total{`+escaped+`,result="error"} 0`,
+		`solr_client_requests_total{collection="logs",operation="select",result="success"} 2`,
+		`solr_client_requests_total{collection="logs",operation="select",result="error"} 1`,
+		`solr_client_requests_total{collection="logs",operation="update",result="success"} 0`,
+		`solr_client_requests_total{collection="logs",operation="update",result="error"} 1`)
+	// the buckets are sorted and cumulative, the +Inf bucket equals to the count
+	assertLines(t, output, `solr_client_request_duration_seconds_bucket{collection="logs",operation="select"`,
+		`solr_client_request_duration_seconds_bucket{collection="logs",operation="select",le="0.1"} 1`,
+		`solr_client_request_duration_seconds_bucket{collection="logs",operation="select",le="1"} 2`,
+		`solr_client_request_duration_seconds_bucket{collection="logs",operation="select",le="+Inf"} 3`)
+	assertLines(t, output, `solr_client_request_duration_seconds_bucket{collection="logs",operation="update"`,
+		`solr_client_request_duration_seconds_bucket{collection="logs",operation="update",le="0.1"} 1`,
+		`solr_client_request_duration_seconds_bucket{collection="logs",operation="update",le="1"} 1`,
+		`solr_client_request_duration_seconds_bucket{collection="logs",operation="update",le="+Inf"} 1`)
+	assertLines(t, output, `solr_client_request_duration_seconds_sum{collection="logs",operation="select"}`,
+		`solr_client_request_duration_seconds_sum{collection="logs",operation="select"} 2.55`)
+	assertLines(t, output, `solr_client_request_duration_seconds_count{collection="logs"`,
+		`solr_client_request_duration_seconds_count{collection="logs",operation="select"} 3`,
+		`solr_client_request_duration_seconds_count{collection="logs",operation="update"} 1`)
+	assertLines(t, output, "solr_client_errors_total{",
+		`solr_client_errors_total{collection="logs",operation="select",http_status="other",solr_code=""} 1`,
+		`solr_client_errors_total{collection="logs",operation="update",http_status="409",solr_code="409"} 1`)
+	assertLines(t, output, `solr_client_sent_bytes_total{collection="logs",operation="update"}`,
+		`solr_client_sent_bytes_total{collection="logs",operation="update"} 150`)
+	assertLines(t, output, `solr_client_received_bytes_total{collection="logs",operation="update"}`,
+		`solr_client_received_bytes_total{collection="logs",operation="update"} 50`)
+	assertLines(t, output, `solr_client_retries_total{collection="logs",operation="select"}`,
+		`solr_client_retries_total{collection="logs",operation="select"} 1`)
+	assertLines(t, output, "solr_client_node_up{",
+		`solr_client_node_up{node="http://solr1:8983"} 1`,
+		`solr_client_node_up{node="http://solr2:8983"} 0`)
+}
+
+func TestPrometheusMetricsServeHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "fail" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"responseHeader":{"status":400},"error":{"msg":"undefined field","code":400}}`))
+			return
+		}
+		w.Write([]byte(`{"responseHeader":{"status":0},"response":{"numFound":0,"start":0,"docs":[]}}`))
+	}))
+	t.Cleanup(server.Close)
+	deadServer := httptest.NewServer(http.NotFoundHandler())
+	deadUrl := deadServer.URL
+	deadServer.Close()
+
+	metrics := NewPrometheusMetrics()
+	solrClient, err := NewSolrClient(&SolrConfig{Urls: []string{deadUrl, server.URL}, Collection: "test"}, WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+	for i := 0; i < 3; i++ {
+		if _, _, err := solrClient.Query(CreateSolrQuery()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	failing := CreateSolrQuery()
+	failing.Query("fail")
+	if _, _, err := solrClient.Query(failing); err == nil {
+		t.Fatalf("expected error for a bad request")
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type: %s", contentType)
+	}
+	output := recorder.Body.String()
+	assertLines(t, output, "solr_client_requests_total{",
+		`solr_client_requests_total{collection="test",operation="select",result="success"} 3`,
+		`solr_client_requests_total{collection="test",operation="select",result="error"} 1`)
+	assertLines(t, output, `solr_client_request_duration_seconds_bucket{collection="test",operation="select",le="+Inf"}`,
+		`solr_client_request_duration_seconds_bucket{collection="test",operation="select",le="+Inf"} 4`)
+	assertLines(t, output, `solr_client_request_duration_seconds_count{`,
+		`solr_client_request_duration_seconds_count{collection="test",operation="select"} 4`)
+	assertLines(t, output, "solr_client_errors_total{",
+		`solr_client_errors_total{collection="test",operation="select",http_status="400",solr_code="400"} 1`)
+	assertLines(t, output, `solr_client_node_up{node="`+deadUrl+`"}`, `solr_client_node_up{node="`+deadUrl+`"} 0`)
+	assertLines(t, output, `solr_client_node_up{node="`+server.URL+`"}`, `solr_client_node_up{node="`+server.URL+`"} 1`)
+}
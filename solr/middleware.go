@@ -0,0 +1,115 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// RequestIDHeader is the default header of the request id middleware
+const RequestIDHeader = "X-Request-ID"
+
+// RoundTripFunc sends a Solr HTTP request and returns its response
+type RoundTripFunc func(request *http.Request) (*http.Response, error)
+
+// Middleware wraps the next round trip of the chain, it can modify the request, the response or both (e.g. add headers, log, retry)
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// WithMiddleware add middlewares to the request chain of the Solr client, the first one is called first,
+// middlewares run after the built-in authentication (so they see the final request), unless WithAuthMiddleware places it later
+func WithMiddleware(middlewares ...Middleware) ClientOption {
+	return func(solrClient *SolrClient) {
+		solrClient.middlewares = append(solrClient.middlewares, middlewares...)
+	}
+}
+
+// WithAuthMiddleware place the built-in authentication at this point of the request chain: it runs after the middlewares
+// added by the preceding WithMiddleware options and before the following ones, e.g. to sign requests after a header middleware
+func WithAuthMiddleware() ClientOption {
+	return func(solrClient *SolrClient) {
+		solrClient.authMiddlewareIndex = len(solrClient.middlewares)
+	}
+}
+
+// AuthMiddleware add authentication details to the requests with the authenticator
+func AuthMiddleware(authenticator Authenticator) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			if err := authenticator.Authenticate(request.Context(), request); err != nil {
+				return nil, err
+			}
+			return next(request)
+		}
+	}
+}
+
+// HeaderMiddleware set static headers on every request
+func HeaderMiddleware(headers http.Header) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			for name, values := range headers {
+				request.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+			}
+			return next(request)
+		}
+	}
+}
+
+// RequestIDMiddleware set a unique request id header (X-Request-ID) on every request that does not have one yet
+func RequestIDMiddleware() Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			if len(request.Header.Get(RequestIDHeader)) == 0 {
+				request.Header.Set(RequestIDHeader, uuid.NewV4().String())
+			}
+			return next(request)
+		}
+	}
+}
+
+// LoggingMiddleware log method, url, status and duration of every request (debug level), and failed round trips (warn level)
+func LoggingMiddleware(logger Logger) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			start := time.Now()
+			response, err := next(request)
+			if err != nil {
+				logger.Warn("Solr request failed", LogField("method", request.Method), LogField("url", request.URL.String()),
+					LogField("duration", time.Since(start)), LogField("error", err))
+				return response, err
+			}
+			logger.Debug("Solr request", LogField("method", request.Method), LogField("url", request.URL.String()),
+				LogField("status", response.StatusCode), LogField("duration", time.Since(start)))
+			return response, err
+		}
+	}
+}
+
+// buildRoundTrip compose the request chain: the user middlewares with the authentication (first by default), then the HTTP client
+func (solrClient *SolrClient) buildRoundTrip() RoundTripFunc {
+	roundTrip := RoundTripFunc(solrClient.httpClient.Do)
+	for i := len(solrClient.middlewares); i >= 0; i-- {
+		if i < len(solrClient.middlewares) {
+			roundTrip = solrClient.middlewares[i](roundTrip)
+		}
+		if i == solrClient.authMiddlewareIndex && solrClient.authenticator != nil {
+			roundTrip = AuthMiddleware(solrClient.authenticator)(roundTrip)
+		}
+	}
+	return roundTrip
+}
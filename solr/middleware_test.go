@@ -0,0 +1,212 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// chainRecorder records the order of the middlewares and the authentication in the request chain
+type chainRecorder struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (recorder *chainRecorder) record(call string) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.calls = append(recorder.calls, call)
+}
+
+func (recorder *chainRecorder) recorded() []string {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]string(nil), recorder.calls...)
+}
+
+// middleware records its name and whether the request is authenticated at that point of the chain
+func (recorder *chainRecorder) middleware(name string) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			call := name
+			if len(request.Header.Get("Authorization")) != 0 {
+				call += " (authenticated)"
+			}
+			recorder.record(call)
+			return next(request)
+		}
+	}
+}
+
+func (recorder *chainRecorder) Authenticate(ctx context.Context, request *http.Request) error {
+	recorder.record("auth")
+	request.Header.Set("Authorization", "Bearer token")
+	return nil
+}
+
+func newMiddlewareTestServer(t *testing.T, headers chan<- http.Header) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if headers != nil {
+			headers <- r.Header.Clone()
+		}
+		w.Write([]byte(`{"responseHeader":{"status":0},"response":{"numFound":0,"start":0,"docs":[]}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newMiddlewareTestClient(t *testing.T, url string, options ...ClientOption) *SolrClient {
+	solrClient, err := NewSolrClient(&SolrConfig{Url: url, Collection: "test"}, options...)
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+	return solrClient
+}
+
+func TestMiddlewareChainOrder(t *testing.T) {
+	server := newMiddlewareTestServer(t, nil)
+	testCases := []struct {
+		name     string
+		options  func(recorder *chainRecorder) []ClientOption
+		expected []string
+	}{
+		{"authentication first by default", func(recorder *chainRecorder) []ClientOption {
+			return []ClientOption{WithMiddleware(recorder.middleware("first"), recorder.middleware("second")),
+				WithMiddleware(recorder.middleware("third")), WithAuthenticator(recorder)}
+		}, []string{"auth", "first (authenticated)", "second (authenticated)", "third (authenticated)"}},
+		{"authentication between middlewares", func(recorder *chainRecorder) []ClientOption {
+			return []ClientOption{WithAuthenticator(recorder), WithMiddleware(recorder.middleware("first")), WithAuthMiddleware(),
+				WithMiddleware(recorder.middleware("second"))}
+		}, []string{"first", "auth", "second (authenticated)"}},
+		{"authentication last", func(recorder *chainRecorder) []ClientOption {
+			return []ClientOption{WithMiddleware(recorder.middleware("first"), recorder.middleware("second")), WithAuthMiddleware(),
+				WithAuthenticator(recorder)}
+		}, []string{"first", "second", "auth"}},
+		{"without authenticator", func(recorder *chainRecorder) []ClientOption {
+			return []ClientOption{WithMiddleware(recorder.middleware("first")), WithAuthMiddleware(),
+				WithMiddleware(recorder.middleware("second"))}
+		}, []string{"first", "second"}},
+	}
+	for _, testCase := range testCases {
+		recorder := &chainRecorder{}
+		solrClient := newMiddlewareTestClient(t, server.URL, testCase.options(recorder)...)
+		if _, _, err := solrClient.Query(CreateSolrQuery()); err != nil {
+			t.Fatalf("%s: unexpected error: %v", testCase.name, err)
+		}
+		if calls := recorder.recorded(); !reflect.DeepEqual(calls, testCase.expected) {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.expected, calls)
+		}
+	}
+}
+
+func TestAuthMiddlewareError(t *testing.T) {
+	authErr := errors.New("no credentials")
+	called := false
+	next := func(request *http.Request) (*http.Response, error) {
+		called = true
+		return nil, nil
+	}
+	authenticator := authenticatorFunc(func(ctx context.Context, request *http.Request) error { return authErr })
+	request := httptest.NewRequest(http.MethodGet, "http://localhost:8983/solr", nil)
+	if _, err := AuthMiddleware(authenticator)(next)(request); err != authErr || called {
+		t.Fatalf("expected the authentication error without sending the request, got: %v, sent: %v", err, called)
+	}
+}
+
+type authenticatorFunc func(ctx context.Context, request *http.Request) error
+
+func (authenticate authenticatorFunc) Authenticate(ctx context.Context, request *http.Request) error {
+	return authenticate(ctx, request)
+}
+
+func TestHeaderMiddleware(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := newMiddlewareTestServer(t, headers)
+	staticHeaders := http.Header{}
+	staticHeaders.Set("X-Tenant", "tenant1")
+	staticHeaders["x-multi"] = []string{"a", "b"}
+	solrClient := newMiddlewareTestClient(t, server.URL, WithMiddleware(HeaderMiddleware(staticHeaders)))
+	if _, _, err := solrClient.Query(CreateSolrQuery()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	received := <-headers
+	if received.Get("X-Tenant") != "tenant1" || !reflect.DeepEqual(received["X-Multi"], []string{"a", "b"}) {
+		t.Fatalf("unexpected headers: %v", received)
+	}
+
+	// the static headers are copied, so modifying the request does not change them
+	request := httptest.NewRequest(http.MethodGet, "http://localhost:8983/solr", nil)
+	HeaderMiddleware(staticHeaders)(func(request *http.Request) (*http.Response, error) {
+		request.Header.Add("X-Multi", "c")
+		return nil, nil
+	})(request)
+	if !reflect.DeepEqual(staticHeaders["x-multi"], []string{"a", "b"}) {
+		t.Fatalf("static headers are modified: %v", staticHeaders)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var requestIds []string
+	next := func(request *http.Request) (*http.Response, error) {
+		requestIds = append(requestIds, request.Header.Get(RequestIDHeader))
+		return nil, nil
+	}
+	roundTrip := RequestIDMiddleware()(next)
+	for i := 0; i < 2; i++ {
+		roundTrip(httptest.NewRequest(http.MethodGet, "http://localhost:8983/solr", nil))
+	}
+	request := httptest.NewRequest(http.MethodGet, "http://localhost:8983/solr", nil)
+	request.Header.Set(RequestIDHeader, "my-request")
+	roundTrip(request)
+	if len(requestIds) != 3 || len(requestIds[0]) != 36 || len(requestIds[1]) != 36 || requestIds[0] == requestIds[1] {
+		t.Fatalf("expected unique generated request ids: %v", requestIds)
+	}
+	if requestIds[2] != "my-request" {
+		t.Fatalf("the existing request id should be kept: %v", requestIds)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	logger := &recordingLogger{}
+	request := httptest.NewRequest(http.MethodGet, "http://localhost:8983/solr/test/select", nil)
+	LoggingMiddleware(logger)(func(request *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})(request)
+	roundTripErr := errors.New("connection refused")
+	if _, err := LoggingMiddleware(logger)(func(request *http.Request) (*http.Response, error) {
+		return nil, roundTripErr
+	})(request); err != roundTripErr {
+		t.Fatalf("expected the round trip error, got: %v", err)
+	}
+	messages := logger.recorded()
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got: %v", messages)
+	}
+	if !strings.HasPrefix(messages[0], "DEBUG Solr request method=GET url=http://localhost:8983/solr/test/select status=200 duration=") {
+		t.Errorf("unexpected debug message: %s", messages[0])
+	}
+	if !strings.HasPrefix(messages[1], "WARN Solr request failed method=GET url=http://localhost:8983/solr/test/select duration=") ||
+		!strings.HasSuffix(messages[1], " error=connection refused") {
+		t.Errorf("unexpected warn message: %s", messages[1])
+	}
+}
@@ -37,6 +37,20 @@ func (q *SolrQuery) SetParam(key, value string) {
 	q.params.Set(key, value)
 }
 
+// GetParam returns the first value of a query parameter (empty string if it is not set)
+func (q *SolrQuery) GetParam(key string) string {
+	return q.params.Get(key)
+}
+
+// Clone returns a copy of the Solr query, so it can be modified without changing the original one
+func (q *SolrQuery) Clone() *SolrQuery {
+	params := url.Values{}
+	for key, values := range *q.params {
+		params[key] = append([]string(nil), values...)
+	}
+	return &SolrQuery{params: &params}
+}
+
 // Encode transform Solr query parameters to string
 func (q *SolrQuery) Encode() string {
 	return q.params.Encode()
@@ -75,7 +89,7 @@ func (q *SolrQuery) AddFields(fields []string) {
 func (q *SolrQuery) AddPivotFields(pivotFields []string) {
 	q.SetParam("facet", "true")
 	if len(pivotFields) > 0 {
-		q.AddParam("facet.pivot.field", strings.Join(pivotFields, ","))
+		q.AddParam("facet.pivot", strings.Join(pivotFields, ","))
 	}
 }
 
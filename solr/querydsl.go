@@ -0,0 +1,371 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// queryEscapeChars are the special characters of the Lucene query syntax
+const queryEscapeChars = `\+-&|!(){}[]^"~*?:/`
+
+// matchNoneQuery matches no document, it is rendered for empty boolean queries and missing (nil) clauses,
+// so e.g. a filter built from an empty list of user values does not widen into a match-all filter
+const matchNoneQuery = "(*:* -*:*)"
+
+// QueryExpr is a node of a query expression tree, String renders it as escaped Lucene/edismax syntax,
+// so user input can be used in the values without breaking (or injecting into) the query; the queries fail closed:
+// empty boolean queries, queries with a nil clause and invalid local params queries match no document
+type QueryExpr interface {
+	String() string
+}
+
+// TermQuery matches a single term of a field (or of the default field, if the field is empty)
+type TermQuery struct {
+	Field string
+	Value string
+}
+
+// PhraseQuery matches a phrase of a field, with optional slop (proximity)
+type PhraseQuery struct {
+	Field string
+	Text  string
+	Slop  int
+}
+
+// RangeQuery matches a range of a field, empty bounds are open (*)
+type RangeQuery struct {
+	Field       string
+	From        string
+	To          string
+	IncludeFrom bool
+	IncludeTo   bool
+}
+
+// WildcardQuery matches a pattern of a field, * and ? are kept as wildcards, every other special character is escaped
+type WildcardQuery struct {
+	Field   string
+	Pattern string
+}
+
+// BooleanQuery combines clauses with AND or OR, nil clauses match no document (they are skipped in OR queries)
+type BooleanQuery struct {
+	Operator string
+	Clauses  []QueryExpr
+}
+
+// NotQuery matches every document that does not match the clause
+type NotQuery struct {
+	Clause QueryExpr
+}
+
+// BoostQuery boosts the score of a clause
+type BoostQuery struct {
+	Clause QueryExpr
+	Boost  float64
+}
+
+// FieldGroupQuery applies a field to every clause of a group, e.g. title:(foo OR "bar baz")
+type FieldGroupQuery struct {
+	Field  string
+	Clause QueryExpr
+}
+
+// LocalParamsQuery is a query with local params ({!parser key=value v=...}), the values are quoted, the parser
+// and the keys should be identifiers (letters, digits, underscores and dots, see Validate)
+type LocalParamsQuery struct {
+	Parser string
+	Params [][2]string
+	Clause QueryExpr
+}
+
+// RawQuery is an unescaped query string, it should not contain user input
+type RawQuery string
+
+// Term create a term query, the value is escaped
+func Term(field string, value string) *TermQuery {
+	return &TermQuery{Field: field, Value: value}
+}
+
+// Phrase create a phrase query
+func Phrase(field string, text string) *PhraseQuery {
+	return &PhraseQuery{Field: field, Text: text}
+}
+
+// Range create an inclusive range query ([from TO to]), empty bounds are open
+func Range(field string, from string, to string) *RangeQuery {
+	return &RangeQuery{Field: field, From: from, To: to, IncludeFrom: true, IncludeTo: true}
+}
+
+// Wildcard create a wildcard query (e.g. "host-1*")
+func Wildcard(field string, pattern string) *WildcardQuery {
+	return &WildcardQuery{Field: field, Pattern: pattern}
+}
+
+// And create a query that matches documents that match every clause
+func And(clauses ...QueryExpr) *BooleanQuery {
+	return &BooleanQuery{Operator: "AND", Clauses: clauses}
+}
+
+// Or create a query that matches documents that match any of the clauses
+func Or(clauses ...QueryExpr) *BooleanQuery {
+	return &BooleanQuery{Operator: "OR", Clauses: clauses}
+}
+
+// Not create a query that matches documents that do not match the clause
+func Not(clause QueryExpr) *NotQuery {
+	return &NotQuery{Clause: clause}
+}
+
+// Boost create a boosted clause (clause^boost)
+func Boost(clause QueryExpr, boost float64) *BoostQuery {
+	return &BoostQuery{Clause: clause, Boost: boost}
+}
+
+// FieldGroup apply the field to a group of clauses (the clauses should not have fields)
+func FieldGroup(field string, clause QueryExpr) *FieldGroupQuery {
+	return &FieldGroupQuery{Field: field, Clause: clause}
+}
+
+// LocalParams create a query with local params for a query parser (e.g. terms, edismax, collapse)
+func LocalParams(parser string) *LocalParamsQuery {
+	return &LocalParamsQuery{Parser: parser}
+}
+
+// Raw create an unescaped query, it should not contain user input
+func Raw(query string) RawQuery {
+	return RawQuery(query)
+}
+
+// MatchAll create a query that matches every document (*:*)
+func MatchAll() RawQuery {
+	return RawQuery("*:*")
+}
+
+// MatchNone create a query that matches no document
+func MatchNone() RawQuery {
+	return RawQuery(matchNoneQuery)
+}
+
+// QueryExpr sets the query (q) from a query expression
+func (q *SolrQuery) QueryExpr(expr QueryExpr) {
+	q.SetParam("q", expr.String())
+}
+
+// FilterQueryExpr add a filter query (fq) from a query expression
+func (q *SolrQuery) FilterQueryExpr(expr QueryExpr) {
+	q.AddParam("fq", expr.String())
+}
+
+// EscapeQueryValue escape the special characters and whitespaces of a term for the Lucene query syntax
+func EscapeQueryValue(value string) string {
+	return escapeQuery(value, "")
+}
+
+// ExcludeFrom make the lower bound of the range exclusive
+func (query *RangeQuery) ExcludeFrom() *RangeQuery {
+	query.IncludeFrom = false
+	return query
+}
+
+// ExcludeTo make the upper bound of the range exclusive
+func (query *RangeQuery) ExcludeTo() *RangeQuery {
+	query.IncludeTo = false
+	return query
+}
+
+// WithSlop sets the slop of the phrase (maximum distance of the terms)
+func (query *PhraseQuery) WithSlop(slop int) *PhraseQuery {
+	query.Slop = slop
+	return query
+}
+
+// Param add a local param (the value is quoted)
+func (query *LocalParamsQuery) Param(key string, value string) *LocalParamsQuery {
+	query.Params = append(query.Params, [2]string{key, value})
+	return query
+}
+
+// Query sets the query of the local params query (rendered as the v local param)
+func (query *LocalParamsQuery) Query(clause QueryExpr) *LocalParamsQuery {
+	query.Clause = clause
+	return query
+}
+
+// Validate returns an error if the parser or a param key is not an identifier (such queries are rendered as MatchNone)
+func (query *LocalParamsQuery) Validate() error {
+	if len(query.Parser) != 0 && !isLocalParamName(query.Parser) {
+		return fmt.Errorf("invalid local params query parser: %q", query.Parser)
+	}
+	for _, param := range query.Params {
+		if !isLocalParamName(param[0]) {
+			return fmt.Errorf("invalid local param key: %q", param[0])
+		}
+	}
+	return nil
+}
+
+func (query *TermQuery) String() string {
+	switch strings.ToUpper(query.Value) {
+	case "":
+		return withField(query.Field, `""`)
+	case "AND", "OR", "NOT":
+		// operators are quoted (lowercase as well, edismax can treat them as operators)
+		return withField(query.Field, quotePhrase(query.Value))
+	}
+	return withField(query.Field, EscapeQueryValue(query.Value))
+}
+
+func (query *PhraseQuery) String() string {
+	phrase := quotePhrase(query.Text)
+	if query.Slop > 0 {
+		phrase = fmt.Sprintf("%s~%d", phrase, query.Slop)
+	}
+	return withField(query.Field, phrase)
+}
+
+func (query *RangeQuery) String() string {
+	lower, upper := "{", "}"
+	if query.IncludeFrom {
+		lower = "["
+	}
+	if query.IncludeTo {
+		upper = "]"
+	}
+	return withField(query.Field, fmt.Sprintf("%s%s TO %s%s", lower, rangeBound(query.From), rangeBound(query.To), upper))
+}
+
+func (query *WildcardQuery) String() string {
+	return withField(query.Field, escapeQuery(query.Pattern, "*?"))
+}
+
+func (query *BooleanQuery) String() string {
+	clauses := make([]string, 0, len(query.Clauses))
+	for _, clause := range query.Clauses {
+		if clause != nil {
+			clauses = append(clauses, clause.String())
+		} else if query.Operator != "OR" {
+			return matchNoneQuery
+		}
+	}
+	switch len(clauses) {
+	case 0:
+		return matchNoneQuery
+	case 1:
+		return clauses[0]
+	}
+	return "(" + strings.Join(clauses, " "+query.Operator+" ") + ")"
+}
+
+func (query *NotQuery) String() string {
+	if query.Clause == nil {
+		return matchNoneQuery
+	}
+	// a purely negative clause does not match anything on its own, so every document is matched first
+	return "(*:* -" + query.Clause.String() + ")"
+}
+
+func (query *BoostQuery) String() string {
+	if query.Clause == nil {
+		return matchNoneQuery
+	}
+	return query.Clause.String() + "^" + strconv.FormatFloat(query.Boost, 'f', -1, 64)
+}
+
+func (query *FieldGroupQuery) String() string {
+	if query.Clause == nil {
+		return matchNoneQuery
+	}
+	clause := query.Clause.String()
+	if !strings.HasPrefix(clause, "(") || !strings.HasSuffix(clause, ")") {
+		clause = "(" + clause + ")"
+	}
+	return withField(query.Field, clause)
+}
+
+func (query *LocalParamsQuery) String() string {
+	if query.Validate() != nil {
+		return matchNoneQuery
+	}
+	var builder strings.Builder
+	builder.WriteString("{!")
+	builder.WriteString(query.Parser)
+	for _, param := range query.Params {
+		builder.WriteString(" ")
+		builder.WriteString(param[0])
+		builder.WriteString("=")
+		builder.WriteString(quoteLocalParam(param[1]))
+	}
+	if query.Clause != nil {
+		builder.WriteString(" v=")
+		builder.WriteString(quoteLocalParam(query.Clause.String()))
+	}
+	builder.WriteString("}")
+	return builder.String()
+}
+
+func (query RawQuery) String() string {
+	return string(query)
+}
+
+func withField(field string, value string) string {
+	if len(field) == 0 {
+		return value
+	}
+	return EscapeQueryValue(field) + ":" + value
+}
+
+func rangeBound(bound string) string {
+	if len(bound) == 0 || bound == "*" {
+		return "*"
+	}
+	return EscapeQueryValue(bound)
+}
+
+// isLocalParamName returns true if the name is an identifier that can be used as a local params parser or key
+func isLocalParamName(name string) bool {
+	for i, char := range name {
+		switch {
+		case char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z'):
+		case i > 0 && (char == '.' || (char >= '0' && char <= '9')):
+		default:
+			return false
+		}
+	}
+	return len(name) != 0
+}
+
+func quotePhrase(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+}
+
+func quoteLocalParam(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// escapeQuery escape the special characters (except the kept ones) and whitespaces with backslash
+func escapeQuery(value string, keep string) string {
+	var builder strings.Builder
+	for _, char := range value {
+		if (strings.ContainsRune(queryEscapeChars, char) && !strings.ContainsRune(keep, char)) || unicode.IsSpace(char) {
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(char)
+	}
+	return builder.String()
+}
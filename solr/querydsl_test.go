@@ -0,0 +1,110 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"testing"
+)
+
+func TestEscapeSpecialCharacters(t *testing.T) {
+	for _, char := range queryEscapeChars {
+		value := "a" + string(char) + "b"
+		expected := `host:a\` + string(char) + "b"
+		if query := Term("host", value).String(); query != expected {
+			t.Errorf("Term(%q) = %s, expected: %s", value, query, expected)
+		}
+	}
+}
+
+func TestQueryExprString(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     QueryExpr
+		expected string
+	}{
+		{"term", Term("host", "host1"), "host:host1"},
+		{"term without field", Term("", "host1"), "host1"},
+		{"empty term", Term("host", ""), `host:""`},
+		{"space", Term("msg", "connection refused"), `msg:connection\ refused`},
+		{"tab and newline", Term("msg", "a\tb\nc"), "msg:a\\\tb\\\nc"},
+		{"unicode space", Term("msg", "a\u3000b"), "msg:a\\\u3000b"},
+		{"escaped field", Term("attr:x", "1"), `attr\:x:1`},
+		{"injection", Term("host", "x OR *:*"), `host:x\ OR\ \*\:\*`},
+		{"AND term", Term("level", "AND"), `level:"AND"`},
+		{"OR term", Term("level", "OR"), `level:"OR"`},
+		{"NOT term", Term("level", "NOT"), `level:"NOT"`},
+		{"lowercase operator term", Term("level", "or"), `level:"or"`},
+		{"operator term without field", Term("", "NOT"), `"NOT"`},
+		{"phrase", Phrase("msg", `say "hi" \o/`), `msg:"say \"hi\" \\o/"`},
+		{"phrase with slop", Phrase("msg", "a b").WithSlop(2), `msg:"a b"~2`},
+		{"range", Range("seq", "1", "10"), "seq:[1 TO 10]"},
+		{"open range", Range("logtime", "NOW-1DAY", ""), `logtime:[NOW\-1DAY TO *]`},
+		{"exclusive range", Range("seq", "*", "10").ExcludeFrom().ExcludeTo(), "seq:{* TO 10}"},
+		{"range injection", Range("seq", "1 TO 2] OR x:[1", "3"), `seq:[1\ TO\ 2\]\ OR\ x\:\[1 TO 3]`},
+		{"wildcard", Wildcard("host", "web-1* ?x"), `host:web\-1*\ ?x`},
+		{"and", And(Term("a", "1"), Term("b", "2")), "(a:1 AND b:2)"},
+		{"or", Or(Term("a", "1"), Term("b", "2")), "(a:1 OR b:2)"},
+		{"single clause", Or(Term("a", "1")), "a:1"},
+		{"empty or", Or(), matchNoneQuery},
+		{"empty and", And(), matchNoneQuery},
+		{"or with nil clause", Or(nil, Term("a", "1")), "a:1"},
+		{"or with nil clauses only", Or(nil, nil), matchNoneQuery},
+		{"and with nil clause", And(Term("a", "1"), nil), matchNoneQuery},
+		{"not", Not(Term("level", "DEBUG")), "(*:* -level:DEBUG)"},
+		{"not nil", Not(nil), matchNoneQuery},
+		{"boost", Boost(Term("type", "x"), 2.5), "type:x^2.5"},
+		{"boost nil", Boost(nil, 2), matchNoneQuery},
+		{"field group", FieldGroup("title", Or(Term("", "foo"), Phrase("", "bar baz"))), `title:(foo OR "bar baz")`},
+		{"field group single", FieldGroup("title", Term("", "foo")), "title:(foo)"},
+		{"field group nil", FieldGroup("title", nil), matchNoneQuery},
+		{"field group of empty or", FieldGroup("title", Or()), "title:" + matchNoneQuery},
+		{"local params", LocalParams("terms").Param("f", "host"), "{!terms f='host'}"},
+		{"local params quoting", LocalParams("terms").Param("f", `it's a \ test}`), `{!terms f='it\'s a \\ test}'}`},
+		{"local params query", LocalParams("edismax").Param("qf", "title^2 body").Query(Term("", "x' v='y")),
+			`{!edismax qf='title^2 body' v='x\'\\ v=\'y'}`},
+		{"local params without parser", LocalParams("").Param("tag", "dt"), "{! tag='dt'}"},
+		{"local params dotted key", LocalParams("lucene").Param("q.op", "AND"), "{!lucene q.op='AND'}"},
+		{"invalid parser", LocalParams("terms f=x}"), matchNoneQuery},
+		{"invalid key", LocalParams("terms").Param("f=host} OR {!x", "y"), matchNoneQuery},
+		{"empty key", LocalParams("terms").Param("", "y"), matchNoneQuery},
+		{"match all", MatchAll(), "*:*"},
+		{"match none", MatchNone(), matchNoneQuery},
+	}
+	for _, test := range tests {
+		if query := test.expr.String(); query != test.expected {
+			t.Errorf("%s: got %s, expected: %s", test.name, query, test.expected)
+		}
+	}
+}
+
+func TestLocalParamsValidate(t *testing.T) {
+	if err := LocalParams("collapse").Param("field", "host").Param("nullPolicy", "expand").Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, query := range []*LocalParamsQuery{LocalParams("1terms"), LocalParams("te rms"), LocalParams("x").Param("a'", "b"), LocalParams("x").Param(".a", "b")} {
+		if err := query.Validate(); err == nil {
+			t.Errorf("%+v should be invalid", query)
+		}
+	}
+}
+
+func TestFilterQueryExprFromEmptyList(t *testing.T) {
+	var hostTerms []QueryExpr
+	q := CreateSolrQuery()
+	q.FilterQueryExpr(Or(hostTerms...))
+	if fq := q.GetParam("fq"); fq != matchNoneQuery {
+		t.Fatalf("a filter from an empty list should match no document, got: %s", fq)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Operation represents the kind of a Solr call
+type Operation string
+
+const (
+	// OperationSelect is used for queries (select handler)
+	OperationSelect Operation = "select"
+	// OperationUpdate is used for updates (update handler)
+	OperationUpdate Operation = "update"
+	// OperationAdmin is used for admin calls (e.g. Collections API)
+	OperationAdmin Operation = "admin"
+	// OperationExport is used for streaming exports (export handler)
+	OperationExport Operation = "export"
+)
+
+// RetryPolicy defines how failed Solr calls are retried: connection errors and retryable HTTP statuses are retried with exponential backoff,
+// only if the operation is idempotent (queries and admin reads by default), that can be overridden per operation
+type RetryPolicy struct {
+	MaxAttempts          int
+	InitialBackoff       time.Duration
+	MaxBackoff           time.Duration
+	Multiplier           float64
+	Jitter               float64
+	RetryableStatusCodes []int
+	IdempotentOperations map[Operation]bool
+}
+
+// RetryError is returned if a Solr call failed after multiple attempts, it wraps the error of the last attempt
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+// Error returns the error of the last attempt with the number of attempts
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v (attempts: %d)", e.Err, e.Attempts)
+}
+
+// Unwrap returns the error of the last attempt
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultRetryPolicy create a retry policy with 3 attempts, exponential backoff from 100ms to 5s with 20% jitter,
+// that retries connection errors and 429, 502, 503, 504 responses
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       100 * time.Millisecond,
+		MaxBackoff:           5 * time.Second,
+		Multiplier:           2,
+		Jitter:               0.2,
+		RetryableStatusCodes: []int{429, 502, 503, 504},
+	}
+}
+
+// Attempts returns the number of attempts of a failed Solr call
+func Attempts(err error) int {
+	var retryError *RetryError
+	if errors.As(err, &retryError) {
+		return retryError.Attempts
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// isRetryable returns true if the error is transient according to the policy (connection error or retryable HTTP status)
+func (policy *RetryPolicy) isRetryable(err error) bool {
+	var connErr *connectionError
+	if errors.As(err, &connErr) {
+		return true
+	}
+	solrError := AsSolrError(err)
+	if solrError == nil {
+		return false
+	}
+	for _, statusCode := range policy.RetryableStatusCodes {
+		if solrError.HTTPStatus == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait time before the next attempt (attempt starts from 1)
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(policy.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if policy.MaxBackoff > 0 && backoff > float64(policy.MaxBackoff) {
+		backoff = float64(policy.MaxBackoff)
+	}
+	if policy.Jitter > 0 {
+		delta := backoff * math.Min(policy.Jitter, 1)
+		backoff = backoff - delta + rand.Float64()*2*delta
+	}
+	return time.Duration(backoff)
+}
+
+// isIdempotent returns true if the request can be sent again safely
+func (solrClient *SolrClient) isIdempotent(solrRequest *solrRequest) bool {
+	policy := solrClient.solrConfig.RetryPolicy
+	if policy != nil {
+		if idempotent, ok := policy.IdempotentOperations[solrRequest.operation]; ok {
+			return idempotent
+		}
+	}
+	return solrRequest.idempotent
+}
+
+// sleepContext wait for the duration, returns the context error if the context is done earlier
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
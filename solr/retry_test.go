@@ -0,0 +1,185 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyIsRetryable(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	testCases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"connection error", &connectionError{err: errors.New("connection refused")}, true},
+		{"wrapped connection error", fmt.Errorf("update failed: %w", &connectionError{err: errors.New("EOF")}), true},
+		{"too many requests", &SolrError{HTTPStatus: http.StatusTooManyRequests}, true},
+		{"bad gateway", &SolrError{HTTPStatus: http.StatusBadGateway}, true},
+		{"service unavailable", &SolrError{HTTPStatus: http.StatusServiceUnavailable}, true},
+		{"gateway timeout", &SolrError{HTTPStatus: http.StatusGatewayTimeout}, true},
+		{"internal server error", &SolrError{HTTPStatus: http.StatusInternalServerError}, false},
+		{"bad request", &SolrError{HTTPStatus: http.StatusBadRequest, Msg: "undefined field"}, false},
+		{"response header status", &SolrError{HTTPStatus: http.StatusOK, Code: 500}, false},
+		{"context canceled", context.Canceled, false},
+		{"other error", errors.New("cannot encode documents"), false},
+		{"nil error", nil, false},
+	}
+	for _, testCase := range testCases {
+		if retryable := policy.isRetryable(testCase.err); retryable != testCase.retryable {
+			t.Errorf("%s: expected retryable %v, got %v", testCase.name, testCase.retryable, retryable)
+		}
+	}
+	custom := &RetryPolicy{RetryableStatusCodes: []int{http.StatusInternalServerError}}
+	if !custom.isRetryable(&SolrError{HTTPStatus: http.StatusInternalServerError}) || custom.isRetryable(&SolrError{HTTPStatus: http.StatusServiceUnavailable}) {
+		t.Errorf("the retryable status codes of the policy should be used")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   RetryPolicy
+		expected []time.Duration
+	}{
+		{"exponential", RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second, Multiplier: 2},
+			[]time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond}},
+		{"capped by max backoff", RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, Multiplier: 2},
+			[]time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second, 3 * time.Second}},
+		{"no max backoff", RetryPolicy{InitialBackoff: time.Second, Multiplier: 10},
+			[]time.Duration{time.Second, 10 * time.Second, 100 * time.Second}},
+		{"multiplier below 1 is constant", RetryPolicy{InitialBackoff: time.Second, Multiplier: 0.5},
+			[]time.Duration{time.Second, time.Second, time.Second}},
+	}
+	for _, testCase := range testCases {
+		for i, expected := range testCase.expected {
+			if backoff := testCase.policy.backoff(i + 1); backoff != expected {
+				t.Errorf("%s: attempt %d: expected %v, got %v", testCase.name, i+1, expected, backoff)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyBackoffJitter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   RetryPolicy
+		attempt  int
+		min, max time.Duration
+	}{
+		{"20% jitter", RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 2, Jitter: 0.2}, 2,
+			1600 * time.Millisecond, 2400 * time.Millisecond},
+		{"jitter around max backoff", RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 2 * time.Second, Multiplier: 2, Jitter: 0.5}, 5,
+			time.Second, 3 * time.Second},
+		{"jitter above 1 is capped", RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, Jitter: 5}, 1,
+			0, 2 * time.Second},
+	}
+	for _, testCase := range testCases {
+		distinct := make(map[time.Duration]bool)
+		for i := 0; i < 1000; i++ {
+			backoff := testCase.policy.backoff(testCase.attempt)
+			if backoff < testCase.min || backoff > testCase.max {
+				t.Fatalf("%s: backoff %v is out of [%v, %v]", testCase.name, backoff, testCase.min, testCase.max)
+			}
+			distinct[backoff] = true
+		}
+		if len(distinct) < 100 {
+			t.Errorf("%s: backoff should be randomized, distinct values: %d", testCase.name, len(distinct))
+		}
+	}
+}
+
+func newRetryTestServer(t *testing.T, statusCode int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		fmt.Fprintf(w, `{"responseHeader":{"status":%d},"error":{"msg":"unavailable","code":%d}}`, statusCode, statusCode)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRetryIdempotentOperations(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		idempotentOperations map[Operation]bool
+		update               bool
+		expectedRequests     int32
+	}{
+		{"queries are retried", nil, false, 3},
+		{"updates are not retried", nil, true, 1},
+		{"updates are retried if they are idempotent", map[Operation]bool{OperationUpdate: true}, true, 3},
+		{"queries are not retried if they are not idempotent", map[Operation]bool{OperationSelect: false}, false, 1},
+	}
+	for _, testCase := range testCases {
+		server, requests := newRetryTestServer(t, http.StatusServiceUnavailable)
+		policy := DefaultRetryPolicy()
+		policy.InitialBackoff = time.Millisecond
+		policy.IdempotentOperations = testCase.idempotentOperations
+		solrClient, err := NewSolrClient(&SolrConfig{Url: server.URL, Collection: "test", RetryPolicy: policy})
+		if err != nil {
+			t.Fatalf("cannot create Solr client: %v", err)
+		}
+		t.Cleanup(solrClient.Close)
+		if testCase.update {
+			_, _, err = solrClient.Update([]SolrDocument{{"id": "1"}}, nil, false)
+		} else {
+			_, _, err = solrClient.Query(CreateSolrQuery())
+		}
+		if err == nil {
+			t.Fatalf("%s: expected error", testCase.name)
+		}
+		if actual := atomic.LoadInt32(requests); actual != testCase.expectedRequests {
+			t.Errorf("%s: expected %d requests, got %d", testCase.name, testCase.expectedRequests, actual)
+		}
+		if Attempts(err) != int(testCase.expectedRequests) {
+			t.Errorf("%s: expected %d attempts, got %d (%v)", testCase.name, testCase.expectedRequests, Attempts(err), err)
+		}
+		if solrError := AsSolrError(err); solrError == nil || solrError.HTTPStatus != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected the error of the last attempt, got: %v", testCase.name, err)
+		}
+	}
+}
+
+func TestGeneratorSolrConfig(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	policy.IdempotentOperations = map[Operation]bool{OperationSelect: false}
+	solrConfig := &SolrConfig{Url: "http://localhost:8983", Collection: "test", RetryPolicy: policy}
+	generatorConfig := generatorSolrConfig(solrConfig)
+	if solrConfig.RetryPolicy != policy || len(policy.IdempotentOperations) != 1 || policy.IdempotentOperations[OperationUpdate] {
+		t.Fatalf("the config of the caller should not be modified: %+v", solrConfig.RetryPolicy)
+	}
+	expected := map[Operation]bool{OperationUpdate: true, OperationSelect: false}
+	if generatorConfig.RetryPolicy == policy || !reflect.DeepEqual(generatorConfig.RetryPolicy.IdempotentOperations, expected) {
+		t.Fatalf("unexpected idempotent operations of the generator: %v", generatorConfig.RetryPolicy.IdempotentOperations)
+	}
+	if generatorConfig.Url != solrConfig.Url || generatorConfig.RetryPolicy.MaxAttempts != policy.MaxAttempts {
+		t.Fatalf("the other settings should be kept: %+v", generatorConfig)
+	}
+	if generatorSolrConfig(&SolrConfig{Collection: "test"}).RetryPolicy != nil {
+		t.Fatalf("retries should not be turned on by the generator")
+	}
+}
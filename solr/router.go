@@ -0,0 +1,410 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compositeIdRouter is the name of the default document router of SolrCloud collections
+const compositeIdRouter = "compositeId"
+
+// defaultUniqueKey is the default uniqueKey field of Solr schemas
+const defaultUniqueKey = "id"
+
+// HashRange represents the hash range of a shard (inclusive)
+type HashRange struct {
+	Min int32
+	Max int32
+}
+
+// ShardUpdateError is returned if the update of some shards failed during a routed update, the documents of the
+// succeeded shards are already sent, so a partial write can be told from a total failure
+type ShardUpdateError struct {
+	Succeeded []string
+	Failed    map[string]error
+}
+
+// shardBatch holds the documents of an update batch that belong to one shard
+type shardBatch struct {
+	shard  string
+	leader *ReplicaState
+	docs   []json.RawMessage
+}
+
+// body returns the documents of the batch as a JSON array
+func (batch *shardBatch) body() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, doc := range batch.docs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(doc)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// Murmur3Hash32 computes the MurmurHash3 (x86, 32 bit) hash of the data, as Solr does for document routing
+func Murmur3Hash32(data []byte, seed uint32) int32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	h1 := seed
+	length := len(data)
+	roundedEnd := length & ^0x3
+	for i := 0; i < roundedEnd; i += 4 {
+		k1 := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k1 *= c1
+		k1 = bits.RotateLeft32(k1, 15)
+		k1 *= c2
+		h1 ^= k1
+		h1 = bits.RotateLeft32(h1, 13)
+		h1 = h1*5 + 0xe6546b64
+	}
+	var k1 uint32
+	switch length & 0x03 {
+	case 3:
+		k1 = uint32(data[roundedEnd+2]) << 16
+		fallthrough
+	case 2:
+		k1 |= uint32(data[roundedEnd+1]) << 8
+		fallthrough
+	case 1:
+		k1 |= uint32(data[roundedEnd])
+		k1 *= c1
+		k1 = bits.RotateLeft32(k1, 15)
+		k1 *= c2
+		h1 ^= k1
+	}
+	h1 ^= uint32(length)
+	h1 ^= h1 >> 16
+	h1 *= 0x85ebca6b
+	h1 ^= h1 >> 13
+	h1 *= 0xc2b2ae35
+	h1 ^= h1 >> 16
+	return int32(h1)
+}
+
+// CompositeIdHash computes the hash of a document id like the compositeId router of Solr,
+// it supports shard keys (e.g.: tenant!id, app!user!id) and bit prefixes (e.g.: tenant/4!id)
+func CompositeIdHash(id string) int32 {
+	firstSeparator := strings.Index(id, "!")
+	if firstSeparator == -1 {
+		// ids without shard key are hashed as a whole
+		return Murmur3Hash32([]byte(id), 0)
+	}
+	parts := []string{id[:firstSeparator]}
+	lastPos := len(id) - 1
+	// no more parts if the first separator is the last character
+	if firstSeparator < lastPos {
+		secondSeparator := strings.Index(id[firstSeparator+1:], "!")
+		if secondSeparator != -1 {
+			secondSeparator += firstSeparator + 1
+		}
+		switch {
+		case secondSeparator == -1:
+			parts = append(parts, id[firstSeparator+1:])
+		case secondSeparator == lastPos:
+			// two separators as the last two characters (back compatibility with Solr versions before 4.9)
+			parts = append(parts, id[firstSeparator+1:secondSeparator])
+		default:
+			// further separators are ignored
+			parts = append(parts, id[firstSeparator+1:secondSeparator], id[secondSeparator+1:])
+		}
+	}
+	pieces := len(parts)
+	if strings.HasSuffix(id, "!") && pieces < 3 {
+		pieces++
+	}
+	triLevel := pieces == 3
+	numBits := []int{16, 0}
+	if triLevel {
+		numBits = []int{8, 8}
+	}
+	hashes := make([]int32, pieces)
+	for i := 0; i < pieces; i++ {
+		if i < pieces-1 {
+			if bitsSeparator := strings.Index(parts[i], "/"); bitsSeparator > 0 {
+				numBits[i] = compositeIdNumBits(parts[i][bitsSeparator+1:])
+				parts[i] = parts[i][:bitsSeparator]
+			}
+		}
+		if i >= len(parts) {
+			hashes[i] = Murmur3Hash32(nil, 0)
+		} else {
+			hashes[i] = Murmur3Hash32([]byte(parts[i]), 0)
+		}
+	}
+	var masks []int32
+	if triLevel {
+		masks = make([]int32, 3)
+		masks[0] = compositeIdMask(numBits[0])
+		masks[1] = compositeIdMask(numBits[0]+numBits[1]) ^ masks[0]
+		masks[2] = ^(masks[0] | masks[1])
+	} else {
+		masks = make([]int32, 2)
+		masks[0] = compositeIdMask(numBits[0])
+		masks[1] = ^masks[0]
+	}
+	result := hashes[0] & masks[0]
+	for i := 1; i < pieces; i++ {
+		result |= hashes[i] & masks[i]
+	}
+	return result
+}
+
+// compositeIdNumBits parse the bits of a shard key prefix, -1 is returned for invalid values (as Solr does)
+func compositeIdNumBits(value string) int {
+	bitCount := 0
+	for _, ch := range value {
+		if ch < '0' || ch > '9' {
+			return -1
+		}
+		bitCount = bitCount*10 + int(ch-'0')
+		if bitCount > 32 {
+			return -1
+		}
+	}
+	return bitCount
+}
+
+// compositeIdMask returns a mask with the given number of upper bits set (shift count is taken modulo 32, like in Java)
+func compositeIdMask(bitCount int) int32 {
+	if bitCount == 0 {
+		return 0
+	}
+	return int32(-1) << (uint(32-bitCount) & 31)
+}
+
+// ParseHashRange parse a shard hash range, e.g.: 80000000-ffffffff
+func ParseHashRange(hashRange string) (HashRange, error) {
+	separator := strings.Index(hashRange, "-")
+	if separator == -1 {
+		return HashRange{}, fmt.Errorf("invalid hash range: %s", hashRange)
+	}
+	min, err := strconv.ParseUint(hashRange[:separator], 16, 32)
+	if err != nil {
+		return HashRange{}, fmt.Errorf("invalid hash range: %s", hashRange)
+	}
+	max, err := strconv.ParseUint(hashRange[separator+1:], 16, 32)
+	if err != nil {
+		return HashRange{}, fmt.Errorf("invalid hash range: %s", hashRange)
+	}
+	return HashRange{Min: int32(min), Max: int32(max)}, nil
+}
+
+// Includes returns true if the hash is in the range
+func (hashRange HashRange) Includes(hash int32) bool {
+	return hashRange.Min <= hash && hash <= hashRange.Max
+}
+
+// ShardForRouteKey returns the name of the active shard of the collection that the route key (document id or router field value) belongs to
+func (collectionState *CollectionState) ShardForRouteKey(routeKey string) (string, error) {
+	hash := CompositeIdHash(routeKey)
+	for shardName, shard := range collectionState.Shards {
+		if len(shard.State) != 0 && shard.State != ReplicaActive {
+			continue
+		}
+		hashRange, err := ParseHashRange(shard.Range)
+		if err != nil {
+			return "", err
+		}
+		if hashRange.Includes(hash) {
+			return shardName, nil
+		}
+	}
+	return "", fmt.Errorf("no active shard found for route key: %s", routeKey)
+}
+
+// routedUpdate split the documents per shard and send each sub-batch to the shard leader in parallel,
+// returns false if the documents cannot be routed (e.g. not cloud mode, not compositeId router or missing ids)
+func (solrClient *SolrClient) routedUpdate(ctx context.Context, body []byte, params url.Values, commit bool) (bool, *SolrResponseData, error) {
+	if solrClient.cloud == nil || len(params.Get("_route_")) != 0 {
+		return false, nil, nil
+	}
+	clusterState := solrClient.ClusterState()
+	collectionState, ok := clusterState.Collections[solrClient.solrConfig.Collection]
+	if !ok || (len(collectionState.Router.Name) != 0 && collectionState.Router.Name != compositeIdRouter) {
+		return false, nil, nil
+	}
+	batches, ok := solrClient.splitByShard(collectionState, clusterState.liveNodeSet(), body)
+	if !ok {
+		return false, nil, nil
+	}
+
+	responses := make([]*SolrResponseData, len(batches))
+	errs := make([]error, len(batches))
+	var waitGroup sync.WaitGroup
+	for i, batch := range batches {
+		waitGroup.Add(1)
+		go func(i int, batch *shardBatch) {
+			defer waitGroup.Done()
+			var solrResponse SolrResponseData
+			request := &solrRequest{method: "POST", handler: "update", operation: OperationUpdate, params: params, body: batch.body()}
+			if batch.leader != nil {
+				request.path = batch.leader.Core + "/update"
+				request.nodeUrl = batch.leader.BaseURL
+			}
+			err := solrClient.execute(ctx, request, &solrResponse)
+			if err != nil && request.nodeUrl != "" && ctx.Err() == nil && isNodeFailure(err) {
+				// leader is not reachable, any other node forwards the documents to the new leader
+				request.path = ""
+				request.nodeUrl = ""
+				solrResponse = SolrResponseData{}
+				err = solrClient.execute(ctx, request, &solrResponse)
+			}
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			responses[i] = &solrResponse
+		}(i, batch)
+	}
+	waitGroup.Wait()
+	shardErr := &ShardUpdateError{Failed: make(map[string]error)}
+	for i, err := range errs {
+		if err != nil {
+			shardErr.Failed[batches[i].shard] = err
+		} else {
+			shardErr.Succeeded = append(shardErr.Succeeded, batches[i].shard)
+		}
+	}
+	if len(shardErr.Failed) != 0 {
+		sort.Strings(shardErr.Succeeded)
+		return true, nil, shardErr
+	}
+
+	merged := &SolrResponseData{}
+	for _, response := range responses {
+		if response.ResponseHeader.QTime > merged.ResponseHeader.QTime {
+			merged.ResponseHeader.QTime = response.ResponseHeader.QTime
+		}
+	}
+	if commit {
+		var commitResponse SolrResponseData
+		commitParams := url.Values{}
+		commitParams.Set("commit", "true")
+		request := &solrRequest{method: "POST", handler: "update", operation: OperationUpdate, params: commitParams, body: []byte(`{"commit":{}}`)}
+		if err := solrClient.execute(ctx, request, &commitResponse); err != nil {
+			return true, nil, err
+		}
+		merged.ResponseHeader.QTime += commitResponse.ResponseHeader.QTime
+	}
+	return true, merged, nil
+}
+
+// Error returns the failed shards with their errors (and the succeeded shards)
+func (e *ShardUpdateError) Error() string {
+	failed := e.FailedShards()
+	messages := make([]string, 0, len(failed))
+	for _, shard := range failed {
+		messages = append(messages, fmt.Sprintf("%s: %v", shard, e.Failed[shard]))
+	}
+	msg := fmt.Sprintf("update failed on shards %s", strings.Join(failed, ", "))
+	if len(e.Succeeded) != 0 {
+		msg += fmt.Sprintf(" (succeeded on shards %s)", strings.Join(e.Succeeded, ", "))
+	}
+	return msg + ": " + strings.Join(messages, "; ")
+}
+
+// Unwrap returns the errors of the failed shards
+func (e *ShardUpdateError) Unwrap() []error {
+	var errs []error
+	for _, shard := range e.FailedShards() {
+		errs = append(errs, e.Failed[shard])
+	}
+	return errs
+}
+
+// FailedShards returns the names of the failed shards (sorted)
+func (e *ShardUpdateError) FailedShards() []string {
+	shards := make([]string, 0, len(e.Failed))
+	for shard := range e.Failed {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+	return shards
+}
+
+// Partial returns true if the documents were written to some of the shards
+func (e *ShardUpdateError) Partial() bool {
+	return len(e.Succeeded) != 0
+}
+
+// splitByShard group the JSON documents of the body by shard, returns false if the body is not an array of documents with route keys
+func (solrClient *SolrClient) splitByShard(collectionState *CollectionState, liveNodes map[string]bool, body []byte) ([]*shardBatch, bool) {
+	var docs []json.RawMessage
+	if err := json.Unmarshal(body, &docs); err != nil || len(docs) == 0 {
+		return nil, false
+	}
+	routeField := collectionState.Router.Field
+	if len(routeField) == 0 {
+		routeField = solrClient.solrConfig.uniqueKey()
+	}
+	batchesByShard := make(map[string]*shardBatch)
+	for _, doc := range docs {
+		var fields map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(doc))
+		decoder.UseNumber()
+		if err := decoder.Decode(&fields); err != nil {
+			return nil, false
+		}
+		routeValue, ok := fields[routeField]
+		if !ok || routeValue == nil {
+			return nil, false
+		}
+		shardName, err := collectionState.ShardForRouteKey(fmt.Sprint(routeValue))
+		if err != nil {
+			return nil, false
+		}
+		batch, ok := batchesByShard[shardName]
+		if !ok {
+			batch = &shardBatch{shard: shardName}
+			leader := collectionState.Shards[shardName].Leader()
+			if leader != nil && leader.IsActive(liveNodes) {
+				batch.leader = leader
+			}
+			batchesByShard[shardName] = batch
+		}
+		batch.docs = append(batch.docs, doc)
+	}
+	var shardNames []string
+	for shardName := range batchesByShard {
+		shardNames = append(shardNames, shardName)
+	}
+	sort.Strings(shardNames)
+	batches := make([]*shardBatch, 0, len(shardNames))
+	for _, shardName := range shardNames {
+		batches = append(batches, batchesByShard[shardName])
+	}
+	return batches, true
+}
+
+// uniqueKey returns the uniqueKey field of the collection (id by default)
+func (solrConfig *SolrConfig) uniqueKey() string {
+	if len(solrConfig.UniqueKey) != 0 {
+		return solrConfig.UniqueKey
+	}
+	return defaultUniqueKey
+}
@@ -0,0 +1,244 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// staticClusterStateProvider returns the same cluster state every time
+type staticClusterStateProvider struct {
+	clusterState *ClusterState
+}
+
+func (provider *staticClusterStateProvider) ClusterState(ctx context.Context, collection string) (*ClusterState, error) {
+	return provider.clusterState, nil
+}
+
+// hashOf converts an unsigned hash literal into the signed hash that Solr uses
+func hashOf(hash uint32) int32 {
+	return int32(hash)
+}
+
+func TestMurmur3Hash32(t *testing.T) {
+	tests := []struct {
+		data     string
+		seed     uint32
+		expected uint32
+	}{
+		{"", 0, 0},
+		{"", 1, 0x514e28b7},
+		{"a", 0, 0x3c2569b2},
+		{"ab", 0, 0x9bbfd75f},
+		{"abc", 0, 0xb3dd93fa},
+		{"test", 0, 0xba6bd213},
+		{"hello", 0, 0x248bfa47},
+		{"Hello, world!", 1234, 0xfaf6cdb3},
+		{"The quick brown fox jumps over the lazy dog", 0, 0x2e4ff723},
+	}
+	for _, test := range tests {
+		if hash := Murmur3Hash32([]byte(test.data), test.seed); hash != hashOf(test.expected) {
+			t.Errorf("Murmur3Hash32(%q, %d) = %#x, expected: %#x", test.data, test.seed, uint32(hash), test.expected)
+		}
+	}
+}
+
+func TestCompositeIdHash(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected uint32
+	}{
+		// plain ids are hashed as a whole
+		{"doc1", 0xd8ced634},
+		// upper 16 bits from the shard key, lower 16 bits from the id
+		{"IBM!12345", 0x76271193},
+		{"tenant!doc1", 0x821fd634},
+		// upper 8 bits from the shard key
+		{"tenant/8!doc1", 0x82ced634},
+		// 0 bits from the shard key
+		{"tenant/0!doc1", 0xd8ced634},
+		// 8 bits from the first, 8 bits from the second shard key, 16 bits from the id
+		{"app!user!doc1", 0x7994d634},
+		// shard key prefix only (lower bits are the hash of the empty string)
+		{"tenant!", 0x821f0000},
+	}
+	for _, test := range tests {
+		if hash := CompositeIdHash(test.id); hash != hashOf(test.expected) {
+			t.Errorf("CompositeIdHash(%q) = %#x, expected: %#x", test.id, uint32(hash), test.expected)
+		}
+	}
+	if CompositeIdHash("tenant!doc1")&hashOf(0xffff0000) != CompositeIdHash("tenant!doc2")&hashOf(0xffff0000) {
+		t.Errorf("documents of the same shard key should share the upper 16 bits")
+	}
+}
+
+func TestParseHashRange(t *testing.T) {
+	tests := []struct {
+		hashRange string
+		expected  HashRange
+		invalid   bool
+	}{
+		{hashRange: "80000000-ffffffff", expected: HashRange{Min: math.MinInt32, Max: -1}},
+		{hashRange: "0-7fffffff", expected: HashRange{Min: 0, Max: math.MaxInt32}},
+		{hashRange: "80000000-7fffffff", expected: HashRange{Min: math.MinInt32, Max: math.MaxInt32}},
+		{hashRange: "d5550000-ffffffff", expected: HashRange{Min: hashOf(0xd5550000), Max: -1}},
+		{hashRange: "80000000", invalid: true},
+		{hashRange: "zz-7fffffff", invalid: true},
+		{hashRange: "0-100000000", invalid: true},
+		{hashRange: "", invalid: true},
+	}
+	for _, test := range tests {
+		hashRange, err := ParseHashRange(test.hashRange)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("ParseHashRange(%q) should fail", test.hashRange)
+			}
+			continue
+		}
+		if err != nil || hashRange != test.expected {
+			t.Errorf("ParseHashRange(%q) = %+v, %v, expected: %+v", test.hashRange, hashRange, err, test.expected)
+		}
+	}
+
+	upper, _ := ParseHashRange("80000000-ffffffff")
+	lower, _ := ParseHashRange("0-7fffffff")
+	for _, hash := range []int32{math.MinInt32, -1} {
+		if !upper.Includes(hash) || lower.Includes(hash) {
+			t.Errorf("hash %#x should be in the 80000000-ffffffff range only", uint32(hash))
+		}
+	}
+	for _, hash := range []int32{0, math.MaxInt32} {
+		if upper.Includes(hash) || !lower.Includes(hash) {
+			t.Errorf("hash %#x should be in the 0-7fffffff range only", uint32(hash))
+		}
+	}
+}
+
+// twoShardCollection create a collection with two shards with the default hash ranges, the leaders are on the given base urls
+func twoShardCollection(shard1Url string, shard2Url string) *ClusterState {
+	return &ClusterState{
+		LiveNodes: []string{"node1", "node2"},
+		Collections: map[string]*CollectionState{
+			"test": {
+				Router: CollectionRouter{Name: compositeIdRouter},
+				Shards: map[string]*ShardState{
+					"shard1": {Range: "80000000-ffffffff", State: ReplicaActive, Replicas: map[string]*ReplicaState{
+						"core_node1": {Core: "test_shard1_replica_n1", BaseURL: shard1Url, NodeName: "node1", State: ReplicaActive, Leader: "true"},
+					}},
+					"shard2": {Range: "0-7fffffff", State: ReplicaActive, Replicas: map[string]*ReplicaState{
+						"core_node2": {Core: "test_shard2_replica_n2", BaseURL: shard2Url, NodeName: "node2", State: ReplicaActive, Leader: "true"},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func TestShardForRouteKey(t *testing.T) {
+	collectionState := twoShardCollection("http://node1", "http://node2").Collections["test"]
+	tests := map[string]string{
+		"doc1":          "shard1",
+		"tenant!doc1":   "shard1",
+		"IBM!12345":     "shard2",
+		"app!user!doc1": "shard2",
+	}
+	for routeKey, expected := range tests {
+		if shard, err := collectionState.ShardForRouteKey(routeKey); err != nil || shard != expected {
+			t.Errorf("ShardForRouteKey(%q) = %s, %v, expected: %s", routeKey, shard, err, expected)
+		}
+	}
+	collectionState.Shards["shard2"].State = "inactive"
+	if _, err := collectionState.ShardForRouteKey("IBM!12345"); err == nil {
+		t.Errorf("route key of an inactive shard should fail")
+	}
+}
+
+func newUpdateServer(t *testing.T, status int) (*httptest.Server, *[]string) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"responseHeader":{"status":0,"QTime":1}}`))
+		} else {
+			w.Write([]byte(`{"responseHeader":{"status":400},"error":{"msg":"ERROR: [doc=x] unknown field 'foo'","code":400}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &paths
+}
+
+func newRoutedTestClient(t *testing.T, shard1Url string, shard2Url string) *SolrClient {
+	provider := &staticClusterStateProvider{clusterState: twoShardCollection(shard1Url, shard2Url)}
+	solrClient, err := NewSolrClient(&SolrConfig{Url: shard1Url, Collection: "test"}, WithClusterStateProvider(provider))
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+	return solrClient
+}
+
+func TestRoutedUpdate(t *testing.T) {
+	shard1, shard1Paths := newUpdateServer(t, http.StatusOK)
+	shard2, shard2Paths := newUpdateServer(t, http.StatusOK)
+	solrClient := newRoutedTestClient(t, shard1.URL, shard2.URL)
+	docs := []map[string]interface{}{{"id": "doc1"}, {"id": "IBM!12345"}}
+	if _, _, err := solrClient.Update(docs, nil, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(*shard1Paths, []string{"/test_shard1_replica_n1/update"}) || !reflect.DeepEqual(*shard2Paths, []string{"/test_shard2_replica_n2/update"}) {
+		t.Fatalf("documents are not sent to the shard leaders: %v, %v", *shard1Paths, *shard2Paths)
+	}
+}
+
+func TestRoutedUpdatePartialFailure(t *testing.T) {
+	shard1, _ := newUpdateServer(t, http.StatusOK)
+	shard2, _ := newUpdateServer(t, http.StatusBadRequest)
+	solrClient := newRoutedTestClient(t, shard1.URL, shard2.URL)
+	docs := []map[string]interface{}{{"id": "doc1"}, {"id": "IBM!12345"}}
+	_, _, err := solrClient.Update(docs, nil, false)
+	var shardErr *ShardUpdateError
+	if !errors.As(err, &shardErr) {
+		t.Fatalf("expected ShardUpdateError, got: %v", err)
+	}
+	if !shardErr.Partial() || !reflect.DeepEqual(shardErr.Succeeded, []string{"shard1"}) || !reflect.DeepEqual(shardErr.FailedShards(), []string{"shard2"}) {
+		t.Fatalf("unexpected shard error: %+v", shardErr)
+	}
+	if !IsBadRequest(err) || !strings.Contains(err.Error(), "shard2: ") {
+		t.Fatalf("the shard errors should be wrapped: %v", err)
+	}
+}
+
+func TestRoutedUpdateTotalFailure(t *testing.T) {
+	shard1, _ := newUpdateServer(t, http.StatusBadRequest)
+	shard2, _ := newUpdateServer(t, http.StatusBadRequest)
+	solrClient := newRoutedTestClient(t, shard1.URL, shard2.URL)
+	docs := []map[string]interface{}{{"id": "doc1"}, {"id": "IBM!12345"}}
+	_, _, err := solrClient.Update(docs, nil, false)
+	var shardErr *ShardUpdateError
+	if !errors.As(err, &shardErr) {
+		t.Fatalf("expected ShardUpdateError, got: %v", err)
+	}
+	if shardErr.Partial() || !reflect.DeepEqual(shardErr.FailedShards(), []string{"shard1", "shard2"}) {
+		t.Fatalf("unexpected shard error: %+v", shardErr)
+	}
+}
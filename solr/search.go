@@ -0,0 +1,104 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"iter"
+	"reflect"
+	"strconv"
+)
+
+// defaultPageSize is the number of rows per page of SearchAll, if the query does not set rows
+const defaultPageSize = 100
+
+// Results holds the typed documents of a Solr query response
+type Results[T any] struct {
+	ResponseHeader SolrResponseHeader
+	NumFound       int64
+	Start          int64
+	MaxScore       float32
+	Docs           []T
+	NextCursorMark string
+	// Response holds every other part of the response (e.g. facets, highlighting), without the documents
+	Response *SolrResponseData
+}
+
+// Search get Solr data based on parameters, and decode the documents into T (a struct with solr tags, see UnmarshalDocument, or SolrDocument)
+func Search[T any](solrClient *SolrClient, solrQuery *SolrQuery) (*Results[T], error) {
+	return SearchContext[T](context.Background(), solrClient, solrQuery)
+}
+
+// SearchContext get Solr data based on parameters and decode the documents into T, the request is cancelled when the context is done
+func SearchContext[T any](ctx context.Context, solrClient *SolrClient, solrQuery *SolrQuery) (*Results[T], error) {
+	solrResponse, rawDocs, err := solrClient.queryDocuments(ctx, solrQuery)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]T, 0, len(rawDocs))
+	if err := unmarshalValue(rawDocs, reflect.ValueOf(&docs).Elem()); err != nil {
+		return nil, err
+	}
+	return &Results[T]{ResponseHeader: solrResponse.ResponseHeader, NumFound: solrResponse.Response.NumFound, Start: solrResponse.Response.Start,
+		MaxScore: solrResponse.Response.MaxScore, Docs: docs, NextCursorMark: solrResponse.NextCursorMark, Response: solrResponse.responseData()}, nil
+}
+
+// SearchAll returns an iterator over every document that matches the query, the pages are fetched on demand: with cursors
+// if the query has a cursorMark parameter (e.g. "*"), otherwise with start/rows (rows of the query is the page size);
+// the iteration stops after the first error (it is yielded with the zero value of T)
+func SearchAll[T any](ctx context.Context, solrClient *SolrClient, solrQuery *SolrQuery) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		pageQuery := CreateSolrQuery()
+		if solrQuery != nil {
+			pageQuery = solrQuery.Clone()
+		}
+		if len(pageQuery.GetParam("rows")) == 0 {
+			pageQuery.Rows(defaultPageSize)
+		}
+		cursorMark := pageQuery.GetParam("cursorMark")
+		start, _ := strconv.ParseInt(pageQuery.GetParam("start"), 10, 64)
+		for {
+			if len(cursorMark) == 0 {
+				pageQuery.SetParam("start", strconv.FormatInt(start, 10))
+			}
+			results, err := SearchContext[T](ctx, solrClient, pageQuery)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, doc := range results.Docs {
+				if !yield(doc, nil) {
+					return
+				}
+			}
+			if len(results.Docs) == 0 {
+				return
+			}
+			if len(cursorMark) != 0 {
+				if results.NextCursorMark == cursorMark || len(results.NextCursorMark) == 0 {
+					return
+				}
+				cursorMark = results.NextCursorMark
+				pageQuery.SetParam("cursorMark", cursorMark)
+				continue
+			}
+			start += int64(len(results.Docs))
+			if start >= results.NumFound {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// pagingServer serves numDocs documents with start/rows or with cursorMark ("page-<offset>" cursor marks)
+type pagingServer struct {
+	*httptest.Server
+	numDocs int
+	// failAt is the number of the request that fails with 500 (0 means no failure)
+	failAt int
+	// repeatCursorMark makes the server return the requested cursor mark as next cursor mark
+	repeatCursorMark bool
+	mutex            sync.Mutex
+	requests         []url.Values
+}
+
+type searchTestDocument struct {
+	ID  string `solr:"id"`
+	Seq int64  `solr:"seq_l"`
+}
+
+func newPagingServer(t *testing.T, numDocs int) *pagingServer {
+	server := &pagingServer{numDocs: numDocs}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *pagingServer) handle(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	server.mutex.Lock()
+	server.requests = append(server.requests, r.Form)
+	requestNumber := len(server.requests)
+	server.mutex.Unlock()
+	if requestNumber == server.failAt {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"responseHeader":{"status":500},"error":{"msg":"index is corrupted","code":500}}`))
+		return
+	}
+	rows, _ := strconv.Atoi(r.Form.Get("rows"))
+	offset, _ := strconv.Atoi(r.Form.Get("start"))
+	cursorMark := r.Form.Get("cursorMark")
+	if len(cursorMark) != 0 && cursorMark != CursorMarkStart {
+		fmt.Sscanf(cursorMark, "page-%d", &offset)
+	}
+	var docs []string
+	for i := offset; i < server.numDocs && i < offset+rows; i++ {
+		docs = append(docs, fmt.Sprintf(`{"id":"doc-%d","seq_l":%d}`, i, i))
+	}
+	nextCursorMark := ""
+	if len(cursorMark) != 0 {
+		nextCursorMark = fmt.Sprintf("page-%d", offset+len(docs))
+		if len(docs) == 0 || server.repeatCursorMark {
+			nextCursorMark = cursorMark
+		}
+	}
+	fmt.Fprintf(w, `{"responseHeader":{"status":0},"response":{"numFound":%d,"start":%d,"docs":[%s]},"nextCursorMark":"%s"}`,
+		server.numDocs, offset, strings.Join(docs, ","), nextCursorMark)
+}
+
+func (server *pagingServer) requestParams(name string) []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	var values []string
+	for _, params := range server.requests {
+		values = append(values, params.Get(name))
+	}
+	return values
+}
+
+func newSearchTestClient(t *testing.T, server *pagingServer) *SolrClient {
+	solrClient, err := NewSolrClient(&SolrConfig{Url: server.URL, Collection: "test"})
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+	return solrClient
+}
+
+// collectIds iterate over the documents, and returns their ids and the first error
+func collectIds(docs func(yield func(searchTestDocument, error) bool)) ([]string, error) {
+	var ids []string
+	for doc, err := range docs {
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, doc.ID)
+	}
+	return ids, nil
+}
+
+func TestSearchContext(t *testing.T) {
+	server := newPagingServer(t, 3)
+	solrClient := newSearchTestClient(t, server)
+	q := CreateSolrQuery()
+	q.Rows(2)
+	results, err := SearchContext[searchTestDocument](context.Background(), solrClient, q)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results.NumFound != 3 || results.Start != 0 || len(results.Docs) != 2 || results.Docs[1] != (searchTestDocument{ID: "doc-1", Seq: 1}) {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if results.Response == nil || results.Response.Response.NumFound != 3 || len(results.Response.Response.Docs) != 0 {
+		t.Fatalf("the response should hold the other parts without the documents: %+v", results.Response)
+	}
+}
+
+func TestSearchAllStartRows(t *testing.T) {
+	server := newPagingServer(t, 5)
+	solrClient := newSearchTestClient(t, server)
+	q := CreateSolrQuery()
+	q.Rows(2)
+	ids, err := collectIds(SearchAll[searchTestDocument](context.Background(), solrClient, q))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(ids, ",") != "doc-0,doc-1,doc-2,doc-3,doc-4" {
+		t.Fatalf("unexpected documents: %v", ids)
+	}
+	// the last page reaches numFound, no empty page is requested
+	if starts := strings.Join(server.requestParams("start"), ","); starts != "0,2,4" {
+		t.Fatalf("unexpected pages: %s", starts)
+	}
+	if q.GetParam("start") != "" {
+		t.Fatalf("the query of the caller should not be modified: %s", q.params.Encode())
+	}
+}
+
+func TestSearchAllDefaultPageSize(t *testing.T) {
+	server := newPagingServer(t, 3)
+	solrClient := newSearchTestClient(t, server)
+	ids, err := collectIds(SearchAll[searchTestDocument](context.Background(), solrClient, nil))
+	if err != nil || len(ids) != 3 {
+		t.Fatalf("unexpected documents: %v, error: %v", ids, err)
+	}
+	if rows := server.requestParams("rows"); len(rows) != 1 || rows[0] != strconv.Itoa(defaultPageSize) {
+		t.Fatalf("unexpected page size: %v", rows)
+	}
+}
+
+func TestSearchAllCursor(t *testing.T) {
+	server := newPagingServer(t, 5)
+	solrClient := newSearchTestClient(t, server)
+	q := CreateSolrQuery()
+	q.Rows(2)
+	q.Cursor("id")
+	ids, err := collectIds(SearchAll[searchTestDocument](context.Background(), solrClient, q))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(ids, ",") != "doc-0,doc-1,doc-2,doc-3,doc-4" {
+		t.Fatalf("unexpected documents: %v", ids)
+	}
+	// the cursor does not know numFound: the last request returns no documents and the same cursor mark
+	if marks := strings.Join(server.requestParams("cursorMark"), ","); marks != "*,page-2,page-4,page-5" {
+		t.Fatalf("unexpected cursor marks: %s", marks)
+	}
+}
+
+func TestSearchAllCursorMarkRepeated(t *testing.T) {
+	server := newPagingServer(t, 5)
+	server.repeatCursorMark = true
+	solrClient := newSearchTestClient(t, server)
+	q := CreateSolrQuery()
+	q.Rows(2)
+	q.Cursor("id")
+	ids, err := collectIds(SearchAll[searchTestDocument](context.Background(), solrClient, q))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(ids, ",") != "doc-0,doc-1" || len(server.requestParams("cursorMark")) != 1 {
+		t.Fatalf("the iteration should stop when the cursor mark does not advance: %v", ids)
+	}
+}
+
+func TestSearchAllBreak(t *testing.T) {
+	server := newPagingServer(t, 10)
+	solrClient := newSearchTestClient(t, server)
+	q := CreateSolrQuery()
+	q.Rows(3)
+	var ids []string
+	for doc, err := range SearchAll[searchTestDocument](context.Background(), solrClient, q) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ids = append(ids, doc.ID)
+		if len(ids) == 4 {
+			break
+		}
+	}
+	if strings.Join(ids, ",") != "doc-0,doc-1,doc-2,doc-3" {
+		t.Fatalf("unexpected documents: %v", ids)
+	}
+	if starts := strings.Join(server.requestParams("start"), ","); starts != "0,3" {
+		t.Fatalf("no page should be fetched after break: %s", starts)
+	}
+}
+
+func TestSearchAllError(t *testing.T) {
+	server := newPagingServer(t, 10)
+	server.failAt = 2
+	solrClient := newSearchTestClient(t, server)
+	q := CreateSolrQuery()
+	q.Rows(3)
+	var ids []string
+	var errs []error
+	for doc, err := range SearchAll[searchTestDocument](context.Background(), solrClient, q) {
+		if err != nil {
+			errs = append(errs, err)
+			if doc != (searchTestDocument{}) {
+				t.Fatalf("the error should be yielded with the zero value: %+v", doc)
+			}
+			continue
+		}
+		ids = append(ids, doc.ID)
+	}
+	if strings.Join(ids, ",") != "doc-0,doc-1,doc-2" {
+		t.Fatalf("the documents before the error should be yielded: %v", ids)
+	}
+	if len(errs) != 1 || AsSolrError(errs[0]) == nil || AsSolrError(errs[0]).HTTPStatus != http.StatusInternalServerError {
+		t.Fatalf("expected one Solr error, got: %v", errs)
+	}
+	if len(server.requestParams("start")) != 2 {
+		t.Fatalf("the iteration should stop after the error")
+	}
+}
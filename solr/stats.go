@@ -0,0 +1,216 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatsFieldBuilder builds a stats.field parameter (a field or function with local params)
+type StatsFieldBuilder struct {
+	field  string
+	params [][2]string
+}
+
+// StatsResult holds the typed stats section of a response
+type StatsResult struct {
+	StatsFields map[string]*FieldStats `json:"stats_fields"`
+}
+
+// FieldStats holds the statistics of a stats field, only the requested statistics are filled,
+// Min, Max and Mean are numbers for numeric fields, but strings for date and string fields
+type FieldStats struct {
+	Min            interface{}   `json:"min,omitempty"`
+	Max            interface{}   `json:"max,omitempty"`
+	Count          int64         `json:"count,omitempty"`
+	Missing        int64         `json:"missing,omitempty"`
+	Sum            float64       `json:"sum,omitempty"`
+	SumOfSquares   float64       `json:"sumOfSquares,omitempty"`
+	Mean           interface{}   `json:"mean,omitempty"`
+	Stddev         float64       `json:"stddev,omitempty"`
+	Percentiles    Percentiles   `json:"percentiles,omitempty"`
+	CountDistinct  int64         `json:"countDistinct,omitempty"`
+	DistinctValues []interface{} `json:"distinctValues,omitempty"`
+	Cardinality    int64         `json:"cardinality,omitempty"`
+	// Facets holds the statistics per stats.facet field and value
+	Facets map[string]map[string]*FieldStats `json:"facets,omitempty"`
+}
+
+// Percentiles holds the requested percentiles of a stats field (percentile -> value)
+type Percentiles map[float64]float64
+
+// StatsField create a stats field builder for a field or function, e.g. StatsField("seq_num").Percentiles(50, 95, 99)
+func StatsField(field string) *StatsFieldBuilder {
+	return &StatsFieldBuilder{field: field}
+}
+
+// AddStatsField enable the stats component and add a stats field (stats.field)
+func (q *SolrQuery) AddStatsField(stats *StatsFieldBuilder) {
+	q.SetParam("stats", "true")
+	q.AddParam("stats.field", stats.String())
+}
+
+// StatsFacet add a field to compute the statistics per value of that field (stats.facet)
+func (q *SolrQuery) StatsFacet(field string) {
+	q.AddParam("stats.facet", field)
+}
+
+// Param add a local param to the stats field
+func (stats *StatsFieldBuilder) Param(key string, value string) *StatsFieldBuilder {
+	stats.params = append(stats.params, [2]string{key, value})
+	return stats
+}
+
+// Key sets the name of the stats field in the response
+func (stats *StatsFieldBuilder) Key(key string) *StatsFieldBuilder {
+	return stats.Param("key", key)
+}
+
+// Tag tags the stats field (e.g. for pivot facets)
+func (stats *StatsFieldBuilder) Tag(tag string) *StatsFieldBuilder {
+	return stats.Param("tag", tag)
+}
+
+// Exclude exclude the filters with the given tags during the stats computation
+func (stats *StatsFieldBuilder) Exclude(tags ...string) *StatsFieldBuilder {
+	return stats.Param("ex", strings.Join(tags, ","))
+}
+
+// Percentiles request percentiles, e.g. Percentiles(50, 95, 99)
+func (stats *StatsFieldBuilder) Percentiles(percentiles ...float64) *StatsFieldBuilder {
+	values := make([]string, 0, len(percentiles))
+	for _, percentile := range percentiles {
+		values = append(values, strconv.FormatFloat(percentile, 'f', -1, 64))
+	}
+	return stats.Param("percentiles", strings.Join(values, ","))
+}
+
+// CountDistinct request the exact number of distinct values
+func (stats *StatsFieldBuilder) CountDistinct() *StatsFieldBuilder {
+	return stats.Param("countDistinct", "true")
+}
+
+// Cardinality request the approximated number of distinct values
+func (stats *StatsFieldBuilder) Cardinality() *StatsFieldBuilder {
+	return stats.Param("cardinality", "true")
+}
+
+// Stats request the named statistics (e.g. "min", "max", "mean"), if any statistic is requested explicitly
+// (including percentiles, countDistinct and cardinality), Solr computes only the requested ones
+func (stats *StatsFieldBuilder) Stats(names ...string) *StatsFieldBuilder {
+	for _, name := range names {
+		stats.Param(name, "true")
+	}
+	return stats
+}
+
+// String returns the stats.field parameter value, e.g. {!percentiles='50,95,99' countDistinct='true'}seq_num
+func (stats *StatsFieldBuilder) String() string {
+	if len(stats.params) == 0 {
+		return stats.field
+	}
+	var builder strings.Builder
+	builder.WriteString("{!")
+	for i, param := range stats.params {
+		if i > 0 {
+			builder.WriteString(" ")
+		}
+		builder.WriteString(param[0])
+		builder.WriteString("=")
+		builder.WriteString(quoteLocalParam(param[1]))
+	}
+	builder.WriteString("}")
+	builder.WriteString(stats.field)
+	return builder.String()
+}
+
+// FieldStats returns the statistics of a stats field (nil if the stats section or the field does not exist)
+func (solrResponse *SolrResponseData) FieldStats(name string) *FieldStats {
+	if solrResponse.Stats == nil {
+		return nil
+	}
+	return solrResponse.Stats.StatsFields[name]
+}
+
+// Percentile returns the value of a requested percentile
+func (fieldStats *FieldStats) Percentile(percentile float64) (float64, bool) {
+	value, ok := fieldStats.Percentiles[percentile]
+	return value, ok
+}
+
+// UnmarshalJSON decode the percentiles from a flat [percentile, value, ...] array or from an object
+func (percentiles *Percentiles) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil || token == nil {
+		return err
+	}
+	values := Percentiles{}
+	switch token {
+	case json.Delim('['):
+		for decoder.More() {
+			var key string
+			if err := decoder.Decode(&key); err != nil {
+				return err
+			}
+			if !decoder.More() {
+				return fmt.Errorf("percentile without value: %s", key)
+			}
+			if err := values.decodeValue(decoder, key); err != nil {
+				return err
+			}
+		}
+	case json.Delim('{'):
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			if err := values.decodeValue(decoder, fmt.Sprint(key)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unexpected JSON token in percentiles: %v", token)
+	}
+	*percentiles = values
+	return nil
+}
+
+// MarshalJSON encode the percentiles as an object (JSON object keys cannot be numbers)
+func (percentiles Percentiles) MarshalJSON() ([]byte, error) {
+	values := make(map[string]float64, len(percentiles))
+	for percentile, value := range percentiles {
+		values[strconv.FormatFloat(percentile, 'f', -1, 64)] = value
+	}
+	return json.Marshal(values)
+}
+
+func (percentiles Percentiles) decodeValue(decoder *json.Decoder, key string) error {
+	percentile, err := strconv.ParseFloat(key, 64)
+	if err != nil {
+		return fmt.Errorf("invalid percentile %q: %v", key, err)
+	}
+	var value float64
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	percentiles[percentile] = value
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatsFieldString(t *testing.T) {
+	testCases := []struct {
+		stats    *StatsFieldBuilder
+		expected string
+	}{
+		{StatsField("seq_num"), "seq_num"},
+		{StatsField("seq_num").Percentiles(50, 95, 99).CountDistinct(), "{!percentiles='50,95,99' countDistinct='true'}seq_num"},
+		{StatsField("seq_num").Percentiles(99.9), "{!percentiles='99.9'}seq_num"},
+		{StatsField("logtime").Key("time").Tag("t1").Exclude("f1", "f2").Stats("min", "max"),
+			"{!key='time' tag='t1' ex='f1,f2' min='true' max='true'}logtime"},
+		{StatsField("host").Cardinality(), "{!cardinality='true'}host"},
+		{StatsField("div(size,2)").Param("key", "it's"), `{!key='it\'s'}div(size,2)`},
+	}
+	for _, testCase := range testCases {
+		if actual := testCase.stats.String(); actual != testCase.expected {
+			t.Errorf("expected %s, got %s", testCase.expected, actual)
+		}
+	}
+}
+
+func TestAddStatsField(t *testing.T) {
+	q := CreateSolrQuery()
+	q.AddStatsField(StatsField("seq_num").Percentiles(50, 95, 99).CountDistinct())
+	q.AddStatsField(StatsField("logtime"))
+	q.StatsFacet("host")
+	if q.params.Get("stats") != "true" {
+		t.Fatalf("stats component is not enabled: %v", q.params.Encode())
+	}
+	expected := []string{"{!percentiles='50,95,99' countDistinct='true'}seq_num", "logtime"}
+	if fields := (*q.params)["stats.field"]; !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("unexpected stats.field params: %v", fields)
+	}
+	if facets := (*q.params)["stats.facet"]; !reflect.DeepEqual(facets, []string{"host"}) {
+		t.Fatalf("unexpected stats.facet params: %v", facets)
+	}
+}
+
+func TestPercentilesUnmarshalJSON(t *testing.T) {
+	testCases := []struct {
+		data     string
+		expected Percentiles
+	}{
+		{`["50.0",10.5,"95.0",20,"99.0",31.25]`, Percentiles{50: 10.5, 95: 20, 99: 31.25}},
+		{`{"50.0":10.5,"95.0":20,"99.9":31.25}`, Percentiles{50: 10.5, 95: 20, 99.9: 31.25}},
+		{`[]`, Percentiles{}},
+		{`null`, nil},
+	}
+	for _, testCase := range testCases {
+		var percentiles Percentiles
+		if err := json.Unmarshal([]byte(testCase.data), &percentiles); err != nil {
+			t.Fatalf("cannot decode %s: %v", testCase.data, err)
+		}
+		if !reflect.DeepEqual(percentiles, testCase.expected) {
+			t.Errorf("%s: expected %v, got %v", testCase.data, testCase.expected, percentiles)
+		}
+	}
+	for _, data := range []string{`["50.0"]`, `["median",1]`, `{"50.0":"high"}`, `"50"`} {
+		var percentiles Percentiles
+		if err := json.Unmarshal([]byte(data), &percentiles); err == nil {
+			t.Errorf("%s: expected an error, got %v", data, percentiles)
+		}
+	}
+}
+
+func TestPercentilesMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Percentiles{50: 10.5, 99.9: 31.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"50":10.5,"99.9":31.25}` {
+		t.Fatalf("unexpected JSON: %s", data)
+	}
+	var percentiles Percentiles
+	if err := json.Unmarshal(data, &percentiles); err != nil || !reflect.DeepEqual(percentiles, Percentiles{50: 10.5, 99.9: 31.25}) {
+		t.Fatalf("percentiles are not round-tripped: %v, %v", percentiles, err)
+	}
+}
+
+func TestStatsResponse(t *testing.T) {
+	body := `{"responseHeader":{"status":0},"response":{"numFound":3,"start":0,"docs":[]},"stats":{"stats_fields":{
+		"seq_num":{"min":1.0,"max":30.0,"count":3,"missing":1,"sum":42.0,"sumOfSquares":950.0,"mean":14.0,"stddev":14.73,
+			"percentiles":["50.0",11.0,"99.0",29.8],"countDistinct":3,
+			"facets":{"host":{"host1":{"min":1.0,"max":11.0,"count":2},"host2":{"min":30.0,"max":30.0,"count":1}}}},
+		"logtime":{"min":"2018-01-01T00:00:00Z","max":"2018-01-03T00:00:00Z","count":3,"missing":0,
+			"mean":"2018-01-02T00:00:00Z"}}}}`
+	var solrResponse SolrResponseData
+	if err := decodeResponseStream(200, newResponseReader(strings.NewReader(body), 0), &solrResponse); err != nil {
+		t.Fatalf("cannot decode response: %v", err)
+	}
+	seqNum := solrResponse.FieldStats("seq_num")
+	if seqNum == nil || seqNum.Min != 1.0 || seqNum.Max != 30.0 || seqNum.Count != 3 || seqNum.Missing != 1 ||
+		seqNum.Sum != 42 || seqNum.Mean != 14.0 || seqNum.CountDistinct != 3 {
+		t.Fatalf("unexpected numeric stats: %+v", seqNum)
+	}
+	if value, ok := seqNum.Percentile(99); !ok || value != 29.8 {
+		t.Fatalf("unexpected 99th percentile: %v, %v", value, ok)
+	}
+	if _, ok := seqNum.Percentile(95); ok {
+		t.Fatalf("95th percentile is not requested")
+	}
+	host1 := seqNum.Facets["host"]["host1"]
+	if host1 == nil || host1.Min != 1.0 || host1.Max != 11.0 || host1.Count != 2 || seqNum.Facets["host"]["host2"].Count != 1 {
+		t.Fatalf("unexpected stats.facet section: %+v", seqNum.Facets)
+	}
+	logtime := solrResponse.FieldStats("logtime")
+	if logtime == nil || logtime.Min != "2018-01-01T00:00:00Z" || logtime.Max != "2018-01-03T00:00:00Z" ||
+		logtime.Mean != "2018-01-02T00:00:00Z" || logtime.Count != 3 {
+		t.Fatalf("unexpected date stats: %+v", logtime)
+	}
+	if solrResponse.FieldStats("missing_field") != nil || (&SolrResponseData{}).FieldStats("seq_num") != nil {
+		t.Fatalf("expected nil stats for a missing field or stats section")
+	}
+}
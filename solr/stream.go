@@ -0,0 +1,366 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ErrResponseTooLarge is returned if a Solr response is larger than the MaxResponseSizeBytes of the Solr config
+var ErrResponseTooLarge = errors.New("solr response is larger than the maximum response size")
+
+// resultFieldsCache caches the JSON field indexes of the result types (reflect.Type -> map[string][]int)
+var resultFieldsCache sync.Map
+
+// responseReader counts the bytes read from a response body, and fails with ErrResponseTooLarge after the limit (if it is set)
+type responseReader struct {
+	reader io.Reader
+	limit  int64
+	count  int64
+	err    error
+}
+
+// responseSectionDecoder is implemented by the response types that decode the documents of the response section one by one
+type responseSectionDecoder interface {
+	decodeResponseSection(decoder *json.Decoder) error
+}
+
+// facetCountsDecoder is implemented by the response types that keep the typed facet_counts section next to the untyped one
+type facetCountsDecoder interface {
+	decodeFacetCounts(raw json.RawMessage)
+}
+
+func newResponseReader(reader io.Reader, limit int64) *responseReader {
+	return &responseReader{reader: reader, limit: limit}
+}
+
+func (reader *responseReader) Read(p []byte) (int, error) {
+	if reader.err != nil {
+		return 0, reader.err
+	}
+	if reader.limit > 0 {
+		// read one byte more than the limit, so the limit itself is allowed
+		remaining := reader.limit + 1 - reader.count
+		if int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	n, err := reader.reader.Read(p)
+	reader.count += int64(n)
+	if reader.limit > 0 && reader.count > reader.limit {
+		reader.err = ErrResponseTooLarge
+		return n, reader.err
+	}
+	if err != nil && err != io.EOF {
+		reader.err = err
+	}
+	return n, err
+}
+
+// readError returns the error of reading the response body (too large response or connection error), or nil
+func (reader *responseReader) readError() error {
+	if reader.err == nil {
+		return nil
+	}
+	if reader.err == ErrResponseTooLarge {
+		return ErrResponseTooLarge
+	}
+	return &connectionError{err: reader.err}
+}
+
+// decodeResponseStream decode the Solr JSON response from the body into the result object without reading the whole body
+// into memory first: the top level sections are decoded one by one, the documents of the response section one by one
+// (if the result supports it); the errors are the same as the ones of decodeResponse
+func decodeResponseStream(httpStatus int, reader *responseReader, result interface{}) error {
+	if httpStatus < 200 || httpStatus >= 300 {
+		var body io.Reader = reader
+		if reader.limit <= 0 {
+			body = io.LimitReader(reader, maxErrorResponseLength)
+		}
+		bodyBytes, err := ioutil.ReadAll(body)
+		if err != nil {
+			return reader.readError()
+		}
+		return decodeResponse(httpStatus, bodyBytes, result)
+	}
+	buffered := bufio.NewReader(reader)
+	if !startsWithObject(buffered) {
+		bodyBytes, _ := ioutil.ReadAll(io.LimitReader(buffered, maxErrorBodyLength))
+		if err := reader.readError(); err != nil {
+			return err
+		}
+		return decodeResponse(httpStatus, bodyBytes, result)
+	}
+
+	decoder := json.NewDecoder(buffered)
+	var responseHeader SolrResponseHeader
+	var errorData *SolrErrorData
+	err := decodeObject(decoder, func(key string) error {
+		if key == "response" {
+			if sectionDecoder, ok := result.(responseSectionDecoder); ok {
+				return sectionDecoder.decodeResponseSection(decoder)
+			}
+		}
+		if key == "facet_counts" {
+			if countsDecoder, ok := result.(facetCountsDecoder); ok {
+				var raw json.RawMessage
+				if err := decoder.Decode(&raw); err != nil {
+					return err
+				}
+				countsDecoder.decodeFacetCounts(raw)
+				return decodeResultField(json.NewDecoder(bytes.NewReader(raw)), result, key)
+			}
+		}
+		switch key {
+		case "responseHeader", "error":
+			// small sections, that are needed by the error handling as well
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return err
+			}
+			target := interface{}(&responseHeader)
+			if key == "error" {
+				target = &errorData
+			}
+			if err := json.Unmarshal(raw, target); err != nil {
+				return err
+			}
+			return decodeResultField(json.NewDecoder(bytes.NewReader(raw)), result, key)
+		}
+		return decodeResultField(decoder, result, key)
+	})
+	if err != nil {
+		if readErr := reader.readError(); readErr != nil {
+			return readErr
+		}
+		return &SolrError{HTTPStatus: httpStatus, Msg: fmt.Sprintf("invalid JSON response: %v", err)}
+	}
+	if readErr := reader.readError(); readErr != nil {
+		// the response is complete, but the limit has been reached during the last read
+		return readErr
+	}
+	if errorData != nil {
+		return newSolrError(httpStatus, errorData, nil)
+	}
+	if responseHeader.Status != 0 {
+		status := int(responseHeader.Status)
+		return &SolrError{HTTPStatus: httpStatus, Code: status, Msg: fmt.Sprintf("response header status: %d", status)}
+	}
+	return nil
+}
+
+// decodeResponseSection decode the response section, the documents are decoded one by one
+func (solrResponse *SolrResponseData) decodeResponseSection(decoder *json.Decoder) error {
+	return decodeDocumentsSection(decoder, &solrResponse.Response.NumFound, &solrResponse.Response.Start, &solrResponse.Response.MaxScore, func() error {
+		var doc SolrDocument
+		if err := decoder.Decode(&doc); err != nil {
+			return err
+		}
+		solrResponse.Response.Docs = append(solrResponse.Response.Docs, doc)
+		return nil
+	})
+}
+
+// decodeResponseSection decode the response section, the documents are kept as raw JSON
+func (solrResponse *documentsResponseData) decodeResponseSection(decoder *json.Decoder) error {
+	return decodeDocumentsSection(decoder, &solrResponse.Response.NumFound, &solrResponse.Response.Start, &solrResponse.Response.MaxScore, func() error {
+		var doc json.RawMessage
+		if err := decoder.Decode(&doc); err != nil {
+			return err
+		}
+		solrResponse.Response.Docs = append(solrResponse.Response.Docs, doc)
+		return nil
+	})
+}
+
+// decodeDocumentsSection decode {"numFound":N,"start":N,"maxScore":N,"docs":[...]}, decodeDoc is called for every document
+func decodeDocumentsSection(decoder *json.Decoder, numFound *int64, start *int64, maxScore *float32, decodeDoc func() error) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("unexpected JSON token in response section: %v", token)
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "numFound":
+			err = decoder.Decode(numFound)
+		case "start":
+			err = decoder.Decode(start)
+		case "maxScore":
+			err = decoder.Decode(maxScore)
+		case "docs":
+			err = decodeArray(decoder, decodeDoc)
+		default:
+			var skipped json.RawMessage
+			err = decoder.Decode(&skipped)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// decodeObject read a JSON object key by key, decodeValue should consume the value of the key
+func decodeObject(decoder *json.Decoder, decodeValue func(key string) error) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("unexpected JSON token in Solr response: %v", token)
+		}
+		if err := decodeValue(key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// decodeArray read a JSON array (or null), decodeElement should consume the next element
+func decodeArray(decoder *json.Decoder, decodeElement func() error) error {
+	token, err := decoder.Token()
+	if err != nil || token == nil {
+		return err
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("unexpected JSON token in Solr response: %v (expected [)", token)
+	}
+	for decoder.More() {
+		if err := decodeElement(); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, ']')
+}
+
+// decodeResultField decode a top level section straight into the matching field of the result object (by JSON name),
+// the sections without a matching field are skipped
+func decodeResultField(decoder *json.Decoder, result interface{}, key string) error {
+	value := reflect.ValueOf(result)
+	if value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+		switch value.Kind() {
+		case reflect.Struct:
+			if index, ok := resultFieldIndex(value.Type(), key); ok {
+				return decoder.Decode(value.FieldByIndex(index).Addr().Interface())
+			}
+		case reflect.Map:
+			if value.Type().Key().Kind() == reflect.String {
+				element := reflect.New(value.Type().Elem())
+				if err := decoder.Decode(element.Interface()); err != nil {
+					return err
+				}
+				if value.IsNil() {
+					value.Set(reflect.MakeMap(value.Type()))
+				}
+				value.SetMapIndex(reflect.ValueOf(key).Convert(value.Type().Key()), element.Elem())
+				return nil
+			}
+		}
+	}
+	var skipped json.RawMessage
+	return decoder.Decode(&skipped)
+}
+
+// resultFieldIndex returns the index of the struct field with the JSON name (fields of embedded structs are promoted,
+// the shallower field wins, the name is matched case-insensitively if there is no exact match, like encoding/json does)
+func resultFieldIndex(structType reflect.Type, key string) ([]int, bool) {
+	var fields map[string][]int
+	if cached, ok := resultFieldsCache.Load(structType); ok {
+		fields = cached.(map[string][]int)
+	} else {
+		fields = make(map[string][]int)
+		collectResultFields(structType, nil, fields)
+		resultFieldsCache.Store(structType, fields)
+	}
+	if index, ok := fields[key]; ok {
+		return index, true
+	}
+	for name, index := range fields {
+		if strings.EqualFold(name, key) {
+			return index, true
+		}
+	}
+	return nil, false
+}
+
+func collectResultFields(structType reflect.Type, parentIndex []int, fields map[string][]int) {
+	var embedded []reflect.StructField
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		tag := structField.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if structField.Anonymous && len(name) == 0 && structField.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, structField)
+			continue
+		}
+		if len(structField.PkgPath) != 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = structField.Name
+		}
+		if _, exists := fields[name]; !exists {
+			fields[name] = append(append([]int{}, parentIndex...), i)
+		}
+	}
+	// fields of the embedded structs are collected after the own fields, so the own fields win
+	for _, structField := range embedded {
+		collectResultFields(structField.Type, append(append([]int{}, parentIndex...), structField.Index...), fields)
+	}
+}
+
+// startsWithObject returns true if the first non-whitespace byte of the reader starts a JSON object (nothing is consumed but whitespace)
+func startsWithObject(reader *bufio.Reader) bool {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return false
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		reader.UnreadByte()
+		return b == '{'
+	}
+}
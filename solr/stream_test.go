@@ -0,0 +1,274 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// largeResponseFixture returns a select response with the given number of documents and a facet section
+func largeResponseFixture(numDocs int) []byte {
+	var builder strings.Builder
+	builder.WriteString(`{"responseHeader":{"status":0,"QTime":12,"params":{"q":"*:*","rows":"` + fmt.Sprint(numDocs) + `"}},`)
+	builder.WriteString(`"response":{"numFound":` + fmt.Sprint(numDocs*10) + `,"start":0,"maxScore":1.0,"docs":[`)
+	for i := 0; i < numDocs; i++ {
+		if i > 0 {
+			builder.WriteString(",")
+		}
+		fmt.Fprintf(&builder, `{"id":"doc-%d","level":"INFO","host":"host%d","seq_num":%d,"logtime":"2018-11-10T10:00:00Z",`+
+			`"log_message":"Random message number %d with some text to make the document larger","tags":["a","b","c"]}`, i, i%10, i, i)
+	}
+	builder.WriteString(`]},"facet_counts":{"facet_queries":{},"facet_fields":{"level":["INFO",80,"ERROR",20]}},`)
+	builder.WriteString(`"highlighting":{"doc-1":{"log_message":["<em>Random</em> message"]}}}`)
+	return []byte(builder.String())
+}
+
+func TestDecodeResponseStream(t *testing.T) {
+	okBody := `{"responseHeader":{"status":0,"QTime":3},"response":{"numFound":2,"start":0,"docs":[{"id":"1"},{"id":"2"}]}}`
+	tests := []struct {
+		name       string
+		httpStatus int
+		body       string
+		limit      int64
+		check      func(t *testing.T, result *SolrResponseData, err error)
+	}{
+		{
+			name:       "success",
+			httpStatus: 200,
+			body:       okBody,
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.ResponseHeader.QTime != 3 || result.Response.NumFound != 2 || len(result.Response.Docs) != 2 {
+					t.Fatalf("unexpected result: %+v", result)
+				}
+			},
+		},
+		{
+			name:       "non-2xx error object",
+			httpStatus: 400,
+			body:       `{"responseHeader":{"status":400},"error":{"metadata":["error-class","org.apache.solr.common.SolrException"],"msg":"undefined field foo","code":400}}`,
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				var solrError *SolrError
+				if !errors.As(err, &solrError) {
+					t.Fatalf("expected SolrError, got: %v", err)
+				}
+				if solrError.HTTPStatus != 400 || solrError.Code != 400 || solrError.Msg != "undefined field foo" ||
+					solrError.ErrorClass() != "org.apache.solr.common.SolrException" {
+					t.Fatalf("unexpected error: %+v", solrError)
+				}
+			},
+		},
+		{
+			name:       "non-2xx non-JSON body",
+			httpStatus: 503,
+			body:       "<html><body>Service Unavailable</body></html>",
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				var solrError *SolrError
+				if !errors.As(err, &solrError) {
+					t.Fatalf("expected SolrError, got: %v", err)
+				}
+				if solrError.HTTPStatus != 503 || !strings.Contains(solrError.Msg, "Service Unavailable") {
+					t.Fatalf("unexpected error: %+v", solrError)
+				}
+			},
+		},
+		{
+			name:       "non-2xx body is capped without response size limit",
+			httpStatus: 500,
+			body:       strings.Repeat("x", maxErrorResponseLength*2),
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				var solrError *SolrError
+				if !errors.As(err, &solrError) {
+					t.Fatalf("expected SolrError, got: %v", err)
+				}
+				if len(solrError.Msg) != maxErrorBodyLength+len("...") {
+					t.Fatalf("unexpected error message length: %d", len(solrError.Msg))
+				}
+			},
+		},
+		{
+			name:       "non-JSON body",
+			httpStatus: 200,
+			body:       "Not JSON",
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				var solrError *SolrError
+				if !errors.As(err, &solrError) {
+					t.Fatalf("expected SolrError, got: %v", err)
+				}
+				if !strings.Contains(solrError.Msg, "unexpected non-JSON response: Not JSON") {
+					t.Fatalf("unexpected error: %+v", solrError)
+				}
+			},
+		},
+		{
+			name:       "truncated JSON body",
+			httpStatus: 200,
+			body:       okBody[:40],
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				var solrError *SolrError
+				if !errors.As(err, &solrError) || !strings.Contains(solrError.Msg, "invalid JSON response") {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name:       "size exactly at the limit",
+			httpStatus: 200,
+			body:       okBody,
+			limit:      int64(len(okBody)),
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(result.Response.Docs) != 2 {
+					t.Fatalf("unexpected result: %+v", result)
+				}
+			},
+		},
+		{
+			name:       "size one byte over the limit",
+			httpStatus: 200,
+			body:       okBody,
+			limit:      int64(len(okBody)) - 1,
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				if !errors.Is(err, ErrResponseTooLarge) {
+					t.Fatalf("expected ErrResponseTooLarge, got: %v", err)
+				}
+			},
+		},
+		{
+			name:       "error body over the limit",
+			httpStatus: 500,
+			body:       strings.Repeat("x", 100),
+			limit:      50,
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				if !errors.Is(err, ErrResponseTooLarge) {
+					t.Fatalf("expected ErrResponseTooLarge, got: %v", err)
+				}
+			},
+		},
+		{
+			name:       "null response section",
+			httpStatus: 200,
+			body:       `{"responseHeader":{"status":0},"response":null}`,
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.Response.NumFound != 0 || result.Response.Docs != nil {
+					t.Fatalf("unexpected result: %+v", result)
+				}
+			},
+		},
+		{
+			name:       "unknown sections are skipped",
+			httpStatus: 200,
+			body: `{"responseHeader":{"status":0},"debug":{"rawquerystring":"*:*","explain":{"1":"..."}},"unknown":[1,2,{"a":null}],` +
+				`"response":{"numFound":1,"docs":[{"id":"1"}],"numFoundExact":true},"nextCursorMark":"AoE=","facet_counts":{"facet_fields":{}}}`,
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.NextCursorMark != "AoE=" || len(result.Response.Docs) != 1 || result.FacetCounts["facet_fields"] == nil {
+					t.Fatalf("unexpected result: %+v", result)
+				}
+			},
+		},
+		{
+			name:       "response header status",
+			httpStatus: 200,
+			body:       `{"responseHeader":{"status":500}}`,
+			check: func(t *testing.T, result *SolrResponseData, err error) {
+				var solrError *SolrError
+				if !errors.As(err, &solrError) || solrError.Code != 500 {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var result SolrResponseData
+			err := decodeResponseStream(test.httpStatus, newResponseReader(strings.NewReader(test.body), test.limit), &result)
+			test.check(t, &result, err)
+		})
+	}
+}
+
+func TestDecodeResponseStreamResultTypes(t *testing.T) {
+	body := `{"responseHeader":{"status":0,"QTime":5},"cluster":{"live_nodes":["host1:8983_solr"]},"response":{"numFound":1,"docs":[{"id":"1"}]}}`
+
+	var embedded struct {
+		SolrResponseData
+		Cluster struct {
+			LiveNodes []string `json:"live_nodes"`
+		} `json:"cluster"`
+	}
+	if err := decodeResponseStream(200, newResponseReader(strings.NewReader(body), 0), &embedded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if embedded.ResponseHeader.QTime != 5 || embedded.Response.NumFound != 1 || len(embedded.Cluster.LiveNodes) != 1 {
+		t.Fatalf("unexpected result: %+v", embedded)
+	}
+
+	var sections map[string]interface{}
+	if err := decodeResponseStream(200, newResponseReader(strings.NewReader(body), 0), &sections); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sections) != 3 || sections["cluster"] == nil {
+		t.Fatalf("unexpected result: %v", sections)
+	}
+
+	if err := decodeResponseStream(200, newResponseReader(strings.NewReader(body), 0), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func BenchmarkDecodeResponse(b *testing.B) {
+	body := largeResponseFixture(5000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bodyBytes, err := ioutil.ReadAll(bytes.NewReader(body))
+		if err != nil {
+			b.Fatal(err)
+		}
+		var result SolrResponseData
+		if err := decodeResponse(200, bodyBytes, &result); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeResponseStream(b *testing.B) {
+	body := largeResponseFixture(5000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var result SolrResponseData
+		if err := decodeResponseStream(200, newResponseReader(bytes.NewReader(body), 0), &result); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
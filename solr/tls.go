@@ -0,0 +1,104 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultConnectTimeoutSeconds is used for dialing Solr if no connect timeout is configured
+const defaultConnectTimeoutSeconds = 30
+
+// defaultResponseHeaderTimeoutSeconds is used for waiting on the response headers if no response header timeout is configured,
+// it does not limit reading the body, so it can be kept low even for streamed exports; slow queries or commits need a higher one
+const defaultResponseHeaderTimeoutSeconds = 10
+
+// newHTTPTransport create the HTTP transport for Solr requests based on the connection and TLS configurations
+func newHTTPTransport(solrConfig *SolrConfig) (*http.Transport, error) {
+	connectTimeout := time.Duration(solrConfig.ConnectTimeoutSeconds) * time.Second
+	if solrConfig.ConnectTimeoutSeconds <= 0 {
+		connectTimeout = defaultConnectTimeoutSeconds * time.Second
+	}
+	responseHeaderTimeout := time.Duration(solrConfig.ResponseHeaderTimeoutSeconds) * time.Second
+	if solrConfig.ResponseHeaderTimeoutSeconds <= 0 {
+		responseHeaderTimeout = defaultResponseHeaderTimeoutSeconds * time.Second
+	}
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+	}
+	if solrConfig.TlsConfig.Enabled || solrConfig.Insecure {
+		tlsConfig, err := newTLSClientConfig(solrConfig)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport, nil
+}
+
+// newTLSClientConfig create TLS client configuration: custom CA bundle, client certificate for mutual TLS,
+// server name override and skipping server certificate verification (insecure)
+func newTLSClientConfig(solrConfig *SolrConfig) (*tls.Config, error) {
+	tlsSettings := solrConfig.TlsConfig
+	if tlsSettings.Enabled {
+		for _, solrUrl := range solrConfig.nodeUrls() {
+			if strings.HasPrefix(strings.ToLower(solrUrl), "http://") {
+				return nil, fmt.Errorf("TLS is enabled but Solr url is not https: %s", solrUrl)
+			}
+		}
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         tlsSettings.ServerName,
+		InsecureSkipVerify: solrConfig.Insecure,
+	}
+	if len(tlsSettings.CACertPath) != 0 {
+		caCertContent, err := ioutil.ReadFile(tlsSettings.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA certificate file: %v", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caCertContent) {
+			return nil, fmt.Errorf("no valid PEM certificate found in CA certificate file: %s", tlsSettings.CACertPath)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	if len(tlsSettings.CertPath) != 0 || len(tlsSettings.KeyPath) != 0 {
+		if len(tlsSettings.CertPath) == 0 || len(tlsSettings.KeyPath) == 0 {
+			return nil, fmt.Errorf("both client certificate and key are required for mutual TLS")
+		}
+		clientCert, err := tls.LoadX509KeyPair(tlsSettings.CertPath, tlsSettings.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeClientCertificate write a self-signed client certificate and its key as PEM files
+func writeClientCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "solr-client"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	writePEM(t, certPath, "CERTIFICATE", certDER)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	return certPath, keyPath
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewHTTPTransportTimeouts(t *testing.T) {
+	transport, err := newHTTPTransport(&SolrConfig{Url: "http://localhost:8983"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if transport.ResponseHeaderTimeout != defaultResponseHeaderTimeoutSeconds*time.Second || transport.TLSClientConfig != nil {
+		t.Fatalf("unexpected default transport: %v, %v", transport.ResponseHeaderTimeout, transport.TLSClientConfig)
+	}
+	transport, err = newHTTPTransport(&SolrConfig{Url: "http://localhost:8983", ResponseHeaderTimeoutSeconds: 120})
+	if err != nil || transport.ResponseHeaderTimeout != 2*time.Minute {
+		t.Fatalf("unexpected response header timeout: %v, %v", transport.ResponseHeaderTimeout, err)
+	}
+}
+
+func TestNewHTTPTransportTLSSettings(t *testing.T) {
+	transport, err := newHTTPTransport(&SolrConfig{Url: "https://localhost:8984", TlsConfig: TLSConfig{Enabled: true, ServerName: "solr.example.com"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tlsConfig := transport.TLSClientConfig
+	if tlsConfig == nil || tlsConfig.ServerName != "solr.example.com" || tlsConfig.InsecureSkipVerify || tlsConfig.RootCAs != nil ||
+		len(tlsConfig.Certificates) != 0 {
+		t.Fatalf("unexpected TLS config: %+v", tlsConfig)
+	}
+
+	// insecure alone enables the TLS client config, the url is not checked
+	transport, err = newHTTPTransport(&SolrConfig{Url: "http://localhost:8983", Insecure: true})
+	if err != nil || transport.TLSClientConfig == nil || !transport.TLSClientConfig.InsecureSkipVerify {
+		t.Fatalf("expected insecure TLS config, got: %+v, %v", transport.TLSClientConfig, err)
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath := writeClientCertificate(t, dir)
+	transport, err = newHTTPTransport(&SolrConfig{Url: "https://localhost:8984",
+		TlsConfig: TLSConfig{Enabled: true, CertPath: certPath, KeyPath: keyPath}})
+	if err != nil || len(transport.TLSClientConfig.Certificates) != 1 {
+		t.Fatalf("expected the client certificate, got: %v", err)
+	}
+}
+
+func TestNewHTTPTransportTLSErrors(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeClientCertificate(t, dir)
+	invalidCA := filepath.Join(dir, "invalid-ca.pem")
+	ioutil.WriteFile(invalidCA, []byte("not a certificate"), 0600)
+	testCases := []struct {
+		name      string
+		solrUrls  []string
+		tlsConfig TLSConfig
+		expected  string
+	}{
+		{"only client certificate", nil, TLSConfig{Enabled: true, CertPath: certPath}, "both client certificate and key are required"},
+		{"only client key", nil, TLSConfig{Enabled: true, KeyPath: keyPath}, "both client certificate and key are required"},
+		{"key as certificate", nil, TLSConfig{Enabled: true, CertPath: keyPath, KeyPath: keyPath}, "cannot load client certificate"},
+		{"missing CA bundle", nil, TLSConfig{Enabled: true, CACertPath: filepath.Join(dir, "missing.pem")}, "cannot read CA certificate file"},
+		{"unreadable CA bundle", nil, TLSConfig{Enabled: true, CACertPath: dir}, "cannot read CA certificate file"},
+		{"invalid CA bundle", nil, TLSConfig{Enabled: true, CACertPath: invalidCA}, "no valid PEM certificate found"},
+		{"http url", []string{"http://localhost:8983"}, TLSConfig{Enabled: true}, "TLS is enabled but Solr url is not https: http://localhost:8983"},
+		{"one http url of many", []string{"https://solr1:8984", "HTTP://solr2:8983"}, TLSConfig{Enabled: true},
+			"TLS is enabled but Solr url is not https: HTTP://solr2:8983"},
+	}
+	for _, testCase := range testCases {
+		solrConfig := &SolrConfig{Url: "https://localhost:8984", Urls: testCase.solrUrls, TlsConfig: testCase.tlsConfig}
+		if _, err := newHTTPTransport(solrConfig); err == nil || !strings.Contains(err.Error(), testCase.expected) {
+			t.Errorf("%s: expected %q error, got: %v", testCase.name, testCase.expected, err)
+		}
+		if _, err := NewSolrClient(solrConfig); err == nil {
+			t.Errorf("%s: expected Solr client creation error", testCase.name)
+		}
+	}
+}
+
+func TestTLSQuery(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"responseHeader":{"status":0},"response":{"numFound":1,"start":0,"docs":[]}}`))
+	}))
+	t.Cleanup(server.Close)
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, caPath, "CERTIFICATE", server.Certificate().Raw)
+
+	testCases := []struct {
+		name      string
+		tlsConfig TLSConfig
+		insecure  bool
+		success   bool
+	}{
+		{"trusted CA bundle", TLSConfig{Enabled: true, CACertPath: caPath, ServerName: "example.com"}, false, true},
+		{"server name does not match", TLSConfig{Enabled: true, CACertPath: caPath, ServerName: "solr.example.org"}, false, false},
+		{"unknown CA", TLSConfig{Enabled: true}, false, false},
+		{"insecure", TLSConfig{Enabled: true}, true, true},
+	}
+	for _, testCase := range testCases {
+		solrClient, err := NewSolrClient(&SolrConfig{Url: server.URL, Collection: "test", TlsConfig: testCase.tlsConfig, Insecure: testCase.insecure})
+		if err != nil {
+			t.Fatalf("%s: cannot create Solr client: %v", testCase.name, err)
+		}
+		_, solrResponse, err := solrClient.Query(CreateSolrQuery())
+		solrClient.Close()
+		if testCase.success && (err != nil || solrResponse.Response.NumFound != 1) {
+			t.Errorf("%s: unexpected error: %v", testCase.name, err)
+		}
+		if !testCase.success && err == nil {
+			t.Errorf("%s: expected certificate verification error", testCase.name)
+		}
+	}
+}
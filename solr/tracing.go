@@ -0,0 +1,337 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TraceParentHeader is the W3C trace context header that is propagated to Solr
+	TraceParentHeader = "traceparent"
+	// TraceStateHeader is the W3C trace state header that is propagated to Solr
+	TraceStateHeader = "tracestate"
+
+	maxParamsSummaryLength = 512
+)
+
+// Tracer creates spans for the Solr calls, it can be injected with the WithTracer client option
+// (e.g. an adapter for an OpenTelemetry tracer, or the InMemoryTracer for tests)
+type Tracer interface {
+	// Start create a span, the parent is the span (or remote span context) of ctx
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span represents a single traced Solr call
+type Span interface {
+	SetAttribute(key string, value interface{})
+	SetError(err error)
+	SpanContext() SpanContext
+	End()
+}
+
+// SpanContext holds the W3C trace context of a span
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Sampled    bool
+	TraceState string
+}
+
+// NopTracer does not record spans, but propagates the trace context of the caller (if there is any) to Solr,
+// it is the default tracer of the Solr client
+type NopTracer struct{}
+
+type nopSpan struct {
+	spanContext SpanContext
+}
+
+// InMemoryTracer records the finished spans in memory, it can be used to check the spans without a live collector
+type InMemoryTracer struct {
+	mutex sync.Mutex
+	spans []RecordedSpan
+}
+
+// RecordedSpan is a span finished by the InMemoryTracer
+type RecordedSpan struct {
+	Name         string
+	SpanContext  SpanContext
+	ParentSpanID [8]byte
+	Attributes   map[string]interface{}
+	Err          error
+	StartTime    time.Time
+	EndTime      time.Time
+}
+
+type inMemorySpan struct {
+	tracer *InMemoryTracer
+	mutex  sync.Mutex
+	span   RecordedSpan
+	ended  bool
+}
+
+type spanContextKey struct{}
+
+type remoteSpanContextKey struct{}
+
+// WithTracer set the tracer of the Solr client
+func WithTracer(tracer Tracer) ClientOption {
+	return func(solrClient *SolrClient) {
+		solrClient.tracer = tracer
+	}
+}
+
+// ContextWithSpan returns a copy of ctx with the span, the Solr calls with that context are children of the span
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span of the context, or nil if there is no span
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanContextKey{}).(Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx with a span context received from another process (e.g. from an incoming traceparent header)
+func ContextWithRemoteSpanContext(ctx context.Context, spanContext SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanContextKey{}, spanContext)
+}
+
+// SpanContextFromContext returns the span context of the span of ctx, or the remote span context if there is no span
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	spanContext, _ := ctx.Value(remoteSpanContextKey{}).(SpanContext)
+	return spanContext
+}
+
+// IsValid returns true if both trace and span ids are set
+func (spanContext SpanContext) IsValid() bool {
+	return spanContext.TraceID != [16]byte{} && spanContext.SpanID != [8]byte{}
+}
+
+// TraceParent returns the W3C traceparent header value of the span context
+func (spanContext SpanContext) TraceParent() string {
+	flags := 0
+	if spanContext.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(spanContext.TraceID[:]), hex.EncodeToString(spanContext.SpanID[:]), flags)
+}
+
+// ParseTraceParent parse a W3C traceparent header value
+func ParseTraceParent(traceParent string) (SpanContext, error) {
+	spanContext := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return spanContext, fmt.Errorf("invalid traceparent: %s", traceParent)
+	}
+	for _, part := range parts[:4] {
+		if !isLowerHex(part) {
+			return spanContext, fmt.Errorf("invalid traceparent (not lowercase hex): %s", traceParent)
+		}
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return spanContext, fmt.Errorf("invalid traceparent: %s", traceParent)
+	}
+	if _, err := hex.Decode(spanContext.TraceID[:], []byte(parts[1])); err != nil {
+		return spanContext, fmt.Errorf("invalid trace id in traceparent: %s", traceParent)
+	}
+	if _, err := hex.Decode(spanContext.SpanID[:], []byte(parts[2])); err != nil {
+		return spanContext, fmt.Errorf("invalid span id in traceparent: %s", traceParent)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return spanContext, fmt.Errorf("invalid trace flags in traceparent: %s", traceParent)
+	}
+	if !spanContext.IsValid() {
+		return spanContext, fmt.Errorf("invalid traceparent (zero trace or span id): %s", traceParent)
+	}
+	spanContext.Sampled = flags[0]&1 == 1
+	return spanContext, nil
+}
+
+// Start returns a non-recording span with the span context of ctx
+func (NopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{spanContext: SpanContextFromContext(ctx)}
+}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+
+func (nopSpan) SetError(err error) {}
+
+func (span nopSpan) SpanContext() SpanContext {
+	return span.spanContext
+}
+
+func (nopSpan) End() {}
+
+// NewInMemoryTracer create a tracer that keeps the finished spans in memory
+func NewInMemoryTracer() *InMemoryTracer {
+	return &InMemoryTracer{}
+}
+
+// Start create a recording span, a new trace is started if ctx has no span context
+func (tracer *InMemoryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent := SpanContextFromContext(ctx)
+	spanContext := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		spanContext.TraceID = parent.TraceID
+		spanContext.Sampled = parent.Sampled
+		spanContext.TraceState = parent.TraceState
+	} else {
+		rand.Read(spanContext.TraceID[:])
+	}
+	rand.Read(spanContext.SpanID[:])
+	span := &inMemorySpan{tracer: tracer, span: RecordedSpan{Name: name, SpanContext: spanContext, ParentSpanID: parent.SpanID,
+		Attributes: make(map[string]interface{}), StartTime: time.Now()}}
+	return ContextWithSpan(ctx, span), span
+}
+
+// Spans returns the finished spans
+func (tracer *InMemoryTracer) Spans() []RecordedSpan {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	return append([]RecordedSpan(nil), tracer.spans...)
+}
+
+// Reset drop the finished spans
+func (tracer *InMemoryTracer) Reset() {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	tracer.spans = nil
+}
+
+func (span *inMemorySpan) SetAttribute(key string, value interface{}) {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.span.Attributes[key] = value
+}
+
+func (span *inMemorySpan) SetError(err error) {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.span.Err = err
+}
+
+func (span *inMemorySpan) SpanContext() SpanContext {
+	return span.span.SpanContext
+}
+
+func (span *inMemorySpan) End() {
+	span.mutex.Lock()
+	if span.ended {
+		span.mutex.Unlock()
+		return
+	}
+	span.ended = true
+	span.span.EndTime = time.Now()
+	recorded := span.span
+	span.mutex.Unlock()
+	span.tracer.mutex.Lock()
+	defer span.tracer.mutex.Unlock()
+	span.tracer.spans = append(span.tracer.spans, recorded)
+}
+
+// startSpan start the span of a Solr call with the request attributes
+func (solrClient *SolrClient) startSpan(ctx context.Context, solrRequest *solrRequest) (context.Context, Span) {
+	ctx, span := solrClient.tracer.Start(ctx, fmt.Sprintf("solr %s", solrRequest.operation))
+	ctx = ContextWithSpan(ctx, span)
+	span.SetAttribute("db.system", "solr")
+	span.SetAttribute("db.operation", string(solrRequest.operation))
+	span.SetAttribute("solr.collection", solrClient.solrConfig.Collection)
+	span.SetAttribute("solr.handler", solrRequest.handlerPath(solrClient.solrConfig))
+	span.SetAttribute("solr.params", paramsSummary(solrRequest))
+	return ctx, span
+}
+
+// endSpan record the response (or error) attributes of a Solr call and end its span
+func endSpan(span Span, result interface{}, err error) {
+	if err != nil {
+		span.SetError(err)
+		if solrError := AsSolrError(err); solrError != nil {
+			span.SetAttribute("http.response.status_code", solrError.HTTPStatus)
+			span.SetAttribute("solr.status", solrError.Code)
+		}
+		if attempts := Attempts(err); attempts > 1 {
+			span.SetAttribute("solr.attempts", attempts)
+		}
+	} else {
+		switch solrResponse := result.(type) {
+		case *SolrResponseData:
+			setResponseAttributes(span, solrResponse.ResponseHeader, solrResponse.Response.NumFound)
+		case *documentsResponseData:
+			setResponseAttributes(span, solrResponse.ResponseHeader, solrResponse.Response.NumFound)
+		}
+	}
+	span.End()
+}
+
+func setResponseAttributes(span Span, responseHeader SolrResponseHeader, numFound int64) {
+	span.SetAttribute("solr.status", int(responseHeader.Status))
+	span.SetAttribute("solr.qtime", int(responseHeader.QTime))
+	span.SetAttribute("solr.num_found", numFound)
+}
+
+// isLowerHex returns true if the value contains lowercase hex digits only (as the W3C trace context requires)
+func isLowerHex(value string) bool {
+	for _, char := range value {
+		if (char < '0' || char > '9') && (char < 'a' || char > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// injectTraceContext set the W3C trace context headers of the span of ctx on the request
+func injectTraceContext(ctx context.Context, request *http.Request) {
+	spanContext := SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return
+	}
+	request.Header.Set(TraceParentHeader, spanContext.TraceParent())
+	if len(spanContext.TraceState) != 0 {
+		request.Header.Set(TraceStateHeader, spanContext.TraceState)
+	}
+}
+
+// paramsSummary returns the sorted query parameters of the request (truncated), without the response writer params
+func paramsSummary(solrRequest *solrRequest) string {
+	keys := make([]string, 0, len(solrRequest.params))
+	for key := range solrRequest.params {
+		if key != "wt" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%s", key, strings.Join(solrRequest.params[key], ",")))
+	}
+	summary := strings.Join(parts, "&")
+	if len(summary) > maxParamsSummaryLength {
+		summary = summary[:maxParamsSummaryLength] + "..."
+	}
+	return summary
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 Oliver Szabo
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package solr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const validTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// traceParentServer is a stand-in Solr node that records the traceparent headers, and fails the first failures requests with 503
+type traceParentServer struct {
+	*httptest.Server
+	mutex        sync.Mutex
+	traceParents []string
+	failures     int
+}
+
+func newTraceParentServer(t *testing.T, failures int) *traceParentServer {
+	server := &traceParentServer{failures: failures}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		server.traceParents = append(server.traceParents, r.Header.Get(TraceParentHeader))
+		fail := len(server.traceParents) <= server.failures
+		server.mutex.Unlock()
+		if fail {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"responseHeader":{"status":0,"QTime":7},"response":{"numFound":42,"start":0,"docs":[]}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *traceParentServer) headers() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string(nil), server.traceParents...)
+}
+
+func newTracedTestClient(t *testing.T, solrConfig *SolrConfig, tracer Tracer) *SolrClient {
+	solrClient, err := NewSolrClient(solrConfig, WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("cannot create Solr client: %v", err)
+	}
+	t.Cleanup(solrClient.Close)
+	return solrClient
+}
+
+func TestParseTraceParent(t *testing.T) {
+	spanContext, err := ParseTraceParent(validTraceParent)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spanContext.TraceParent() != validTraceParent || !spanContext.Sampled {
+		t.Fatalf("unexpected span context: %+v", spanContext)
+	}
+	// future versions can have more fields
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); err != nil {
+		t.Fatalf("unexpected error for a future version: %v", err)
+	}
+
+	invalid := map[string]string{
+		"empty":                 "",
+		"forbidden version":     "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"non-hex version":       "zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"long version":          "000-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"extra field of v00":    validTraceParent + "-extra",
+		"missing field":         "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"short trace id":        "00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"long span id":          "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b70-01",
+		"short flags":           "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		"uppercase trace id":    "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"non-hex span id":       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bx-01",
+		"all-zero trace id":     "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"all-zero span id":      "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"non-hex trace flags":   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g",
+		"wrong field separator": "00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	}
+	for name, traceParent := range invalid {
+		if _, err := ParseTraceParent(traceParent); err == nil {
+			t.Errorf("%s: ParseTraceParent(%q) should fail", name, traceParent)
+		}
+	}
+}
+
+func TestTraceParentInjected(t *testing.T) {
+	server := newTraceParentServer(t, 0)
+	tracer := NewInMemoryTracer()
+	solrClient := newTracedTestClient(t, &SolrConfig{Url: server.URL, Collection: "test"}, tracer)
+
+	remote, _ := ParseTraceParent(validTraceParent)
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+	if _, _, err := solrClient.QueryContext(ctx, CreateSolrQuery()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spans := tracer.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got: %d", len(spans))
+	}
+	span := spans[0]
+	if span.SpanContext.TraceID != remote.TraceID || span.ParentSpanID != remote.SpanID {
+		t.Fatalf("the span should continue the remote trace: %+v", span)
+	}
+	headers := server.headers()
+	if len(headers) != 1 || headers[0] != span.SpanContext.TraceParent() {
+		t.Fatalf("the traceparent of the span should be sent, got: %v, expected: %s", headers, span.SpanContext.TraceParent())
+	}
+	if span.Attributes["solr.collection"] != "test" || span.Attributes["solr.num_found"] != int64(42) || span.Attributes["solr.qtime"] != 7 {
+		t.Fatalf("unexpected span attributes: %v", span.Attributes)
+	}
+}
+
+func TestOneSpanPerRequestWithRetries(t *testing.T) {
+	server := newTraceParentServer(t, 2)
+	tracer := NewInMemoryTracer()
+	retryPolicy := DefaultRetryPolicy()
+	retryPolicy.InitialBackoff = time.Millisecond
+	retryPolicy.MaxBackoff = time.Millisecond
+	solrClient := newTracedTestClient(t, &SolrConfig{Url: server.URL, Collection: "test", RetryPolicy: retryPolicy}, tracer)
+
+	if _, _, err := solrClient.Query(CreateSolrQuery()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spans := tracer.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span for the retried request, got: %d", len(spans))
+	}
+	headers := server.headers()
+	if len(headers) != 3 {
+		t.Fatalf("expected 3 attempts, got: %d", len(headers))
+	}
+	for _, header := range headers {
+		if header != spans[0].SpanContext.TraceParent() {
+			t.Fatalf("every attempt should send the traceparent of the span: %v", headers)
+		}
+	}
+}
+
+func TestOneSpanPerRequestWithFailover(t *testing.T) {
+	deadServer := httptest.NewServer(http.NotFoundHandler())
+	deadUrl := deadServer.URL
+	deadServer.Close()
+	server := newTraceParentServer(t, 0)
+	tracer := NewInMemoryTracer()
+	solrConfig := &SolrConfig{Urls: []string{deadUrl, server.URL}, Collection: "test"}
+	solrClient := newTracedTestClient(t, solrConfig, tracer)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := solrClient.Query(CreateSolrQuery()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if spans := tracer.Spans(); len(spans) != 2 {
+		t.Fatalf("expected one span per request, got: %d", len(spans))
+	}
+	if headers := server.headers(); len(headers) != 2 || !strings.HasPrefix(headers[0], "00-") {
+		t.Fatalf("unexpected traceparent headers: %v", headers)
+	}
+
+	tracer.Reset()
+	server.Close()
+	if _, _, err := solrClient.Query(CreateSolrQuery()); err == nil {
+		t.Fatalf("expected error without live nodes")
+	}
+	spans := tracer.Spans()
+	if len(spans) != 1 || spans[0].Err == nil {
+		t.Fatalf("expected one failed span, got: %+v", spans)
+	}
+}
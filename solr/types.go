@@ -15,19 +15,20 @@
 package solr
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/url"
-
-	"gopkg.in/jcmturner/gokrb5.v4/client"
+	"sync"
 )
 
 // KerberosConfig holds kerberos related configurations
 type KerberosConfig struct {
-	keytab         string
-	principal      string
-	realm          string
-	krb5confPath   string
-	kerberosClient *client.Client
+	keytab           string
+	principal        string
+	realm            string
+	krb5confPath     string
+	ccachePath       string
+	servicePrincipal string
 }
 
 // BasicAuthConfig hold authentication credentials
@@ -40,39 +41,96 @@ type BasicAuthConfig struct {
 type SecurityConfig struct {
 	kerberosEnabled  bool
 	basicAuthEnabled bool
+	jwtEnabled       bool
 	kerberosConfig   *KerberosConfig
 	basicAuthConfig  *BasicAuthConfig
+	jwtConfig        *JWTConfig
 }
 
 // TLSConfig holds TLS related configurations
 type TLSConfig struct {
-	cert    string
-	enabled bool
+	Enabled    bool
+	CACertPath string
+	CertPath   string
+	KeyPath    string
+	ServerName string
 }
 
 // SolrConfig holds Solr related configurations
 type SolrConfig struct {
-	Url                   string
-	Collection            string
-	SecurityConfig        *SecurityConfig
-	SolrUrlContext        string
-	TlsConfig             TLSConfig
-	Insecure              bool
-	ConnectTimeoutSeconds int
+	Url                          string
+	Collection                   string
+	SecurityConfig               *SecurityConfig
+	SolrUrlContext               string
+	TlsConfig                    TLSConfig
+	Insecure                     bool
+	ConnectTimeoutSeconds        int
+	ResponseHeaderTimeoutSeconds int
+	Urls                         []string
+	LoadBalancerConfig           LoadBalancerConfig
+	CloudConfig                  *CloudConfig
+	UniqueKey                    string
+	RetryPolicy                  *RetryPolicy
+	CircuitBreakerConfig         *CircuitBreakerConfig
+	MaxResponseSizeBytes         int64
+}
+
+// LoadBalancerConfig holds load balancing and failover related configurations (used if multiple Solr urls are configured)
+type LoadBalancerConfig struct {
+	Strategy                   LoadBalancerStrategy
+	HealthCheckIntervalSeconds int
+}
+
+// CloudConfig holds SolrCloud related configurations, if ZooKeeper hosts are not set (or ZooKeeper is not reachable),
+// the cluster state is gathered with the Collections API (CLUSTERSTATUS) from the configured Solr urls
+type CloudConfig struct {
+	ZkHosts                 []string
+	ZkChroot                string
+	ZkSessionTimeoutSeconds int
+	RefreshIntervalSeconds  int
 }
 
 // SolrClient represents a Solr connection that is used to communicate with Solr HTTP endpoints
 type SolrClient struct {
-	solrConfig *SolrConfig
-	httpClient *http.Client
+	solrConfig           *SolrConfig
+	httpClient           *http.Client
+	authenticator        Authenticator
+	middlewares          []Middleware
+	authMiddlewareIndex  int
+	roundTrip            RoundTripFunc
+	logger               Logger
+	metrics              Metrics
+	tracer               Tracer
+	nodes                *nodePool
+	clusterStateProvider ClusterStateProvider
+	cloud                *cloudState
+	breakers             *circuitBreakers
+	kerberosSession      *kerberosSession
+	stop                 chan struct{}
+	stopOnce             sync.Once
 }
 
 // SolrResponseData represents Solr response data that contains the response itself and the response header as well
 type SolrResponseData struct {
-	ResponseHeader SolrResponseHeader     `json:"responseHeader"`
-	Response       SolrResponse           `json:"response"`
-	FacetCounts    map[string]interface{} `json:"facet_counts,omitempty"`
-	Highlighting   map[string]interface{} `json:"highlighting,omitempty"`
+	ResponseHeader SolrResponseHeader        `json:"responseHeader"`
+	Response       SolrResponse              `json:"response"`
+	FacetCounts    map[string]interface{}    `json:"facet_counts,omitempty"`
+	Highlighting   map[string]interface{}    `json:"highlighting,omitempty"`
+	Error          *SolrErrorData            `json:"error,omitempty"`
+	NextCursorMark string                    `json:"nextCursorMark,omitempty"`
+	Facets         *FacetResult              `json:"facets,omitempty"`
+	Grouped        map[string]*GroupedResult `json:"grouped,omitempty"`
+	Expanded       map[string]SolrResponse   `json:"expanded,omitempty"`
+	Stats          *StatsResult              `json:"stats,omitempty"`
+	facetCounts    *FacetCounts
+}
+
+// SolrErrorData represents the error object of a failed Solr response
+type SolrErrorData struct {
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Msg      string          `json:"msg,omitempty"`
+	Trace    string          `json:"trace,omitempty"`
+	Code     int             `json:"code,omitempty"`
 }
 
 // SolrDocument represents a Solr document (document map)
@@ -83,8 +141,8 @@ type SolrDocuments []SolrDocument
 
 // SolrResponse represents a Solr HTTP response
 type SolrResponse struct {
-	NumFound int32          `json:"numFound,omitempty"`
-	Start    int32          `json:"start,omitempty"`
+	NumFound int64          `json:"numFound,omitempty"`
+	Start    int64          `json:"start,omitempty"`
 	MaxScore float32        `json:"maxScore,omitempty"`
 	Docs     []SolrDocument `json:"docs,omitempty"`
 }